@@ -0,0 +1,106 @@
+// Package fmtcmd is the command line shared by the formatters of the
+// calculators. Given .calc files it writes each of them formatted to
+// stdout, or rewrites or lists the ones that are not formatted. Without
+// files it formats stdin.
+package fmtcmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Formatter formats programs and reports their errors
+type Formatter struct {
+	List   bool //list the files that are not formatted instead of printing them
+	Write  bool //rewrite the files that are not formatted
+	Out    io.Writer
+	ErrOut io.Writer
+
+	//Format formats a program, or fails with the errors that stop it
+	Format func(src string) (string, error)
+	//Diagnostics renders each error of err against the program src, one
+	//string per error ending in a newline
+	Diagnostics func(src string, err error) []string
+}
+
+// format formats src, which was read from name, and reports whether it
+// could be parsed
+func (f *Formatter) format(name string, src string) (string, bool) {
+	res, err := f.Format(src)
+	if err == nil {
+		return res, true
+	}
+	for _, d := range f.Diagnostics(src, err) {
+		fmt.Fprintf(f.ErrOut, "%s: %s", name, d)
+	}
+	return "", false
+}
+
+// FormatFile formats file as the flags ask and reports whether it
+// succeeded
+func (f *Formatter) FormatFile(file string) bool {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(f.ErrOut, err)
+		return false
+	}
+	res, ok := f.format(file, string(src))
+	if !ok {
+		return false
+	}
+
+	changed := res != string(src)
+	if f.List && changed {
+		fmt.Fprintln(f.Out, file)
+	}
+	if f.Write && changed {
+		if err := ioutil.WriteFile(file, []byte(res), 0644); err != nil {
+			fmt.Fprintln(f.ErrOut, err)
+			return false
+		}
+	}
+	if !f.List && !f.Write {
+		fmt.Fprint(f.Out, res)
+	}
+	return true
+}
+
+// Main runs the command named name with the -l and -w flags, it formats
+// with f.Format and exits with status 1 when a program fails to format
+func Main(name string, f *Formatter) {
+	flag.BoolVar(&f.List, "l", false, "list the files whose formatting differs from "+name+"'s")
+	flag.BoolVar(&f.Write, "w", false, "write the result to the file instead of stdout")
+	flag.Parse()
+	f.Out, f.ErrOut = os.Stdout, os.Stderr
+
+	if flag.NArg() == 0 {
+		if f.Write {
+			fmt.Fprintf(f.ErrOut, "%s: cannot use -w with stdin\n", name)
+			os.Exit(2)
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(f.ErrOut, err)
+			os.Exit(1)
+		}
+		res, ok := f.format("<stdin>", string(src))
+		if !ok {
+			os.Exit(1)
+		}
+		fmt.Fprint(f.Out, res)
+		return
+	}
+
+	failed := false
+	for _, file := range flag.Args() {
+		if !f.FormatFile(file) {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
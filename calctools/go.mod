@@ -0,0 +1,7 @@
+module calctools
+
+go 1.14
+
+require lexer v0.0.0
+
+replace lexer => ../lexer
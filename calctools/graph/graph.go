@@ -0,0 +1,71 @@
+// Package graph draws the ast of a calculator as a Graphviz DOT graph or a
+// Mermaid flowchart. A calculator adds the labels of its nodes and the
+// edges to their children, the graph writes and escapes them.
+package graph
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Graph is an ast as labelled nodes and the edges from each node to its
+// children, numbered in the order they are added
+type Graph struct {
+	labels []string
+	edges  []edge
+}
+
+type edge struct {
+	from, to int
+	label    string //what the child is to its parent, e.g. then, or ""
+}
+
+// Add adds a node labelled label below parent along an edge labelled
+// edgeLabel and returns its number. The root has parent -1.
+func (g *Graph) Add(label string, parent int, edgeLabel string) int {
+	id := len(g.labels)
+	g.labels = append(g.labels, label)
+	if parent >= 0 {
+		g.edges = append(g.edges, edge{from: parent, to: id, label: edgeLabel})
+	}
+	return id
+}
+
+// WriteDOT writes g to w as a Graphviz DOT graph, e.g. for `dot -Tsvg`
+func (g *Graph) WriteDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph ast {")
+	fmt.Fprintln(w, "\tnode [shape=box, fontname=monospace];")
+	for i, l := range g.labels {
+		fmt.Fprintf(w, "\tn%d [label=\"%s\"];\n", i, dotEscaper.Replace(l))
+	}
+	for _, e := range g.edges {
+		if e.label != "" {
+			fmt.Fprintf(w, "\tn%d -> n%d [label=\"%s\"];\n", e.from, e.to, dotEscaper.Replace(e.label))
+			continue
+		}
+		fmt.Fprintf(w, "\tn%d -> n%d;\n", e.from, e.to)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// WriteMermaid writes g to w as a Mermaid flowchart
+func (g *Graph) WriteMermaid(w io.Writer) {
+	fmt.Fprintln(w, "flowchart TD")
+	for i, l := range g.labels {
+		fmt.Fprintf(w, "\tn%d[\"%s\"]\n", i, mermaidEscaper.Replace(l))
+	}
+	for _, e := range g.edges {
+		if e.label != "" {
+			fmt.Fprintf(w, "\tn%d -->|\"%s\"| n%d\n", e.from, mermaidEscaper.Replace(e.label), e.to)
+			continue
+		}
+		fmt.Fprintf(w, "\tn%d --> n%d\n", e.from, e.to)
+	}
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// mermaidEscaper writes the characters mermaid could read as markup as
+// entity codes
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "#", "#35;")
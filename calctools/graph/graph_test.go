@@ -0,0 +1,39 @@
+package graph
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGraph(t *testing.T) {
+	g := &Graph{}
+	root := g.Add(`if "a"`, -1, "")
+	g.Add(`a\b`, root, "cond")
+	g.Add("a < #1", root, "")
+
+	var dot bytes.Buffer
+	g.WriteDOT(&dot)
+	expected := "digraph ast {\n" +
+		"\tnode [shape=box, fontname=monospace];\n" +
+		"\tn0 [label=\"if \\\"a\\\"\"];\n" +
+		"\tn1 [label=\"a\\\\b\"];\n" +
+		"\tn2 [label=\"a < #1\"];\n" +
+		"\tn0 -> n1 [label=\"cond\"];\n" +
+		"\tn0 -> n2;\n" +
+		"}\n"
+	if dot.String() != expected {
+		t.Errorf("expected dot\n%s\ngot\n%s", expected, dot.String())
+	}
+
+	var mermaid bytes.Buffer
+	g.WriteMermaid(&mermaid)
+	expected = "flowchart TD\n" +
+		"\tn0[\"if #quot;a#quot;\"]\n" +
+		"\tn1[\"a\\b\"]\n" +
+		"\tn2[\"a #lt; #35;1\"]\n" +
+		"\tn0 -->|\"cond\"| n1\n" +
+		"\tn0 --> n2\n"
+	if mermaid.String() != expected {
+		t.Errorf("expected mermaid\n%s\ngot\n%s", expected, mermaid.String())
+	}
+}
@@ -0,0 +1,76 @@
+// Package layout is the writer under the formatters of the calculators. It
+// indents the lines of a program and places the comments of its source
+// around them, the formatters write the statements themselves.
+package layout
+
+import (
+	"fmt"
+	"io"
+	"lexer"
+	"strings"
+)
+
+// Writer writes source a line at a time, indenting blocks with a tab for
+// each level of nesting
+type Writer struct {
+	w        io.Writer
+	comments []*lexer.Token //the comments still to be written
+	Depth    int            //the nesting of the block being written
+	Line     int            //the last line of the source written
+}
+
+// NewWriter returns a Writer to w that places comments, which are in the
+// order of the source, around the lines it is given
+func NewWriter(w io.Writer, comments []*lexer.Token) *Writer {
+	return &Writer{w: w, comments: comments}
+}
+
+// Printf writes to the source like fmt.Fprintf
+func (w *Writer) Printf(format string, a ...interface{}) {
+	fmt.Fprintf(w.w, format, a...)
+}
+
+// Indent writes the indentation of a line at the current depth
+func (w *Writer) Indent() {
+	w.Printf("%s", strings.Repeat("\t", w.Depth))
+}
+
+// Gap writes the blank line that separates what starts on line from what
+// was written before, many blank lines in the source become one. Nothing
+// has been written at the start of a program or block.
+func (w *Writer) Gap(line int) {
+	if w.Line > 0 && line > w.Line+1 {
+		w.Printf("\n")
+	}
+}
+
+// HasComments reports whether there are comments left before pos
+func (w *Writer) HasComments(pos lexer.Position) bool {
+	return len(w.comments) > 0 && w.comments[0].Pos.Offset < pos.Offset
+}
+
+// CommentsBefore writes the comments before pos each on a line of its own
+func (w *Writer) CommentsBefore(pos lexer.Position) {
+	for w.HasComments(pos) {
+		c := w.comments[0]
+		w.comments = w.comments[1:]
+		w.Gap(c.Pos.Line)
+		w.Indent()
+		w.Printf("%s\n", c.Value)
+		w.Line = c.End.Line
+	}
+}
+
+// Trailing writes the comments that follow a line ending at end on the same
+// line, up to limit where the enclosing block ends
+func (w *Writer) Trailing(end lexer.Position, limit lexer.Position) {
+	for len(w.comments) > 0 {
+		c := w.comments[0]
+		if c.Pos.Line != end.Line || c.Pos.Offset < end.Offset || c.Pos.Offset >= limit.Offset {
+			return
+		}
+		w.comments = w.comments[1:]
+		w.Printf(" %s", c.Value)
+		w.Line = c.End.Line
+	}
+}
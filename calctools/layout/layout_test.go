@@ -0,0 +1,45 @@
+package layout
+
+import (
+	"lexer"
+	"strings"
+	"testing"
+)
+
+func comment(value string, line, offset int) *lexer.Token {
+	return &lexer.Token{
+		Value: value,
+		Pos:   lexer.Position{Offset: offset, Line: line},
+		End:   lexer.Position{Offset: offset + len(value), Line: line},
+	}
+}
+
+func TestWriter(t *testing.T) {
+	//"// one" on line 1, "b // two" on line 2 and "// three" on line 5
+	comments := []*lexer.Token{comment("// one", 1, 0), comment("// two", 2, 10), comment("// three", 5, 30)}
+	var sb strings.Builder
+	w := NewWriter(&sb, comments)
+	w.Depth = 1
+
+	w.CommentsBefore(lexer.Position{Offset: 8, Line: 2})
+	w.Gap(2)
+	w.Indent()
+	w.Printf("b")
+	w.Line = 2
+	//the block ends before // two
+	w.Trailing(lexer.Position{Offset: 9, Line: 2}, lexer.Position{Offset: 10})
+	if !w.HasComments(lexer.Position{Offset: 11}) {
+		t.Errorf("expected // two to be left after the end of the block")
+	}
+	w.Trailing(lexer.Position{Offset: 9, Line: 2}, lexer.Position{Offset: 100})
+	w.Printf("\n")
+	w.CommentsBefore(lexer.Position{Offset: 100})
+
+	expected := "\t// one\n\tb // two\n\n\t// three\n"
+	if sb.String() != expected {
+		t.Errorf("expected\n%q\ngot\n%q", expected, sb.String())
+	}
+	if w.HasComments(lexer.Position{Offset: 100}) {
+		t.Errorf("expected all comments to be written")
+	}
+}
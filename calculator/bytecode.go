@@ -0,0 +1,210 @@
+package calculator
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// opcode is the first byte of an instruction. Operands follow it, a one
+// byte argument count or a two byte big endian index or offset.
+type opcode byte
+
+const (
+	opConst    opcode = iota //push consts[u16]
+	opZero                   //push 0, the value of a statement
+	opPop                    //drop the top of the stack
+	opLoad                   //push the variable refs[u16] resolves to
+	opStore                  //pop into slot u16 of the current frame
+	opClosure                //push a function of protos[u16] closing over the current frame
+	opPrint                  //pop and print
+	opCheckInt               //fail unless the top of the stack is a number
+	opNeg                    //negate the top of the stack
+	opAdd                    //the binary operators pop the rhs and then the lhs
+	opSub
+	opMul
+	opDiv
+	opEq
+	opNeq
+	opLt
+	opGt
+	opLte
+	opGte
+	opBitOr
+	opBitXor
+	opBitAnd
+	opShl
+	opShr
+	opPow        //pop the base and then the exponent, see compiler.power
+	opJump       //jump forward u16 bytes
+	opJumpIfZero //pop and jump forward u16 bytes if it is 0
+	opPushFrame  //enter a block with u16 slots
+	opPopFrame   //leave a block
+	opCallable   //fail unless the top of the stack is a function taking u8 arguments
+	opCall       //call the function below the u8 arguments on the stack
+	opReturn     //return the top of the stack to the caller
+)
+
+var opcodeNames = map[opcode]string{
+	opConst:      "CONST",
+	opZero:       "ZERO",
+	opPop:        "POP",
+	opLoad:       "LOAD",
+	opStore:      "STORE",
+	opClosure:    "CLOSURE",
+	opPrint:      "PRINT",
+	opCheckInt:   "CHECKINT",
+	opNeg:        "NEG",
+	opAdd:        "ADD",
+	opSub:        "SUB",
+	opMul:        "MUL",
+	opDiv:        "DIV",
+	opEq:         "EQ",
+	opNeq:        "NEQ",
+	opLt:         "LT",
+	opGt:         "GT",
+	opLte:        "LTE",
+	opGte:        "GTE",
+	opBitOr:      "BITOR",
+	opBitXor:     "BITXOR",
+	opBitAnd:     "BITAND",
+	opShl:        "SHL",
+	opShr:        "SHR",
+	opPow:        "POW",
+	opJump:       "JUMP",
+	opJumpIfZero: "JUMPIFZERO",
+	opPushFrame:  "PUSHFRAME",
+	opPopFrame:   "POPFRAME",
+	opCallable:   "CALLABLE",
+	opCall:       "CALL",
+	opReturn:     "RETURN",
+}
+
+func (o opcode) String() string {
+	if s, ok := opcodeNames[o]; ok {
+		return s
+	}
+	return fmt.Sprintf("opcode(%d)", byte(o))
+}
+
+// operandSize is the number of bytes following each opcode
+func (o opcode) operandSize() int {
+	switch o {
+	case opConst, opLoad, opStore, opClosure, opJump, opJumpIfZero, opPushFrame:
+		return 2
+	case opCallable, opCall:
+		return 1
+	}
+	return 0
+}
+
+// binaryOps maps the operators applyOp knows to their opcodes
+var binaryOps = map[Op]opcode{
+	PLUS:     opAdd,
+	MINUS:    opSub,
+	MULTIPLY: opMul,
+	DIVIDE:   opDiv,
+	EQ:       opEq,
+	NEQ:      opNeq,
+	LT:       opLt,
+	GT:       opGt,
+	LTE:      opLte,
+	GTE:      opGte,
+	BITOR:    opBitOr,
+	BITXOR:   opBitXor,
+	BITAND:   opBitAnd,
+	LSHIFT:   opShl,
+	RSHIFT:   opShr,
+}
+
+// local is a slot of the frame depth blocks out from the current one
+type local struct {
+	depth int
+	slot  int
+}
+
+// ref is a resolved identifier. A frame only holds a variable once the
+// statement setting it has run, so a load tries each frame that may hold it,
+// innermost first, and then the global slot, like Frame.GetVar does.
+type ref struct {
+	name   string
+	locals []local
+	global int
+}
+
+// spanAt records the span of the instruction at offset, for the
+// instructions that can fail
+type spanAt struct {
+	offset int
+	span   Span
+}
+
+// Chunk is the bytecode of a program or of a function body, along with the
+// constants, functions and variables its instructions refer to by index
+type Chunk struct {
+	name   string
+	code   []byte
+	consts []int
+	protos []*proto
+	refs   []ref
+	spans  []spanAt
+}
+
+// proto is a compiled function. Its parameters and the variables its body
+// sets share one frame of slots.
+type proto struct {
+	name       string
+	params     int
+	paramSlots []int //the slot of each parameter, in order
+	slots      int
+	chunk      *Chunk
+}
+
+// span returns the span of the instruction at offset
+func (c *Chunk) span(offset int) Span {
+	i := sort.Search(len(c.spans), func(i int) bool { return c.spans[i].offset >= offset })
+	if i < len(c.spans) && c.spans[i].offset == offset {
+		return c.spans[i].span
+	}
+	return Span{}
+}
+
+func (c *Chunk) u16(offset int) int {
+	return int(c.code[offset])<<8 | int(c.code[offset+1])
+}
+
+// Disassemble writes the instructions of c, and then those of the
+// functions it declares, one per line
+func (c *Chunk) Disassemble(w io.Writer) {
+	fmt.Fprintf(w, "== %s ==\n", c.name)
+	for ip := 0; ip < len(c.code); {
+		op := opcode(c.code[ip])
+		switch op.operandSize() {
+		case 0:
+			fmt.Fprintf(w, "%04d %s", ip, op)
+		case 1:
+			fmt.Fprintf(w, "%04d %-10s", ip, op)
+			fmt.Fprintf(w, " %d", c.code[ip+1])
+		case 2:
+			fmt.Fprintf(w, "%04d %-10s", ip, op)
+			arg := c.u16(ip + 1)
+			switch op {
+			case opConst:
+				fmt.Fprintf(w, " %d (%d)", arg, c.consts[arg])
+			case opLoad:
+				fmt.Fprintf(w, " %d (%s)", arg, c.refs[arg].name)
+			case opClosure:
+				fmt.Fprintf(w, " %d (%s)", arg, c.protos[arg].name)
+			case opJump, opJumpIfZero:
+				fmt.Fprintf(w, " %d (to %04d)", arg, ip+3+arg)
+			default:
+				fmt.Fprintf(w, " %d", arg)
+			}
+		}
+		fmt.Fprintln(w)
+		ip += 1 + op.operandSize()
+	}
+	for _, p := range c.protos {
+		p.chunk.Disassemble(w)
+	}
+}
@@ -0,0 +1,306 @@
+// Command calc runs calculator programs. Given .calc files it runs them one
+// after another in the same environment, given a pipe or a file on stdin it
+// runs that, and on a terminal it starts a read eval print loop.
+package main
+
+import (
+	"bufio"
+	"calculator"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const usage = `meta commands:
+  :env           list the global variables
+  :ast [src]     dump the ast of src or of the last program
+  :ast dot [src] draw the ast in Graphviz DOT, or mermaid for Mermaid
+  :tokens [src]  list the tokens of src or of the last program
+  :load file     run file in the current environment
+  :history       list the programs entered so far
+  !!, !n         run the last or the n-th program again
+  :help          show this message
+  :quit          leave the calculator
+`
+
+// repl reads programs and meta commands and evaluates the programs in one
+// environment, so a program can use what the ones before it defined
+type repl struct {
+	parser  *calculator.Parser
+	eval    *calculator.Evaluator
+	out     io.Writer
+	errOut  io.Writer
+	last    string   //the last program entered
+	history []string //the programs entered, oldest first
+	histOut io.Writer
+}
+
+func newRepl(out, errOut io.Writer) *repl {
+	return &repl{
+		parser: calculator.BuildParser(),
+		eval:   calculator.CreateEvaluator(),
+		out:    out,
+		errOut: errOut,
+	}
+}
+
+// run evaluates src and reports whether it succeeded. The value of a
+// program ending in an expression is printed when echo is set.
+func (r *repl) run(src string, echo bool) bool {
+	node, err := r.parser.Parse(src)
+	if err != nil {
+		r.report(src, err)
+		return false
+	}
+	res, err := r.eval.EvalValue(node)
+	if err != nil {
+		r.report(src, err)
+		return false
+	}
+	if echo && calculator.IsExpr(node) {
+		fmt.Fprintln(r.out, res)
+	}
+	return true
+}
+
+// report writes a diagnostic for every error in err
+func (r *repl) report(src string, err error) {
+	switch e := err.(type) {
+	case calculator.ErrorList:
+		for _, cerr := range e {
+			fmt.Fprint(r.errOut, cerr.Diagnostic(src))
+		}
+	case *calculator.Error:
+		fmt.Fprint(r.errOut, e.Diagnostic(src))
+	default:
+		fmt.Fprintln(r.errOut, err)
+	}
+}
+
+// runFile runs the program in file without echoing its values
+func (r *repl) runFile(file string) bool {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(r.errOut, err)
+		return false
+	}
+	return r.run(string(src), false)
+}
+
+// graphs are the formats :ast draws an ast in besides its dump
+var graphs = map[string]func(io.Writer, calculator.Node){
+	"dot":     calculator.DumpDOT,
+	"mermaid": calculator.DumpMermaid,
+}
+
+// split splits line into its first word and the rest
+func split(line string) (string, string) {
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i+1:])
+	}
+	return line, ""
+}
+
+// command runs a meta command and reports whether the repl should go on
+func (r *repl) command(line string) bool {
+	name, arg := split(line)
+	src := arg
+	if src == "" {
+		src = r.last
+	}
+
+	switch name {
+	case ":env":
+		vars := r.eval.Globals()
+		names := make([]string, 0, len(vars))
+		for k := range vars {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			fmt.Fprintf(r.out, "%s = %v\n", k, vars[k])
+		}
+	case ":ast":
+		dump := calculator.Dump
+		if graph, rest := split(arg); graphs[graph] != nil {
+			dump, src = graphs[graph], rest
+			if src == "" {
+				src = r.last
+			}
+		}
+		node, err := r.parser.Parse(src)
+		if err != nil {
+			r.report(src, err)
+			break
+		}
+		dump(r.out, node)
+	case ":tokens":
+		toks, err := calculator.Tokens(src)
+		for _, tok := range toks {
+			fmt.Fprintf(r.out, "%s %q %s\n", calculator.TokenName(tok.Kind), tok.Value, tok.Pos)
+		}
+		if err != nil {
+			fmt.Fprintln(r.errOut, err)
+		}
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(r.errOut, ":load needs a file name")
+			break
+		}
+		r.runFile(arg)
+	case ":history":
+		for i, src := range r.history {
+			fmt.Fprintf(r.out, "%d  %s\n", i+1, strings.Replace(src, "\n", "\n   ", -1))
+		}
+	case ":help":
+		fmt.Fprint(r.out, usage)
+	case ":quit":
+		return false
+	default:
+		fmt.Fprintf(r.errOut, "unknown command %s, try :help\n", name)
+	}
+	return true
+}
+
+// recall returns the program a !! or !n line refers to
+func (r *repl) recall(line string) (string, bool) {
+	if line == "!!" {
+		if len(r.history) == 0 {
+			return "", false
+		}
+		return r.history[len(r.history)-1], true
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(r.history) {
+		return "", false
+	}
+	return r.history[n-1], true
+}
+
+// remember adds src to the history and the history file
+func (r *repl) remember(src string) {
+	r.last = src
+	r.history = append(r.history, src)
+	if r.histOut != nil {
+		fmt.Fprintf(r.histOut, "%s\n", strconv.Quote(src))
+	}
+}
+
+// loadHistory reads the programs saved by earlier sessions from file and
+// appends the programs of this session to it
+func (r *repl) loadHistory(file string) {
+	if data, err := ioutil.ReadFile(file); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if src, err := strconv.Unquote(line); err == nil {
+				r.history = append(r.history, src)
+			}
+		}
+	}
+	if f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err == nil {
+		r.histOut = f
+	}
+}
+
+// loop reads programs from in until it is exhausted or :quit is entered. A
+// program continues on the next line while it has unclosed blocks.
+func (r *repl) loop(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	prompt := func(p string) {
+		fmt.Fprint(r.out, p)
+	}
+
+	prompt(">> ")
+	var src []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if len(src) == 0 {
+			switch {
+			case trimmed == "":
+				prompt(">> ")
+				continue
+			case strings.HasPrefix(trimmed, ":"):
+				if !r.command(trimmed) {
+					return
+				}
+				prompt(">> ")
+				continue
+			case strings.HasPrefix(trimmed, "!"):
+				prog, found := r.recall(trimmed)
+				if !found {
+					fmt.Fprintf(r.errOut, "no program %s in the history\n", trimmed)
+					prompt(">> ")
+					continue
+				}
+				fmt.Fprintln(r.out, prog)
+				line = prog
+			}
+		}
+
+		src = append(src, line)
+		prog := strings.Join(src, "\n")
+		if calculator.Incomplete(prog) {
+			prompt(".. ")
+			continue
+		}
+		src = nil
+
+		r.remember(prog)
+		r.run(prog, true)
+		prompt(">> ")
+	}
+
+	if len(src) > 0 {
+		prog := strings.Join(src, "\n")
+		r.remember(prog)
+		r.run(prog, true)
+	}
+	fmt.Fprintln(r.out)
+}
+
+// isTerminal reports whether f is a character device rather than a file
+// or a pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func main() {
+	interactive := flag.Bool("i", false, "start the read eval print loop even when stdin is not a terminal")
+	flag.Parse()
+	r := newRepl(os.Stdout, os.Stderr)
+
+	if flag.NArg() > 0 {
+		for _, file := range flag.Args() {
+			if !r.runFile(file) {
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	if !*interactive && !isTerminal(os.Stdin) {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(r.errOut, err)
+			os.Exit(1)
+		}
+		if !r.run(string(src), false) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		r.loadHistory(filepath.Join(home, ".calc_history"))
+	}
+	fmt.Fprint(r.out, "calculator, :help lists the meta commands\n")
+	r.loop(os.Stdin)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoop(t *testing.T) {
+	in := `set a = 2
+func f(x) {
+	return x * a
+}
+f(21)
+
+:env
+!!
+:ast 1 + 2
+:tokens set b
+:load ../../testdata/fold.calc
+sum
+:history
+2 $ 3
+:quit
+a`
+
+	var out, errOut bytes.Buffer
+	r := newRepl(&out, &errOut)
+	r.loop(strings.NewReader(in))
+
+	want := `>> >> .. .. >> 42
+>> >> a = 2
+f = <func f>
+>> f(21)
+42
+>> program 1:1
+  binary 1:1
+    number 1 1:1
+    + 1:3
+      number 2 1:5
+>> SET "set" 1:1
+IDENTIFIER "b" 1:5
+>> >> 10
+>> 1  set a = 2
+2  func f(x) {
+   	return x * a
+   }
+3  f(21)
+4  f(21)
+5  sum
+>> >> `
+	if out.String() != want {
+		t.Errorf("expected output\n%s\ngot\n%s", want, out.String())
+	}
+	if !strings.HasPrefix(errOut.String(), "syntax error: could not match anything at position 1:3") {
+		t.Errorf("expected a syntax error got %q", errOut.String())
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	var out, errOut bytes.Buffer
+	r := newRepl(&out, &errOut)
+
+	if !r.runFile("../../testdata/fold.calc") {
+		t.Fatalf("could not run testdata/fold.calc: %s", errOut.String())
+	}
+	if v := r.eval.Globals()["product"]; v != 24 {
+		t.Errorf("expected product to be 24 got %v", v)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected a file to run without echoing values got %q", out.String())
+	}
+	if r.runFile("../../testdata/missing.calc") {
+		t.Errorf("expected a missing file to fail")
+	}
+}
+
+func TestAstGraph(t *testing.T) {
+	var out, errOut bytes.Buffer
+	r := newRepl(&out, &errOut)
+
+	r.command(":ast dot 1 - 2")
+	want := "digraph ast {\n\tnode [shape=box, fontname=monospace];\n\tn0 [label=\"program\"];\n\tn1 [label=\"-\"];\n" +
+		"\tn2 [label=\"number 1\"];\n\tn3 [label=\"number 2\"];\n\tn0 -> n1;\n\tn1 -> n2;\n\tn1 -> n3;\n}\n"
+	if out.String() != want {
+		t.Errorf("expected %q got %q", want, out.String())
+	}
+
+	//without a program it draws the last one
+	out.Reset()
+	r.remember("x")
+	r.command(":ast mermaid")
+	if want := "flowchart TD\n\tn0[\"program\"]\n\tn1[\"identifier x\"]\n\tn0 --> n1\n"; out.String() != want {
+		t.Errorf("expected %q got %q", want, out.String())
+	}
+}
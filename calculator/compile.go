@@ -0,0 +1,338 @@
+package calculator
+
+// scope is the compile time view of a frame, it gives every variable the
+// frame may hold a slot. The global frame has no scope, its slots are
+// shared by every program a VM runs.
+type scope struct {
+	slots  map[string]int
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{slots: make(map[string]int), parent: parent}
+}
+
+func (s *scope) declare(name string) int {
+	slot, ok := s.slots[name]
+	if !ok {
+		slot = len(s.slots)
+		s.slots[name] = slot
+	}
+	return slot
+}
+
+// declareSets gives a slot to every variable node sets in the frame it runs
+// in. The branches of an if run in the enclosing frame, a block or a
+// function body in a frame of its own.
+func (s *scope) declareSets(node Node) {
+	switch n := node.(type) {
+	case *programStmt:
+		for _, dec := range n.declarations {
+			s.declareSets(dec)
+		}
+	case *assignStmt:
+		s.declare(n.identifier)
+	case *funcStmt:
+		s.declare(n.identifier)
+	case *ifExpr:
+		s.declareSets(n.thenStmt)
+		if n.elseStmt != nil {
+			s.declareSets(n.elseStmt)
+		}
+	}
+}
+
+type refKey struct {
+	name  string
+	scope *scope
+}
+
+// compiler emits the bytecode of one chunk
+type compiler struct {
+	chunk   *Chunk
+	scope   *scope         //the innermost frame, nil in the global frame
+	globals map[string]int //the global slots, shared with the VM
+	consts  map[int]int
+	refs    map[refKey]int
+	err     error
+}
+
+func newCompiler(name string, s *scope, globals map[string]int) *compiler {
+	return &compiler{
+		chunk:   &Chunk{name: name},
+		scope:   s,
+		globals: globals,
+		consts:  make(map[int]int),
+		refs:    make(map[refKey]int),
+	}
+}
+
+// compile compiles a program to run in the global frame of a VM with the
+// given global slots, adding a slot for each new global it refers to
+func compile(node Node, globals map[string]int) (*Chunk, error) {
+	c := newCompiler("program", nil, globals)
+	c.node(node, true)
+	c.emit(opReturn)
+	return c.chunk, c.err
+}
+
+func (c *compiler) emit(op opcode, operands ...byte) int {
+	c.chunk.code = append(c.chunk.code, byte(op))
+	c.chunk.code = append(c.chunk.code, operands...)
+	return len(c.chunk.code) - len(operands)
+}
+
+// emitAt emits an instruction that can fail at pos
+func (c *compiler) emitAt(pos Span, op opcode, operands ...byte) {
+	c.chunk.spans = append(c.chunk.spans, spanAt{offset: len(c.chunk.code), span: pos})
+	c.emit(op, operands...)
+}
+
+// u16 encodes an operand, recording an error when it does not fit
+func (c *compiler) u16(n int, pos Span) []byte {
+	if n > 0xffff && c.err == nil {
+		c.err = newError(RuntimeError, pos, "program too large to compile")
+	}
+	return []byte{byte(n >> 8), byte(n)}
+}
+
+func (c *compiler) u8(n int, pos Span) []byte {
+	if n > 0xff && c.err == nil {
+		c.err = newError(RuntimeError, pos, "too many arguments to compile")
+	}
+	return []byte{byte(n)}
+}
+
+// jump emits a forward jump whose offset is patched by land
+func (c *compiler) jump(op opcode) int {
+	return c.emit(op, 0, 0)
+}
+
+func (c *compiler) land(at int, pos Span) {
+	copy(c.chunk.code[at:], c.u16(len(c.chunk.code)-at-2, pos))
+}
+
+func (c *compiler) constant(n int, pos Span) []byte {
+	i, ok := c.consts[n]
+	if !ok {
+		i = len(c.chunk.consts)
+		c.chunk.consts = append(c.chunk.consts, n)
+		c.consts[n] = i
+	}
+	return c.u16(i, pos)
+}
+
+func (c *compiler) global(name string) int {
+	slot, ok := c.globals[name]
+	if !ok {
+		slot = len(c.globals)
+		c.globals[name] = slot
+	}
+	return slot
+}
+
+// resolve returns the index of the ref to name from the current frame
+func (c *compiler) resolve(name string, pos Span) []byte {
+	key := refKey{name: name, scope: c.scope}
+	i, ok := c.refs[key]
+	if !ok {
+		r := ref{name: name, global: c.global(name)}
+		depth := 0
+		for s := c.scope; s != nil; s = s.parent {
+			if slot, ok := s.slots[name]; ok {
+				r.locals = append(r.locals, local{depth: depth, slot: slot})
+			}
+			depth++
+		}
+		i = len(c.chunk.refs)
+		c.chunk.refs = append(c.chunk.refs, r)
+		c.refs[key] = i
+	}
+	return c.u16(i, pos)
+}
+
+// store emits the instruction that sets name in the current frame
+func (c *compiler) store(name string, pos Span) {
+	if c.scope == nil {
+		c.emit(opStore, c.u16(c.global(name), pos)...)
+		return
+	}
+	c.emit(opStore, c.u16(c.scope.declare(name), pos)...)
+}
+
+// node compiles a statement, leaving its value on the stack when keep is set
+func (c *compiler) node(node Node, keep bool) {
+	switch n := node.(type) {
+	case *programStmt:
+		if len(n.declarations) == 0 {
+			if keep {
+				c.emit(opZero)
+			}
+			return
+		}
+		last := len(n.declarations) - 1
+		for i, dec := range n.declarations {
+			c.node(dec, keep && i == last)
+		}
+	case *blockStmt:
+		outer := c.scope
+		c.scope = newScope(outer)
+		c.scope.declareSets(n.program)
+		c.emit(opPushFrame, c.u16(len(c.scope.slots), n.pos)...)
+		c.node(n.program, keep)
+		c.emit(opPopFrame)
+		c.scope = outer
+	case *assignStmt:
+		c.expr(n.expr)
+		c.store(n.identifier, n.pos)
+		if keep {
+			c.emit(opZero)
+		}
+	case *funcStmt:
+		c.emit(opClosure, c.u16(len(c.chunk.protos), n.pos)...)
+		c.chunk.protos = append(c.chunk.protos, c.function(n))
+		c.store(n.identifier, n.pos)
+		if keep {
+			c.emit(opZero)
+		}
+	case *returnStmt:
+		c.expr(n.expr)
+		c.emit(opReturn)
+	case *printExpr:
+		c.expr(n.expr)
+		c.emit(opPrint)
+		if keep {
+			c.emit(opZero)
+		}
+	case *ifExpr:
+		c.number(n.cmpExpr)
+		toElse := c.jump(opJumpIfZero)
+		c.node(n.thenStmt, keep)
+		toEnd := c.jump(opJump)
+		c.land(toElse, n.pos)
+		if n.elseStmt != nil {
+			c.node(n.elseStmt, keep)
+		} else if keep {
+			c.emit(opZero)
+		}
+		c.land(toEnd, n.pos)
+	default:
+		c.expr(node)
+		if !keep {
+			c.emit(opPop)
+		}
+	}
+}
+
+// function compiles the body of fn to run in a frame holding its parameters
+// and the variables its body sets. Eval runs the body block in a frame of
+// its own below the parameters, but as nothing else sees the parameter
+// frame one frame behaves the same.
+func (c *compiler) function(fn *funcStmt) *proto {
+	fc := newCompiler(fn.identifier, newScope(c.scope), c.globals)
+	p := &proto{name: fn.identifier, params: len(fn.params), chunk: fc.chunk}
+	for _, par := range fn.params {
+		p.paramSlots = append(p.paramSlots, fc.scope.declare(par))
+	}
+	if b, ok := fn.block.(*blockStmt); ok {
+		fc.scope.declareSets(b.program)
+		fc.node(b.program, true)
+	} else {
+		fc.node(fn.block, true)
+	}
+	fc.emit(opReturn)
+	p.slots = len(fc.scope.slots)
+	if fc.err != nil && c.err == nil {
+		c.err = fc.err
+	}
+	return p
+}
+
+// mayBeFunc reports whether node can evaluate to a function, so that using
+// it as a number has to be checked
+func mayBeFunc(node Node) bool {
+	switch n := node.(type) {
+	case *number:
+		return false
+	case *subExpr:
+		return mayBeFunc(n.Expr)
+	case *binaryExpr:
+		return len(n.subExprs) == 1 && mayBeFunc(n.subExprs[0])
+	case *unaryExpr:
+		return n.Op != MINUS && mayBeFunc(n.Right)
+	}
+	return true
+}
+
+// number compiles node where evalInt would evaluate it, a function value
+// fails at the position of node
+func (c *compiler) number(node Node) {
+	c.expr(node)
+	if mayBeFunc(node) {
+		c.emitAt(node.position(), opCheckInt)
+	}
+}
+
+// expr compiles an expression, leaving its value on the stack
+func (c *compiler) expr(node Node) {
+	switch n := node.(type) {
+	case *binaryExpr:
+		switch {
+		case len(n.subExprs) == 1:
+			c.expr(n.subExprs[0])
+		case len(n.subExprs) > 1 && n.subExprs[0].Op == POWER:
+			c.power(n)
+		default:
+			c.number(n.subExprs[0])
+			for _, se := range n.subExprs[1:] {
+				c.number(se)
+				op, ok := binaryOps[se.Op]
+				if !ok {
+					if c.err == nil {
+						c.err = newError(RuntimeError, se.pos, "illegal operator %d", se.Op)
+					}
+					continue
+				}
+				c.emitAt(se.pos, op)
+			}
+		}
+	case *subExpr:
+		c.expr(n.Expr)
+	case *unaryExpr:
+		if n.Op == MINUS {
+			c.number(n.Right)
+			c.emit(opNeg)
+			return
+		}
+		c.expr(n.Right)
+	case *callExpr:
+		c.expr(n.callee)
+		argc := c.u8(len(n.args), n.pos)
+		c.emitAt(n.pos, opCallable, argc...)
+		for _, a := range n.args {
+			c.expr(a)
+		}
+		c.emit(opCall, argc...)
+	case *number:
+		c.emit(opConst, c.constant(n.num, n.pos)...)
+	case *identifier:
+		c.emitAt(n.pos, opLoad, c.resolve(n.iden, n.pos)...)
+	case *programStmt, *blockStmt, *assignStmt, *funcStmt, *returnStmt, *printExpr, *ifExpr:
+		c.node(node, true)
+	default:
+		if c.err == nil {
+			c.err = newError(RuntimeError, Span{}, "unknown node type %T", n)
+		}
+	}
+}
+
+// power compiles a right associative power chain the way Eval evaluates it,
+// the last operand first
+func (c *compiler) power(n *binaryExpr) {
+	last := len(n.subExprs) - 1
+	c.number(n.subExprs[last])
+	for i := last - 1; i >= 0; i-- {
+		c.number(n.subExprs[i])
+		c.emit(opPow)
+	}
+}
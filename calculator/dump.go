@@ -0,0 +1,109 @@
+package calculator
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+var opNames = map[Op]string{
+	ILLEGALOP: "ILLEGALOP",
+	PLUS:      "+",
+	MINUS:     "-",
+	MULTIPLY:  "*",
+	DIVIDE:    "/",
+	POWER:     "**",
+	EQ:        "==",
+	NEQ:       "!=",
+	LT:        "<",
+	GT:        ">",
+	LTE:       "<=",
+	GTE:       ">=",
+	BITOR:     "|",
+	BITXOR:    "^",
+	BITAND:    "&",
+	LSHIFT:    "<<",
+	RSHIFT:    ">>",
+}
+
+func (o Op) String() string {
+	if s, ok := opNames[o]; ok {
+		return s
+	}
+	return fmt.Sprintf("Op(%d)", int(o))
+}
+
+// Dump writes the ast rooted at node to w, one node per line indented by
+// its depth and followed by the position it starts at. Binary expressions
+// with a single operand are left out.
+func Dump(w io.Writer, node Node) {
+	dump(w, node, 0)
+}
+
+func dump(w io.Writer, node Node, depth int) {
+	line := func(format string, a ...interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", strings.Repeat("  ", depth), fmt.Sprintf(format, a...), node.position().Start)
+	}
+
+	switch n := node.(type) {
+	case *programStmt:
+		line("program")
+		for _, dec := range n.declarations {
+			dump(w, dec, depth+1)
+		}
+	case *blockStmt:
+		line("block")
+		dump(w, n.program, depth+1)
+	case *assignStmt:
+		line("set %s", n.identifier)
+		dump(w, n.expr, depth+1)
+	case *funcStmt:
+		line("func %s(%s)", n.identifier, strings.Join(n.params, ", "))
+		dump(w, n.block, depth+1)
+	case *returnStmt:
+		line("return")
+		dump(w, n.expr, depth+1)
+	case *printExpr:
+		line("print")
+		dump(w, n.expr, depth+1)
+	case *ifExpr:
+		line("if")
+		dump(w, n.cmpExpr, depth+1)
+		dump(w, n.thenStmt, depth+1)
+		if n.elseStmt != nil {
+			dump(w, n.elseStmt, depth+1)
+		}
+	case *binaryExpr:
+		//every precedence level wraps its operand, only show the operators
+		if len(n.subExprs) == 1 {
+			dump(w, n.subExprs[0], depth)
+			return
+		}
+		line("binary")
+		for _, se := range n.subExprs {
+			dump(w, se, depth+1)
+		}
+	case *subExpr:
+		if n.Op == ILLEGALOP {
+			dump(w, n.Expr, depth)
+			return
+		}
+		line("%s", n.Op)
+		dump(w, n.Expr, depth+1)
+	case *unaryExpr:
+		line("unary %s", n.Op)
+		dump(w, n.Right, depth+1)
+	case *callExpr:
+		line("call")
+		dump(w, n.callee, depth+1)
+		for _, a := range n.args {
+			dump(w, a, depth+1)
+		}
+	case *number:
+		line("number %d", n.num)
+	case *identifier:
+		line("identifier %s", n.iden)
+	default:
+		line("%T", n)
+	}
+}
@@ -4,7 +4,7 @@ package calculator
 
 EBNF:
 
-<program>     : <stmt> {<seperator> <stmt>}
+<program>     : [<stmt>] {<seperator> [<stmt>]}
 
 <stmt>        : <assign_stmt>
 			  | <func_stmt>
@@ -12,6 +12,7 @@ EBNF:
               | <cmp_expr>
 			  | <block>
 			  | <print>
+			  | <return_stmt>
 
 <assign_stmt> : set <id> = <cmp_expr>
 
@@ -21,6 +22,12 @@ EBNF:
 
 <parameters>  : <id> { , <id> }
 
+A function yields the value of its return statement, or the value of the
+last statement in its block when it finishes without one. return is only
+allowed inside a function body.
+
+<return_stmt> : return <cmp_expr>
+
 
 Note 'else' binds to the innermost 'if', like in C
 
@@ -30,8 +37,6 @@ Note 'else' binds to the innermost 'if', like in C
 
 <print>       : print(<cmp_expr>)
 
-//TODO add in all expressions until arithExpr
-
 <cmp_expr>    : <bitor_expr> [== <bitor_expr>]
               | <bitor_expr> [!= <bitor_expr>]
               | <bitor_expr> [> <bitor_expr>]
@@ -39,14 +44,14 @@ Note 'else' binds to the innermost 'if', like in C
               | <bitor_expr> [>= <bitor_expr>]
               | <bitor_expr> [<= <bitor_expr>]
 
-<bitor_expr>  | <bitxor_expr> {| <bitxor_expr>}
+<bitor_expr>  : <bitxor_expr> {| <bitxor_expr>}
 
-<bitxor_expr> | <bitand_expr> {^ <bitand_expr>}
+<bitxor_expr> : <bitand_expr> {^ <bitand_expr>}
 
-<bitand_expr> | <shift_expr> {& <shift_expr>}
+<bitand_expr> : <shift_expr> {& <shift_expr>}
 
-<shift_expr>  | <arith_expr> {<< <arith_expr>}
-              : <arith_expr> {>> <arith_expr>}
+<shift_expr>  : <arith_expr> {<< <arith_expr>}
+              | <arith_expr> {>> <arith_expr>}
 
 <arith_expr>  : <term> {+ <term>}
               | <term> {- <term>}
@@ -64,20 +69,32 @@ Note 'else' binds to the innermost 'if', like in C
 
 <unary>       : - <call>
 
-<call>        : primary { ( {<arguments>} )}
+Functions are values that capture the scope they are defined in, so any
+expression that evaluates to a function can be called.
+
+<call>        : <primary> { ( [<arguments>] ) }
 
 <arguments>   : <cmp_expr> { , <cmp_expr> }
 
-<primary>     : <id> | <number> |
+<primary>     : <id> | <number> | ( <cmp_expr> )
 
 <id>          : [a-zA-Z_]\w+
 <number>      : \d+
 <seperator>   : \n
 			  | ;
+
+Comments are skipped by the lexer. A line comment starts with # or // and
+runs to the end of the line. A block comment starts with /* and runs to the
+first closing star slash, a block comment spanning several lines separates
+statements like a newline does.
 */
 
-import "fmt"
-import "strconv"
+import (
+	"io"
+	"lexer"
+	"strconv"
+	"strings"
+)
 
 type Op int
 
@@ -88,28 +105,49 @@ const (
 	MULTIPLY
 	DIVIDE
 	POWER
+	EQ
+	NEQ
+	LT
+	GT
+	LTE
+	GTE
+	BITOR
+	BITXOR
+	BITAND
+	LSHIFT
+	RSHIFT
 )
 
 type Node interface {
 	isNode()
+	position() Span
 }
 
 type programStmt struct {
+	pos          Span
 	declarations []Node
 }
 
 type assignStmt struct {
+	pos        Span
 	identifier string
 	expr       Node
 }
 
 type funcStmt struct {
+	pos        Span
 	identifier string
 	params     []string
 	block      Node
 }
 
+type returnStmt struct {
+	pos  Span
+	expr Node
+}
+
 type binaryExpr struct {
+	pos      Span
 	Op       Op
 	subExprs []*subExpr
 }
@@ -120,20 +158,24 @@ func (a *binaryExpr) accept(v Visitor) {
 
 //TODO should if be a stmt or expr?
 type ifExpr struct {
+	pos      Span
 	cmpExpr  Node
 	thenStmt Node
 	elseStmt Node
 }
 
 type blockStmt struct {
+	pos     Span
 	program Node
 }
 
 type printExpr struct {
+	pos  Span
 	expr Node
 }
 
 type identifier struct {
+	pos  Span
 	iden string
 }
 
@@ -142,6 +184,7 @@ func (a *identifier) accept(v Visitor) {
 }
 
 type number struct {
+	pos Span
 	num int
 }
 
@@ -150,6 +193,7 @@ func (a *number) accept(v Visitor) {
 }
 
 type unaryExpr struct {
+	pos   Span
 	Op    Op
 	Right Node
 }
@@ -159,11 +203,15 @@ func (a *unaryExpr) accept(v Visitor) {
 }
 
 type callExpr struct {
-	funcName string
-	args     []Node
+	pos    Span
+	callee Node
+	args   []Node
 }
 
+// the position of a subExpr is the position of its operator, or of the
+// operand when the operator is ILLEGALOP
 type subExpr struct {
+	pos  Span
 	Op   Op
 	Expr Node
 }
@@ -176,6 +224,7 @@ func (e *programStmt) isNode() {}
 func (e *assignStmt) isNode()  {}
 func (e *binaryExpr) isNode()  {}
 func (e *funcStmt) isNode()    {}
+func (e *returnStmt) isNode()  {}
 func (e *identifier) isNode()  {}
 func (e *number) isNode()      {}
 func (e *unaryExpr) isNode()   {}
@@ -185,362 +234,696 @@ func (e *blockStmt) isNode()   {}
 func (e *printExpr) isNode()   {}
 func (e *callExpr) isNode()    {}
 
+func (e *programStmt) position() Span { return e.pos }
+func (e *assignStmt) position() Span  { return e.pos }
+func (e *binaryExpr) position() Span  { return e.pos }
+func (e *funcStmt) position() Span    { return e.pos }
+func (e *returnStmt) position() Span  { return e.pos }
+func (e *identifier) position() Span  { return e.pos }
+func (e *number) position() Span      { return e.pos }
+func (e *unaryExpr) position() Span   { return e.pos }
+func (e *subExpr) position() Span     { return e.pos }
+func (e *ifExpr) position() Span      { return e.pos }
+func (e *blockStmt) position() Span   { return e.pos }
+func (e *printExpr) position() Span   { return e.pos }
+func (e *callExpr) position() Span    { return e.pos }
+
 type Parser struct {
-	Lexer        *Lexer
-	CurrentToken *Token
+	Lexer        *lexer.Lexer
+	CurrentToken *lexer.Token
+	funcDepth    int      //number of function bodies being parsed
+	prevEnd      Position //end of the last matched token
+	errors       ErrorList
 }
 
-func BuildParser() *Parser {
-	regexMap := [][2]string{
-		{`set`, "SET"},
-		{`if`, "IF"},
-		{`then`, "THEN"},
-		{`else`, "ELSE"},
-		{`func`, "FUNC"},
-		{`print`, "PRINT"},
-		{`\n`, "NEWLINE"},
-		{`\d+`, "NUMBER"},
-		{`[a-zA-Z_]\w*`, "IDENTIFIER"},
-		{`\*\*`, "**"},
-		{`!=`, "!="},
-		{`==`, "=="},
-		{`>=`, ">="},
-		{`<=`, "<="},
-		{`>>`, ">>"},
-		{`<<`, "<<"},
-		{`&`, "&"},
-		{`\^`, "^"},
-		{`\|`, "|"},
-		{`<`, "<"},
-		{`>`, ">"},
-		{`\+`, "+"},
-		{`\-`, "-"},
-		{`\*`, "*"},
-		{`\/`, "/"},
-		{`\(`, "("},
-		{`\)`, ")"},
-		{`\{`, "{"},
-		{`\}`, "}"},
-		{`=`, "="},
-		{`;`, ";"},
-		{`,`, ","},
-	}
+// token kinds produced by calcRules
+const (
+	tokSet lexer.Kind = lexer.FirstKind + iota
+	tokIf
+	tokThen
+	tokElse
+	tokFunc
+	tokPrint
+	tokReturn
+	tokNumber
+	tokIdentifier
+	tokPower
+	tokNeq
+	tokEq
+	tokGte
+	tokLte
+	tokRshift
+	tokLshift
+	tokAmp
+	tokCaret
+	tokPipe
+	tokLt
+	tokGt
+	tokPlus
+	tokMinus
+	tokStar
+	tokSlash
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokAssign
+	tokSemicolon
+	tokComma
+)
+
+// calcRules lists the tokens of the language, keywords before identifiers
+var calcRules = lexer.MustRuleSet([]lexer.Rule{
+	{Pattern: `set`, Kind: tokSet, Name: "SET"},
+	{Pattern: `if`, Kind: tokIf, Name: "IF"},
+	{Pattern: `then`, Kind: tokThen, Name: "THEN"},
+	{Pattern: `else`, Kind: tokElse, Name: "ELSE"},
+	{Pattern: `func`, Kind: tokFunc, Name: "FUNC"},
+	{Pattern: `print`, Kind: tokPrint, Name: "PRINT"},
+	{Pattern: `return`, Kind: tokReturn, Name: "RETURN"},
+	{Pattern: `\d+`, Kind: tokNumber, Name: "NUMBER"},
+	{Pattern: `[a-zA-Z_]\w*`, Kind: tokIdentifier, Name: "IDENTIFIER"},
+	{Pattern: `\*\*`, Kind: tokPower, Name: "**"},
+	{Pattern: `!=`, Kind: tokNeq, Name: "!="},
+	{Pattern: `==`, Kind: tokEq, Name: "=="},
+	{Pattern: `>=`, Kind: tokGte, Name: ">="},
+	{Pattern: `<=`, Kind: tokLte, Name: "<="},
+	{Pattern: `>>`, Kind: tokRshift, Name: ">>"},
+	{Pattern: `<<`, Kind: tokLshift, Name: "<<"},
+	{Pattern: `&`, Kind: tokAmp, Name: "&"},
+	{Pattern: `\^`, Kind: tokCaret, Name: "^"},
+	{Pattern: `\|`, Kind: tokPipe, Name: "|"},
+	{Pattern: `<`, Kind: tokLt, Name: "<"},
+	{Pattern: `>`, Kind: tokGt, Name: ">"},
+	{Pattern: `\+`, Kind: tokPlus, Name: "+"},
+	{Pattern: `\-`, Kind: tokMinus, Name: "-"},
+	{Pattern: `\*`, Kind: tokStar, Name: "*"},
+	{Pattern: `\/`, Kind: tokSlash, Name: "/"},
+	{Pattern: `\(`, Kind: tokLParen, Name: "("},
+	{Pattern: `\)`, Kind: tokRParen, Name: ")"},
+	{Pattern: `\{`, Kind: tokLBrace, Name: "{"},
+	{Pattern: `\}`, Kind: tokRBrace, Name: "}"},
+	{Pattern: `=`, Kind: tokAssign, Name: "="},
+	{Pattern: `;`, Kind: tokSemicolon, Name: ";"},
+	{Pattern: `,`, Kind: tokComma, Name: ","},
+}, commentConfig())
+
+// commentConfig skips # and // line comments and /* */ block comments
+func commentConfig() lexer.Config {
+	cfg := lexer.DefaultConfig()
+	cfg.LineComments = []string{"#", "//"}
+	cfg.BlockComments = [][2]string{{"/*", "*/"}}
+	return cfg
+}
 
+func BuildParser() *Parser {
 	p := &Parser{}
-	p.Lexer = CreateLexer(regexMap, true)
+	p.Lexer = lexer.New(calcRules, nil)
 	return p
 }
 
-func (p *Parser) getNextToken() {
-	var err error
-	p.CurrentToken, err = p.Lexer.Token()
+// Tokens returns the tokens of src, up to the first lexer error
+func Tokens(src string) ([]*lexer.Token, error) {
+	return lexer.New(calcRules, strings.NewReader(src)).Tokens()
+}
+
+// TokenName returns the name of a token kind, e.g. NUMBER or +
+func TokenName(k lexer.Kind) string {
+	return calcRules.Name(k)
+}
+
+// Incomplete reports whether src opens more blocks than it closes, so the
+// rest of the program is still to come
+func Incomplete(src string) bool {
+	toks, _ := Tokens(src)
+	depth := 0
+	for _, tok := range toks {
+		switch tok.Kind {
+		case tokLBrace:
+			depth++
+		case tokRBrace:
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+func (p *Parser) getNextToken() error {
+	tok, err := p.Lexer.Token()
 	if err != nil {
-		panic(err)
+		pos := p.Lexer.Position()
+		if lerr, ok := err.(*lexer.LexError); ok {
+			pos = lerr.Pos
+		}
+		if err == p.Lexer.Err() {
+			//the input ends where it could not be read
+			p.CurrentToken = &lexer.Token{Kind: lexer.EOF, Pos: pos, End: pos}
+		}
+		return newError(SyntaxError, Span{Start: pos, End: pos}, "%v", err)
+	}
+	if p.CurrentToken != nil {
+		p.prevEnd = p.CurrentToken.End
+	}
+	p.CurrentToken = tok
+	return nil
+}
+
+// skipToken moves past the current token while synchronizing, the lexer
+// errors in the skipped input are not reported. An error reading the input
+// ends it and is reported.
+func (p *Parser) skipToken() {
+	for {
+		err := p.getNextToken()
+		if err == nil {
+			return
+		}
+		if p.CurrentToken.Kind == lexer.EOF {
+			p.addError(err)
+			return
+		}
+	}
+}
+
+func (p *Parser) addError(err error) {
+	p.errors = append(p.errors, err.(*Error))
+}
+
+// synchronize discards tokens after a syntax error until the end of the
+// statement it occurred in. A separator is consumed, a } is left for the
+// enclosing block unless end shows that there is no enclosing block.
+func (p *Parser) synchronize(end lexer.Kind) {
+	for {
+		switch p.CurrentToken.Kind {
+		case tokSemicolon, lexer.Newline:
+			p.skipToken()
+			return
+		case tokRBrace:
+			if end == tokRBrace {
+				return
+			}
+		case lexer.EOF:
+			return
+		}
+		p.skipToken()
 	}
 }
 
-func (p *Parser) matchMultipleTokens(ts ...string) string {
+// span returns the span from start to the end of the last matched token
+func (p *Parser) span(start Position) Span {
+	return Span{Start: start, End: p.prevEnd}
+}
+
+// unexpected reports that the current token is not one of the kinds ks
+func (p *Parser) unexpected(ks ...lexer.Kind) *Error {
+	ts := make([]string, len(ks))
+	for i, k := range ks {
+		ts[i] = calcRules.Name(k)
+	}
+	got := calcRules.Name(p.CurrentToken.Kind)
+
+	var err *Error
+	if len(ts) == 1 {
+		err = newError(SyntaxError, p.CurrentToken.Span(), "expected type %s got type %s", ts[0], got)
+	} else {
+		err = newError(SyntaxError, p.CurrentToken.Span(), "expected one of the types %+v got type %s", ts, got)
+	}
+	err.Expected = ts
+	err.Got = got
+	return err
+}
+
+func (p *Parser) matchMultipleTokens(ts ...lexer.Kind) (string, error) {
 	for _, t := range ts {
-		if t == p.CurrentToken.Type {
+		if t == p.CurrentToken.Kind {
 			v := p.CurrentToken.Value
-			p.getNextToken()
-			return v
+			return v, p.getNextToken()
 		}
 	}
-	err := fmt.Errorf("expcted one of the types %+v got type %s", ts, p.CurrentToken.Type)
-	panic(err)
-	return ""
+	return "", p.unexpected(ts...)
 }
 
-func (p *Parser) matchToken(t string) string {
-	if t == p.CurrentToken.Type {
+func (p *Parser) matchToken(t lexer.Kind) (string, error) {
+	if t == p.CurrentToken.Kind {
 		v := p.CurrentToken.Value
-		p.getNextToken()
-		return v
+		return v, p.getNextToken()
 	}
 
-	err := fmt.Errorf("expcted type %s got type %s", t, p.CurrentToken.Type)
-	panic(err)
-	return ""
+	return "", p.unexpected(t)
 }
 
-func (p *Parser) Parse(program string) Node {
+// Parse returns the ast of program. When the program is malformed the error
+// is an ErrorList holding every syntax error, and the ast contains the
+// statements that could be parsed.
+func (p *Parser) Parse(program string) (Node, error) {
+	return p.ParseReader(strings.NewReader(program))
+}
 
-	p.Lexer.Input(program)
-	p.Lexer.Reset()
-	p.CurrentToken, _ = p.Lexer.Token()
+// ParseReader parses the program read from r like Parse. An error reading r
+// is reported as a syntax error where the input stopped.
+func (p *Parser) ParseReader(r io.Reader) (Node, error) {
+	p.Lexer.Reset(r)
+	p.funcDepth = 0
+	p.CurrentToken = nil
+	p.prevEnd = Position{}
+	p.errors = nil
+	for p.CurrentToken == nil {
+		if err := p.getNextToken(); err != nil {
+			p.addError(err)
+		}
+	}
 
-	return p.parseProgram()
+	node := p.parseProgram()
+	return node, p.errors.Err()
 }
 
 func (p *Parser) parseProgram() Node {
-	stmts := make([]Node, 0)
-	var n Node
-	for p.CurrentToken.Type != "EOF" {
-		n = p.parseDeclaration()
-		stmts = append(stmts, n)
-		if p.CurrentToken.Type != "EOF" {
-			p.matchMultipleTokens(";", "NEWLINE")
-		}
-	}
+	pos := p.CurrentToken.Pos
+	stmts := p.parseStmts(lexer.EOF)
 
-	if p.CurrentToken.Type != "EOF" {
-		panic(fmt.Sprintf("Expectd type EOF got %s instead", p.CurrentToken.Type))
-	}
 	return &programStmt{
+		pos:          p.span(pos),
 		declarations: stmts,
 	}
 }
 
-func (p *Parser) parseDeclaration() Node {
-	c := p.CurrentToken.Type
-	var node Node
-	if c == "SET" {
-		node = p.parseAssignStmt()
-	} else if c == "IF" {
-		node = p.parseIfExpr()
-	} else if c == "{" {
-		node = p.parseBlockExpr()
-	} else if c == "PRINT" {
-		node = p.parsePrintStmt()
-	} else if c == "FUNC" {
-		node = p.parseFuncStmt()
-	} else {
-		node = p.parseCmpExpr()
+// parseStmts parses seperated declarations until a token of type end. A
+// declaration that fails to parse is recorded and skipped, so every syntax
+// error in the program is reported.
+func (p *Parser) parseStmts(end lexer.Kind) []Node {
+	stmts := make([]Node, 0)
+	for p.CurrentToken.Kind != end && p.CurrentToken.Kind != lexer.EOF {
+		//a statement may be empty
+		if p.CurrentToken.Kind == tokSemicolon || p.CurrentToken.Kind == lexer.Newline {
+			if err := p.getNextToken(); err != nil {
+				p.addError(err)
+			}
+			continue
+		}
+
+		n, err := p.parseDeclaration()
+		if err == nil {
+			stmts = append(stmts, n)
+			if p.CurrentToken.Kind != end && p.CurrentToken.Kind != lexer.EOF {
+				_, err = p.matchMultipleTokens(tokSemicolon, lexer.Newline)
+			}
+		}
+		if err != nil {
+			p.addError(err)
+			p.synchronize(end)
+		}
+	}
+	return stmts
+}
+
+func (p *Parser) parseDeclaration() (Node, error) {
+	switch p.CurrentToken.Kind {
+	case tokSet:
+		return p.parseAssignStmt()
+	case tokIf:
+		return p.parseIfExpr()
+	case tokLBrace:
+		return p.parseBlockExpr()
+	case tokPrint:
+		return p.parsePrintStmt()
+	case tokFunc:
+		return p.parseFuncStmt()
+	case tokReturn:
+		return p.parseReturnStmt()
 	}
-	return node
+	return p.parseCmpExpr()
 }
 
-func (p *Parser) parseAssignStmt() Node {
-	p.matchToken("SET")
-	iden := p.matchToken("IDENTIFIER")
-	p.matchToken("=")
-	cmpExpr := p.parseCmpExpr()
+func (p *Parser) parseAssignStmt() (Node, error) {
+	pos := p.CurrentToken.Pos
+	if _, err := p.matchToken(tokSet); err != nil {
+		return nil, err
+	}
+	iden, err := p.matchToken(tokIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.matchToken(tokAssign); err != nil {
+		return nil, err
+	}
+	cmpExpr, err := p.parseCmpExpr()
+	if err != nil {
+		return nil, err
+	}
 	return &assignStmt{
+		pos:        p.span(pos),
 		identifier: iden,
 		expr:       cmpExpr,
-	}
+	}, nil
 }
 
-func (p *Parser) parseIfExpr() Node {
-	p.matchToken("IF")
-	ce := p.parseCmpExpr()
-	p.matchToken("THEN")
-	te := p.parseDeclaration()
+func (p *Parser) parseIfExpr() (Node, error) {
+	pos := p.CurrentToken.Pos
+	if _, err := p.matchToken(tokIf); err != nil {
+		return nil, err
+	}
+	ce, err := p.parseCmpExpr()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.matchToken(tokThen); err != nil {
+		return nil, err
+	}
+	te, err := p.parseDeclaration()
+	if err != nil {
+		return nil, err
+	}
 	var ee Node
-	if p.CurrentToken.Type == "ELSE" {
-		p.matchToken("ELSE")
-		ee = p.parseDeclaration()
+	if p.CurrentToken.Kind == tokElse {
+		if _, err := p.matchToken(tokElse); err != nil {
+			return nil, err
+		}
+		if ee, err = p.parseDeclaration(); err != nil {
+			return nil, err
+		}
 	}
 	return &ifExpr{
+		pos:      p.span(pos),
 		cmpExpr:  ce,
 		thenStmt: te,
 		elseStmt: ee,
-	}
+	}, nil
 }
 
-//TODO code in parseProgram is quite similar
-func (p *Parser) parseBlockExpr() Node {
-	p.matchToken("{")
-	stmts := make([]Node, 0)
-	var n Node
-	for p.CurrentToken.Type != "}" {
-		n = p.parseDeclaration()
-		stmts = append(stmts, n)
-		if p.CurrentToken.Type != "}" {
-			p.matchMultipleTokens(";", "NEWLINE")
-		}
+func (p *Parser) parseBlockExpr() (Node, error) {
+	pos := p.CurrentToken.Pos
+	if _, err := p.matchToken(tokLBrace); err != nil {
+		return nil, err
 	}
-
-	if p.CurrentToken.Type != "}" {
-		panic(fmt.Sprintf("Expectd type } got %s instead", p.CurrentToken.Type))
+	stmts := p.parseStmts(tokRBrace)
+	if _, err := p.matchToken(tokRBrace); err != nil {
+		return nil, err
 	}
-	p.matchToken("}")
 
 	return &blockStmt{
+		pos: p.span(pos),
 		program: &programStmt{
+			pos:          p.span(pos),
 			declarations: stmts,
 		},
-	}
+	}, nil
 }
 
-func (p *Parser) parsePrintStmt() Node {
-	p.matchToken("PRINT")
-	p.matchToken("(")
-	expr := p.parseCmpExpr()
-	p.matchToken(")")
+func (p *Parser) parsePrintStmt() (Node, error) {
+	pos := p.CurrentToken.Pos
+	if _, err := p.matchToken(tokPrint); err != nil {
+		return nil, err
+	}
+	if _, err := p.matchToken(tokLParen); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseCmpExpr()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.matchToken(tokRParen); err != nil {
+		return nil, err
+	}
 
 	return &printExpr{
+		pos:  p.span(pos),
 		expr: expr,
-	}
+	}, nil
 }
 
-func (p *Parser) parseFuncStmt() Node {
-	p.matchToken("FUNC")
-	funcIden := p.matchToken("IDENTIFIER")
-	p.matchToken("(")
+func (p *Parser) parseFuncStmt() (Node, error) {
+	pos := p.CurrentToken.Pos
+	if _, err := p.matchToken(tokFunc); err != nil {
+		return nil, err
+	}
+	funcIden, err := p.matchToken(tokIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.matchToken(tokLParen); err != nil {
+		return nil, err
+	}
 
 	params := make([]string, 0)
-	var par string
-	for p.CurrentToken.Type != ")" {
-		par = p.matchToken("IDENTIFIER")
+	for p.CurrentToken.Kind != tokRParen {
+		par, err := p.matchToken(tokIdentifier)
+		if err != nil {
+			return nil, err
+		}
 		params = append(params, par)
-		if p.CurrentToken.Type != ")" {
-			p.matchToken(",")
+		if p.CurrentToken.Kind != tokRParen {
+			if _, err := p.matchToken(tokComma); err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	p.matchToken(")")
+	if _, err := p.matchToken(tokRParen); err != nil {
+		return nil, err
+	}
 
-	block := p.parseBlockExpr()
+	p.funcDepth++
+	block, err := p.parseBlockExpr()
+	p.funcDepth--
+	if err != nil {
+		return nil, err
+	}
 
 	return &funcStmt{
+		pos:        p.span(pos),
 		identifier: funcIden,
 		params:     params,
 		block:      block,
+	}, nil
+}
+
+func (p *Parser) parseReturnStmt() (Node, error) {
+	pos := p.CurrentToken.Pos
+	if p.funcDepth == 0 {
+		return nil, newError(SyntaxError, p.CurrentToken.Span(), "return outside of a function")
+	}
+	if _, err := p.matchToken(tokReturn); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseCmpExpr()
+	if err != nil {
+		return nil, err
 	}
+
+	return &returnStmt{
+		pos:  p.span(pos),
+		expr: expr,
+	}, nil
 }
 
-func (p *Parser) parseCmpExpr() Node {
-	return p.parseArithExpr()
+var cmpOps = map[lexer.Kind]Op{
+	tokEq:  EQ,
+	tokNeq: NEQ,
+	tokLt:  LT,
+	tokGt:  GT,
+	tokLte: LTE,
+	tokGte: GTE,
 }
 
-func (p *Parser) parseArithExpr() Node {
-	subExprs := make([]*subExpr, 0)
-	var op Op
+// comparisons do not chain so there is at most one operator
+func (p *Parser) parseCmpExpr() (Node, error) {
+	lhs, err := p.parseBitOrExpr()
+	if err != nil {
+		return nil, err
+	}
 
-	lhs := p.parseTerm()
-	subExprs = append(subExprs, &subExpr{Op: ILLEGALOP, Expr: lhs})
-	for {
-		if p.CurrentToken.Type == "+" {
-			p.matchToken("+")
-			op = PLUS
-		} else if p.CurrentToken.Type == "-" {
-			p.matchToken("-")
-			op = MINUS
-		} else {
-			break
-		}
-		rhs := p.parseTerm()
-		se := &subExpr{
-			Op:   op,
-			Expr: rhs,
-		}
-		subExprs = append(subExprs, se)
+	op, ok := cmpOps[p.CurrentToken.Kind]
+	if !ok {
+		return lhs, nil
+	}
+	opPos := p.CurrentToken.Pos
+	if _, err := p.matchToken(p.CurrentToken.Kind); err != nil {
+		return nil, err
+	}
+	rhs, err := p.parseBitOrExpr()
+	if err != nil {
+		return nil, err
 	}
 
-	return &binaryExpr{subExprs: subExprs}
+	return &binaryExpr{
+		pos: p.span(lhs.position().Start),
+		subExprs: []*subExpr{
+			&subExpr{pos: lhs.position(), Op: ILLEGALOP, Expr: lhs},
+			&subExpr{pos: p.span(opPos), Op: op, Expr: rhs},
+		},
+	}, nil
 }
 
-func (p *Parser) parseTerm() Node {
+// parseLeftAssoc parses one left associative precedence tier. ops maps the
+// token types of the tier to their Op and next parses the tier below it.
+func (p *Parser) parseLeftAssoc(ops map[lexer.Kind]Op, next func() (Node, error)) (Node, error) {
 	subExprs := make([]*subExpr, 0)
 
-	lhs := p.parsePower()
-	subExprs = append(subExprs, &subExpr{Op: ILLEGALOP, Expr: lhs})
-
-	var op Op
+	lhs, err := next()
+	if err != nil {
+		return nil, err
+	}
+	subExprs = append(subExprs, &subExpr{pos: lhs.position(), Op: ILLEGALOP, Expr: lhs})
 	for {
-		if p.CurrentToken.Type == "*" {
-			p.matchToken("*")
-			op = MULTIPLY
-		} else if p.CurrentToken.Type == "/" {
-			p.matchToken("/")
-			op = DIVIDE
-		} else {
+		op, ok := ops[p.CurrentToken.Kind]
+		if !ok {
 			break
 		}
+		opPos := p.CurrentToken.Pos
+		if _, err := p.matchToken(p.CurrentToken.Kind); err != nil {
+			return nil, err
+		}
 
-		rhs := p.parsePower()
-		se := &subExpr{
-			Op:   op,
-			Expr: rhs,
+		rhs, err := next()
+		if err != nil {
+			return nil, err
 		}
-		subExprs = append(subExprs, se)
+		subExprs = append(subExprs, &subExpr{pos: p.span(opPos), Op: op, Expr: rhs})
 	}
 
-	return &binaryExpr{subExprs: subExprs}
+	return &binaryExpr{pos: p.span(lhs.position().Start), subExprs: subExprs}, nil
+}
+
+func (p *Parser) parseBitOrExpr() (Node, error) {
+	return p.parseLeftAssoc(map[lexer.Kind]Op{tokPipe: BITOR}, p.parseBitXorExpr)
+}
+
+func (p *Parser) parseBitXorExpr() (Node, error) {
+	return p.parseLeftAssoc(map[lexer.Kind]Op{tokCaret: BITXOR}, p.parseBitAndExpr)
+}
+
+func (p *Parser) parseBitAndExpr() (Node, error) {
+	return p.parseLeftAssoc(map[lexer.Kind]Op{tokAmp: BITAND}, p.parseShiftExpr)
+}
+
+func (p *Parser) parseShiftExpr() (Node, error) {
+	return p.parseLeftAssoc(map[lexer.Kind]Op{tokLshift: LSHIFT, tokRshift: RSHIFT}, p.parseArithExpr)
+}
+
+func (p *Parser) parseArithExpr() (Node, error) {
+	return p.parseLeftAssoc(map[lexer.Kind]Op{tokPlus: PLUS, tokMinus: MINUS}, p.parseTerm)
+}
+
+func (p *Parser) parseTerm() (Node, error) {
+	return p.parseLeftAssoc(map[lexer.Kind]Op{tokStar: MULTIPLY, tokSlash: DIVIDE}, p.parsePower)
 }
 
 // power is right associative so the factor element has ILLEGALOP
-func (p *Parser) parsePower() Node {
+func (p *Parser) parsePower() (Node, error) {
 	subExprs := make([]*subExpr, 0)
 
-	factor := p.parseUnary()
+	factor, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	pos := factor.position().Start
 
-	for {
-		if p.CurrentToken.Type == "**" {
-			p.matchToken("**")
-		} else {
-			break
+	for p.CurrentToken.Kind == tokPower {
+		if _, err := p.matchToken(tokPower); err != nil {
+			return nil, err
 		}
 
-		subExprs = append(subExprs, &subExpr{Op: POWER, Expr: factor})
-		factor = p.parseUnary()
+		subExprs = append(subExprs, &subExpr{pos: p.span(factor.position().Start), Op: POWER, Expr: factor})
+		if factor, err = p.parseUnary(); err != nil {
+			return nil, err
+		}
 	}
 
-	subExprs = append(subExprs, &subExpr{Op: ILLEGALOP, Expr: factor})
+	subExprs = append(subExprs, &subExpr{pos: factor.position(), Op: ILLEGALOP, Expr: factor})
 
 	return &binaryExpr{
+		pos:      p.span(pos),
 		subExprs: subExprs,
-	}
+	}, nil
 }
 
-func (p *Parser) parseUnary() Node {
-	if p.CurrentToken.Type == "-" {
-		p.matchToken("-")
-		right := p.parseUnary()
-		return &unaryExpr{Op: MINUS, Right: right}
+func (p *Parser) parseUnary() (Node, error) {
+	if p.CurrentToken.Kind == tokMinus {
+		pos := p.CurrentToken.Pos
+		if _, err := p.matchToken(tokMinus); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{pos: p.span(pos), Op: MINUS, Right: right}, nil
 	}
 
 	return p.parseCall()
 }
 
-func (p *Parser) parseCall() Node {
-	primary := p.parsePrimary()
+func (p *Parser) parseCall() (Node, error) {
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
 
-	if p.CurrentToken.Type == "(" {
-		return p.finishCall(primary)
+	for p.CurrentToken.Kind == tokLParen {
+		if expr, err = p.finishCall(expr); err != nil {
+			return nil, err
+		}
 	}
-	return primary
+	return expr, nil
 }
 
-func (p *Parser) finishCall(expr Node) Node {
-	p.matchToken("(")
-
-	tExpr := expr.(*identifier)
-
-	if p.CurrentToken.Type == ")" {
-		p.matchToken(")")
-		return &callExpr{funcName: tExpr.iden}
+func (p *Parser) finishCall(callee Node) (Node, error) {
+	pos := p.CurrentToken.Pos
+	if _, err := p.matchToken(tokLParen); err != nil {
+		return nil, err
 	}
-	args := make([]Node, 0)
 
-	a1 := p.parseCmpExpr()
-	args = append(args, a1)
-	for p.CurrentToken.Type == "," {
-		p.matchToken(",")
-		a1 = p.parseCmpExpr()
-		args = append(args, a1)
+	args := make([]Node, 0)
+	for p.CurrentToken.Kind != tokRParen {
+		a, err := p.parseCmpExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if p.CurrentToken.Kind != tokRParen {
+			if _, err := p.matchToken(tokComma); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, err := p.matchToken(tokRParen); err != nil {
+		return nil, err
 	}
-	p.matchToken(")")
 
-	return &callExpr{funcName: tExpr.iden, args: args}
+	return &callExpr{pos: p.span(pos), callee: callee, args: args}, nil
 }
 
-func (p *Parser) parsePrimary() Node {
-	c := p.CurrentToken.Type
-	if c == "NUMBER" {
-		num, err := strconv.Atoi(p.matchToken("NUMBER"))
+func (p *Parser) parsePrimary() (Node, error) {
+	pos := p.CurrentToken.Pos
+	switch c := p.CurrentToken.Kind; c {
+	case tokNumber:
+		v, err := p.matchToken(tokNumber)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		return &number{num: num}
-	} else if c == "IDENTIFIER" {
-		iden := p.matchToken("IDENTIFIER")
-		return &identifier{iden: iden}
-	} else {
-		p := fmt.Sprintf("Unknown factor type %s", c)
-		panic(p)
+		num, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, newError(SyntaxError, p.span(pos), "%v", err)
+		}
+		return &number{pos: p.span(pos), num: num}, nil
+	case tokIdentifier:
+		iden, err := p.matchToken(tokIdentifier)
+		if err != nil {
+			return nil, err
+		}
+		return &identifier{pos: p.span(pos), iden: iden}, nil
+	case tokLParen:
+		if _, err := p.matchToken(tokLParen); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseCmpExpr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.matchToken(tokRParen); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	default:
+		return nil, p.unexpected(tokNumber, tokIdentifier, tokLParen)
 	}
-	return nil
 }
@@ -2,8 +2,15 @@ package calculator
 
 import "fmt"
 
+// Frame holds the variables of one scope. Lookups that miss continue in the
+// parent frame, so a chain of frames is the lexical environment of a scope.
 type Frame struct {
-	table map[string]interface{}
+	table  map[string]interface{}
+	parent *Frame
+}
+
+func NewFrame(parent *Frame) *Frame {
+	return &Frame{table: make(map[string]interface{}), parent: parent}
 }
 
 func (f *Frame) AddVar(v string, val interface{}) {
@@ -11,26 +18,25 @@ func (f *Frame) AddVar(v string, val interface{}) {
 }
 
 func (f *Frame) GetVar(v string) (interface{}, error) {
-	if val, ok := f.table[v]; ok {
-		return val, nil
+	for fr := f; fr != nil; fr = fr.parent {
+		if val, ok := fr.table[v]; ok {
+			return val, nil
+		}
 	}
 
 	return nil, fmt.Errorf("unbound variable %s", v)
 }
 
 type Env struct {
-	frames       []Frame
 	currentFrame *Frame
 }
 
 func (e *Env) CreateFrame() {
-	e.frames = append(e.frames, Frame{table: make(map[string]interface{})})
-	e.currentFrame = &e.frames[len(e.frames)-1]
+	e.currentFrame = NewFrame(e.currentFrame)
 }
 
 func (e *Env) RemoveFrame() {
-	e.frames = e.frames[:len(e.frames)-1]
-	e.currentFrame = &e.frames[len(e.frames)-1]
+	e.currentFrame = e.currentFrame.parent
 }
 
 //TODO should we check if any frames exist?
@@ -39,14 +45,16 @@ func (e *Env) AddVar(v string, val interface{}) {
 }
 
 func (e *Env) GetVar(v string) (interface{}, error) {
-	var res interface{}
-	var err error
-	for i := len(e.frames) - 1; i >= 0; i-- {
-		res, err = e.frames[i].GetVar(v)
-		if err == nil {
-			return res, nil
-		}
-	}
+	return e.currentFrame.GetVar(v)
+}
+
+// closure is a function value. It keeps the frame the function was defined
+// in so free variables resolve lexically wherever the function is called.
+type closure struct {
+	fn  *funcStmt
+	env *Frame
+}
 
-	return nil, err
+func (c *closure) String() string {
+	return fmt.Sprintf("<func %s>", c.fn.identifier)
 }
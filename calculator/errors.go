@@ -0,0 +1,78 @@
+package calculator
+
+import (
+	"fmt"
+	"lexer"
+)
+
+type ErrorKind int
+
+const (
+	SyntaxError  ErrorKind = iota //the program could not be lexed or parsed
+	NameError                     //an identifier is not bound
+	ArityError                    //a function is called with the wrong number of arguments
+	RuntimeError                  //evaluation failed, e.g. a divide by zero
+)
+
+var errorKindNames = map[ErrorKind]string{
+	SyntaxError:  "syntax error",
+	NameError:    "name error",
+	ArityError:   "arity error",
+	RuntimeError: "runtime error",
+}
+
+func (k ErrorKind) String() string {
+	return errorKindNames[k]
+}
+
+// Error is returned by Parser.Parse and Evaluator.Eval. Pos is the span of
+// the token or node the error is reported at. Syntax errors from an
+// unexpected token also record the token types that would have been
+// accepted and the type that was found.
+type Error struct {
+	Kind     ErrorKind
+	Pos      Span
+	Msg      string
+	Expected []string
+	Got      string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at %s: %s", e.Kind, e.Pos.Start, e.Msg)
+}
+
+// Diagnostic renders the error against the program source it came from,
+// pointing a caret at the offending token.
+func (e *Error) Diagnostic(src string) string {
+	return lexer.RenderDiagnostic(src, e.Pos, fmt.Sprintf("%s: %s", e.Kind, e.Msg))
+}
+
+// ErrorList is returned by Parser.Parse, it holds every syntax error found
+// in the program in source order.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Err returns nil when the list is empty and the list otherwise
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+func newError(kind ErrorKind, pos Span, format string, a ...interface{}) *Error {
+	return &Error{
+		Kind: kind,
+		Pos:  pos,
+		Msg:  fmt.Sprintf(format, a...),
+	}
+}
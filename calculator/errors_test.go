@@ -0,0 +1,62 @@
+package calculator
+
+import "testing"
+
+func TestDiagnostic(t *testing.T) {
+	p := BuildParser()
+
+	diags := map[string]string{
+		"set a = 1\nprint(a +)": `syntax error: expected one of the types [NUMBER IDENTIFIER (] got type )
+ --> 2:10
+  |
+2 | print(a +)
+  |          ^
+`,
+		"set a =\r\nprint(a)": `syntax error: expected one of the types [NUMBER IDENTIFIER (] got type NEWLINE
+ --> 1:9
+  |
+1 | set a =
+  |        ^
+`,
+		"set a = 1\nset b = 2 $ 3": `syntax error: could not match anything at position 2:11
+ --> 2:11
+  |
+2 | set b = 2 $ 3
+  |           ^
+`,
+		"1 + 1\n\tnope * 2": `name error: unbound identifier nope
+ --> 2:2
+  |
+2 | 	nope * 2
+  | 	^^^^
+`,
+		"func f(a) { a }\nf(1, 2)": `arity error: f takes 1 arguments got 2
+ --> 2:2
+  |
+2 | f(1, 2)
+  |  ^^^^^^
+`,
+	}
+
+	for src, want := range diags {
+		_, err := evalProgram(p, src)
+		cerr, ok := firstError(err)
+		if !ok {
+			t.Errorf("expected %q to fail with an *Error got %v", src, err)
+			continue
+		}
+		if got := cerr.Diagnostic(src); got != want {
+			t.Errorf("expected diagnostic for %q\n%s\ngot\n%s", src, want, got)
+		}
+	}
+
+	_, err := evalProgram(p, "print(1")
+	if cerr, _ := firstError(err); cerr.Got != "EOF" || len(cerr.Expected) != 1 || cerr.Expected[0] != ")" {
+		t.Errorf("expected ) got EOF to be recorded, got %+v", cerr)
+	}
+
+	//an error that is not at a place in the source has no caret
+	if got := newError(RuntimeError, Span{}, "unknown node type").Diagnostic("1 + 2"); got != "runtime error: unknown node type\n" {
+		t.Errorf("expected a diagnostic without a caret got %q", got)
+	}
+}
@@ -2,156 +2,334 @@ package calculator
 
 import (
 	"fmt"
+	"io"
 	"math"
+	"os"
 )
 
 func intPow(x, y int) int {
 	return int(math.Pow(float64(x), float64(y)))
 }
 
-func CreateEvaluator() *Evaluator {
-	ev := Evaluator{
-		env: Env{frames: make([]Frame, 0)},
+func boolToInt(b bool) int {
+	if b {
+		return 1
 	}
+	return 0
+}
+
+func CreateEvaluator() *Evaluator {
+	ev := Evaluator{out: os.Stdout}
 
 	ev.env.CreateFrame()
 	return &ev
 }
 
 type Evaluator struct {
-	env Env
+	env       Env
+	returning bool //set by a return statement until the call completes
+	out       io.Writer
+}
+
+// SetOutput sets where print writes to, os.Stdout by default
+func (e *Evaluator) SetOutput(w io.Writer) {
+	e.out = w
+}
+
+// Eval evaluates node and returns its value. A program whose value is a
+// function evaluates to 0. The error is an *Error describing why evaluation
+// stopped; the evaluator is left in the global frame so it can be reused.
+func (e *Evaluator) Eval(node Node) (int, error) {
+	res, err := e.EvalValue(node)
+	if n, ok := res.(int); ok {
+		return n, err
+	}
+	return 0, err
+}
+
+// EvalValue is like Eval but returns functions as well as numbers. A
+// function prints as <func name>.
+func (e *Evaluator) EvalValue(node Node) (interface{}, error) {
+	frame := e.env.currentFrame
+	res, err := e.eval(node)
+	if err != nil {
+		e.env.currentFrame = frame
+		e.returning = false
+		return nil, err
+	}
+	return res, nil
+}
+
+// Globals returns the variables defined in the global frame
+func (e *Evaluator) Globals() map[string]interface{} {
+	f := e.env.currentFrame
+	for f.parent != nil {
+		f = f.parent
+	}
+	vars := make(map[string]interface{}, len(f.table))
+	for k, v := range f.table {
+		vars[k] = v
+	}
+	return vars
 }
 
-func (e *Evaluator) Eval(node Node) int {
+// IsExpr reports whether the last statement of a program is an expression,
+// so the value of the program is the value of that expression
+func IsExpr(node Node) bool {
+	if prog, ok := node.(*programStmt); ok {
+		if len(prog.declarations) == 0 {
+			return false
+		}
+		node = prog.declarations[len(prog.declarations)-1]
+	}
+	switch node.(type) {
+	case *assignStmt, *funcStmt, *printExpr, *returnStmt:
+		return false
+	}
+	return true
+}
+
+// evalInt evaluates a node that must produce a number
+func (e *Evaluator) evalInt(node Node) (int, error) {
+	res, err := e.eval(node)
+	if err != nil {
+		return 0, err
+	}
+	if n, ok := res.(int); ok {
+		return n, nil
+	}
+	return 0, newError(RuntimeError, node.position(), "expected a number got %v", res)
+}
+
+// applyOp combines the running result of a binaryExpr with the next operand
+func applyOp(se *subExpr, res int, rhs int) (int, error) {
+	n, err := operate(se.Op, res, rhs)
+	if err != nil {
+		return 0, newError(RuntimeError, se.pos, "%s", err)
+	}
+	return n, nil
+}
+
+// operate applies a binary operator, it is shared by Eval and the VM
+func operate(op Op, lhs int, rhs int) (int, error) {
+	switch op {
+	case PLUS:
+		return lhs + rhs, nil
+	case MINUS:
+		return lhs - rhs, nil
+	case MULTIPLY:
+		return lhs * rhs, nil
+	case DIVIDE:
+		if rhs == 0 {
+			return 0, fmt.Errorf("divide by zero")
+		}
+		return lhs / rhs, nil
+	case EQ:
+		return boolToInt(lhs == rhs), nil
+	case NEQ:
+		return boolToInt(lhs != rhs), nil
+	case LT:
+		return boolToInt(lhs < rhs), nil
+	case GT:
+		return boolToInt(lhs > rhs), nil
+	case LTE:
+		return boolToInt(lhs <= rhs), nil
+	case GTE:
+		return boolToInt(lhs >= rhs), nil
+	case BITOR:
+		return lhs | rhs, nil
+	case BITXOR:
+		return lhs ^ rhs, nil
+	case BITAND:
+		return lhs & rhs, nil
+	case LSHIFT, RSHIFT:
+		if rhs < 0 {
+			return 0, fmt.Errorf("negative shift count %d", rhs)
+		}
+		if op == LSHIFT {
+			return lhs << uint(rhs), nil
+		}
+		return lhs >> uint(rhs), nil
+	}
+	return 0, fmt.Errorf("illegal operator %d", op)
+}
+
+// eval returns either an int or a *closure
+func (e *Evaluator) eval(node Node) (interface{}, error) {
 
 	switch n := node.(type) {
 	case *programStmt:
 		{
-			var res int
+			var res interface{} = 0
+			var err error
 			for _, dec := range n.declarations {
-				res = e.Eval(dec)
+				if res, err = e.eval(dec); err != nil {
+					return nil, err
+				}
+				if e.returning {
+					break
+				}
 			}
-			return res
+			return res, nil
 		}
 	case *blockStmt:
 		{
 			e.env.CreateFrame()
-			e.Eval(n.program)
+			res, err := e.eval(n.program)
 			e.env.RemoveFrame()
+			return res, err
 		}
 	case *assignStmt:
 		{
 			iden := n.identifier
-			res := e.Eval(n.expr)
+			res, err := e.eval(n.expr)
+			if err != nil {
+				return nil, err
+			}
 			e.env.AddVar(iden, res)
 		}
 	case *funcStmt:
 		{
 			iden := n.identifier
-			e.env.AddVar(iden, n)
+			e.env.AddVar(iden, &closure{fn: n, env: e.env.currentFrame})
+		}
+	case *returnStmt:
+		{
+			res, err := e.eval(n.expr)
+			if err != nil {
+				return nil, err
+			}
+			e.returning = true
+			return res, nil
 		}
 	case *printExpr:
 		{
-			res := e.Eval(n.expr)
-			fmt.Println(res)
+			res, err := e.eval(n.expr)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintln(e.out, res)
 
 		}
 	case *ifExpr:
 		{
-			res := e.Eval(n.cmpExpr)
+			res, err := e.evalInt(n.cmpExpr)
+			if err != nil {
+				return nil, err
+			}
 			//assume that 0 represents false
 			if res != 0 {
-				return e.Eval(n.thenStmt)
-			} else {
-				return e.Eval(n.elseStmt)
+				return e.eval(n.thenStmt)
+			} else if n.elseStmt != nil {
+				return e.eval(n.elseStmt)
 			}
 		}
 	case *binaryExpr:
 		{
-			var se *subExpr
+			// a single operand may be any value, e.g. a function
+			if len(n.subExprs) == 1 {
+				return e.eval(n.subExprs[0])
+			}
+
+			// a power chain is right associative, see parsePower
+			if len(n.subExprs) > 1 && n.subExprs[0].Op == POWER {
+				last := len(n.subExprs) - 1
+				res, err := e.evalInt(n.subExprs[last])
+				if err != nil {
+					return nil, err
+				}
+				for i := last - 1; i >= 0; i-- {
+					base, err := e.evalInt(n.subExprs[i])
+					if err != nil {
+						return nil, err
+					}
+					res = intPow(base, res)
+				}
+				return res, nil
+			}
 
 			res := 0
 			if len(n.subExprs) > 0 {
-				res = e.Eval(n.subExprs[0])
+				var err error
+				if res, err = e.evalInt(n.subExprs[0]); err != nil {
+					return nil, err
+				}
 			}
 
-			for i := 1; i < len(n.subExprs); i++ {
-				se = n.subExprs[i]
-
-				switch op := se.Op; op {
-				case PLUS:
-					{
-						res += e.Eval(se)
-					}
-				case MINUS:
-					{
-						res -= e.Eval(se)
-					}
-				case MULTIPLY:
-					{
-						res *= e.Eval(se)
-					}
-				case DIVIDE:
-					{
-						res /= e.Eval(se)
-					}
-				case ILLEGALOP:
-					{
-						panic("ILLEGALOP")
-					}
+			for _, se := range n.subExprs[1:] {
+				rhs, err := e.evalInt(se)
+				if err != nil {
+					return nil, err
+				}
+				if res, err = applyOp(se, res, rhs); err != nil {
+					return nil, err
 				}
 			}
-			return res
+			return res, nil
 		}
 	case *unaryExpr:
 		{
 			if n.Op == MINUS {
-				return -e.Eval(n.Right)
+				res, err := e.evalInt(n.Right)
+				return -res, err
 			}
-			return e.Eval(n.Right)
+			return e.eval(n.Right)
 		}
 	case *callExpr:
 		{
-			fi, err := e.env.GetVar(n.funcName)
+			fi, err := e.eval(n.callee)
 			if err != nil {
-				panic(fmt.Sprintf("could not find function %s", n.funcName))
+				return nil, err
+			}
+			f, ok := fi.(*closure)
+			if !ok {
+				return nil, newError(RuntimeError, n.pos, "cannot call %v, it is not a function", fi)
 			}
-			f := fi.(*funcStmt)
 
-			if len(f.params) != len(n.args) {
-				err := fmt.Sprintf("num params %d is not eql to num args %d", len(f.params), len(n.args))
-				panic(err)
+			if len(f.fn.params) != len(n.args) {
+				return nil, newError(ArityError, n.pos, "%s takes %d arguments got %d", f.fn.identifier, len(f.fn.params), len(n.args))
+			}
+			//evaluate the arguments in the caller's frame
+			args := make([]interface{}, len(n.args))
+			for i, a := range n.args {
+				if args[i], err = e.eval(a); err != nil {
+					return nil, err
+				}
 			}
-			e.env.CreateFrame()
 
-			var na int
-			for i := 0; i < len(f.params); i++ {
-				na = e.Eval(n.args[i])
-				e.env.AddVar(f.params[i], na)
+			//the body runs in a frame whose parent is the defining frame
+			caller := e.env.currentFrame
+			e.env.currentFrame = f.env
+			e.env.CreateFrame()
+			for i := 0; i < len(f.fn.params); i++ {
+				e.env.AddVar(f.fn.params[i], args[i])
 			}
 
-			e.Eval(f.block)
+			res, err := e.eval(f.fn.block)
+			e.returning = false
+			e.env.currentFrame = caller
+			return res, err
 		}
 	case *subExpr:
 		{
-			return e.Eval(n.Expr)
+			return e.eval(n.Expr)
 		}
 	case *number:
 		{
-			return n.num
+			return n.num, nil
 		}
 	case *identifier:
 		{
 			if val, err := e.env.GetVar(n.iden); err == nil {
-				return val.(int)
+				return val, nil
 			}
-			panic(fmt.Sprintf("unbound indentifier %s", n.iden))
+			return nil, newError(NameError, n.pos, "unbound identifier %s", n.iden)
 		}
 	default:
 		{
-			panic(fmt.Sprintf("Unknown type %T", n))
+			return nil, newError(RuntimeError, Span{}, "unknown node type %T", n)
 		}
 	}
 
-	return 0
+	return 0, nil
 }
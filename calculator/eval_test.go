@@ -1,30 +1,364 @@
 package calculator
 
-import "testing"
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// evalProgram parses and evaluates expr with a fresh evaluator
+func evalProgram(p *Parser, expr string) (int, error) {
+	parsed, err := p.Parse(expr)
+	if err != nil {
+		return 0, err
+	}
+	return CreateEvaluator().Eval(parsed)
+}
+
+// firstError returns the *Error reported first by Parse or Eval
+func firstError(err error) (*Error, bool) {
+	if l, ok := err.(ErrorList); ok && len(l) > 0 {
+		return l[0], true
+	}
+	cerr, ok := err.(*Error)
+	return cerr, ok
+}
+
+// mathExprs are evaluated in turn by one evaluator
+var mathExprs = map[string]int{
+	//"3*4+2-3+5":                  16,
+	"2+2":   4,
+	"4-6+3": 1,
+	"-2":    -2,
+	//"2*7*11":                     154,
+	/*`set abc = 23
+	set cde = 34
+	set hello = 42
+	abc + cde + hello`: 99,*/
+	`func hello(a, b) { print(a+b) }
+	hello(2,3)`: 0,
+}
 
 func TestEvaluator(t *testing.T) {
 	p := BuildParser()
 	eval := CreateEvaluator()
 
-	mathExprs := map[string]int{
-		//"3*4+2-3+5":                  16,
-		"2+2":   4,
-		"4-6+3": 1,
-		"-2":    -2,
-		//"2*7*11":                     154,
-		/*`set abc = 23
-		set cde = 34
-		set hello = 42
-		abc + cde + hello`: 99,*/
-		`func hello(a, b) { print(a+b) }
-		hello(2,3)`: 0,
+	for expr, res := range mathExprs {
+		parsed, err := p.Parse(expr)
+		if err != nil {
+			t.Fatalf("could not parse %s: %v", expr, err)
+		}
+		calcRes, err := eval.Eval(parsed)
+		if err != nil {
+			t.Fatalf("could not evaluate %s: %v", expr, err)
+		}
+		if calcRes != res {
+			t.Errorf("expected %s to evaluate to %d got %d", expr, res, calcRes)
+		}
+	}
+}
+
+// cmpExprs map programs using comparisons and if to their values
+var cmpExprs = map[string]int{
+	"2 == 2":                   1,
+	"2 != 2":                   0,
+	"1 < 2":                    1,
+	"2 > 1+3":                  0,
+	"3 <= 3":                   1,
+	"2*2 >= 5":                 0,
+	"-1 < 0":                   1,
+	"if 1 < 2 then 10 else 20": 10,
+	"if 2 < 1 then 10 else 20": 20,
+	"if 2 < 1 then 10":         0,
+	`set a = 4
+	set b = 7
+	if a >= b then a else b`: 7,
+}
+
+func TestComparison(t *testing.T) {
+	p := BuildParser()
+
+	for expr, res := range cmpExprs {
+		calcRes, err := evalProgram(p, expr)
+		if err != nil {
+			t.Errorf("could not evaluate %s: %v", expr, err)
+			continue
+		}
+		if calcRes != res {
+			t.Errorf("expected %s to evaluate to %d got %d", expr, res, calcRes)
+		}
 	}
+}
 
-	for expr, res := range mathExprs {
-		parsed := p.Parse(expr)
-		calcRes := eval.Eval(parsed)
+// bitExprs map programs using the bitwise and power operators to their values
+var bitExprs = map[string]int{
+	"6 | 9":           15,
+	"6 ^ 3":           5,
+	"6 & 3":           2,
+	"1 << 4":          16,
+	"256 >> 2":        64,
+	"1 << 2 + 1":      8,
+	"1 | 2 ^ 3 & 1":   3,
+	"12 & 10 == 8":    1,
+	"2 ** 3":          8,
+	"2 ** 3 ** 2":     512,
+	"2 ** 3 * 2 + 43": 59,
+	`set flags = 5
+	set mask = 1 << 2
+	flags & mask != 0`: 1,
+}
+
+func TestBitwise(t *testing.T) {
+	p := BuildParser()
+
+	for expr, res := range bitExprs {
+		calcRes, err := evalProgram(p, expr)
+		if err != nil {
+			t.Errorf("could not evaluate %s: %v", expr, err)
+			continue
+		}
+		if calcRes != res {
+			t.Errorf("expected %s to evaluate to %d got %d", expr, res, calcRes)
+		}
+	}
+}
+
+// funcExprs map programs that call functions to their values
+var funcExprs = map[string]int{
+	`func add(a, b) { return a + b }
+	add(2, 3)`: 5,
+	`func last(a) { set b = a * 2; b + 1 }
+	last(4)`: 9,
+	`func max(a, b) { if a > b then return a
+	return b }
+	max(3, 8) + max(9, 1)`: 17,
+	`func early(a) { { if a < 0 then { return 0 - a } }; print(a); return a }
+	early(-6)`: 6,
+	`func fact(n) { if n <= 1 then return 1 else return n * fact(n - 1) }
+	fact(5)`: 120,
+	`set a = 1
+	func shadow(a) { return a }
+	shadow(7) + a`: 8,
+}
+
+func TestReturn(t *testing.T) {
+	p := BuildParser()
+
+	for expr, res := range funcExprs {
+		eval := CreateEvaluator()
+		parsed, err := p.Parse(expr)
+		if err != nil {
+			t.Fatalf("could not parse %s: %v", expr, err)
+		}
+		calcRes, err := eval.Eval(parsed)
+		if err != nil {
+			t.Errorf("could not evaluate %s: %v", expr, err)
+		}
+		if calcRes != res {
+			t.Errorf("expected %s to evaluate to %d got %d", expr, res, calcRes)
+		}
+		if eval.env.currentFrame.parent != nil {
+			t.Errorf("expected %s to return to the global frame", expr)
+		}
+	}
+}
+
+// closureExprs map programs using functions as values to their values
+var closureExprs = map[string]int{
+	`func double(x) { return x * 2 }
+	func apply(f, x) { return f(x) }
+	apply(double, 21)`: 42,
+	`func double(x) { return x * 2 }
+	set g = double
+	g(4)`: 8,
+	`func adder(n) { func add(x) { return x + n }; return add }
+	set plusfive = adder(5)
+	plusfive(10) + adder(1)(2)`: 18,
+	`func plus(a, b) { return a + b }
+	func fold(f, acc, n) { if n == 0 then return acc; return fold(f, f(acc, n), n - 1) }
+	fold(plus, 0, 4)`: 10,
+	`set x = 1
+	func getx() { return x }
+	func shadow(x) { return getx() }
+	shadow(99)`: 1,
+	`func counter() { set c = 10; func next(d) { return c + d }; return next }
+	(counter())(5)`: 15,
+	`func id(f) { return f }
+	id(id)(id)(3)`: 3,
+	"(2 + 3) * 4": 20,
+}
+
+func TestClosure(t *testing.T) {
+	p := BuildParser()
+
+	for expr, res := range closureExprs {
+		calcRes, err := evalProgram(p, expr)
+		if err != nil {
+			t.Errorf("could not evaluate %s: %v", expr, err)
+			continue
+		}
 		if calcRes != res {
 			t.Errorf("expected %s to evaluate to %d got %d", expr, res, calcRes)
 		}
 	}
 }
+
+// commentExprs map programs with comments to their values
+var commentExprs = map[string]int{
+	"2 + 3 # five":                   5,
+	"2 + 3 // five":                  5,
+	"6 / 2":                          3,
+	"2 + /* three */ 3":              5,
+	"# set a = 1\nset a = 2\na":      2,
+	"set a = 1 /* a\nb */ a + 1":     2,
+	"{ # empty\n}; 4":                4,
+	"set a = 1;; set b = 2\n\n\na+b": 3,
+	`func f(x) {
+		/* doubles x */
+		return x * 2 // twice
+	}
+	f(4)`: 8,
+}
+
+func TestComments(t *testing.T) {
+	p := BuildParser()
+
+	for expr, res := range commentExprs {
+		calcRes, err := evalProgram(p, expr)
+		if err != nil {
+			t.Errorf("could not evaluate %q: %v", expr, err)
+			continue
+		}
+		if calcRes != res {
+			t.Errorf("expected %q to evaluate to %d got %d", expr, res, calcRes)
+		}
+	}
+
+	src, err := ioutil.ReadFile("testdata/fold.calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := evalProgram(p, string(src)); err != nil || res != 34 {
+		t.Errorf("expected testdata/fold.calc to evaluate to 34 got %d, %v", res, err)
+	}
+}
+
+// errExprs map failing programs to the kind and offset of their first error
+var errExprs = map[string]struct {
+	kind ErrorKind
+	pos  int
+}{
+	"2 +":                         {SyntaxError, 3},
+	"set = 4":                     {SyntaxError, 4},
+	"print(2":                     {SyntaxError, 7},
+	"{ 1; 2":                      {SyntaxError, 6},
+	"2 $ 3":                       {SyntaxError, 2},
+	"return 2":                    {SyntaxError, 0},
+	"1 + nope":                    {NameError, 4},
+	"func f(a) { return a }; f()": {ArityError, 25},
+	"4 / (2 - 2)":                 {RuntimeError, 2},
+	"1 << -1":                     {RuntimeError, 2},
+	"set a = 3; a(1)":             {RuntimeError, 12},
+	"1 /* never closed":           {SyntaxError, 2},
+	"func f() { 1 }; f + 1":       {RuntimeError, 16},
+}
+
+func TestErrors(t *testing.T) {
+	p := BuildParser()
+
+	for expr, want := range errExprs {
+		_, err := evalProgram(p, expr)
+		cerr, ok := firstError(err)
+		if !ok {
+			t.Errorf("expected %s to fail with an *Error got %v", expr, err)
+			continue
+		}
+		if cerr.Kind != want.kind || cerr.Pos.Start.Offset != want.pos {
+			t.Errorf("expected %s to fail with %s at %d got %v", expr, want.kind, want.pos, cerr)
+		}
+	}
+}
+
+// afterError is a session whose last program fails inside a call
+var afterError = []string{"set a = 5", "func f(x) { { return x / 0 } }", "f(a)"}
+
+func TestEvalAfterError(t *testing.T) {
+	p := BuildParser()
+	eval := CreateEvaluator()
+
+	for _, expr := range afterError {
+		parsed, err := p.Parse(expr)
+		if err != nil {
+			t.Fatalf("could not parse %s: %v", expr, err)
+		}
+		eval.Eval(parsed)
+	}
+
+	parsed, _ := p.Parse("a + 1")
+	res, err := eval.Eval(parsed)
+	if err != nil || res != 6 {
+		t.Errorf("expected a + 1 to evaluate to 6 got %d, %v", res, err)
+	}
+	if eval.env.currentFrame.parent != nil {
+		t.Errorf("expected the failed call to return to the global frame")
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	p := BuildParser()
+
+	src := `set a = 1 +
+set b = 2
+func f(x) { set y = ; return x $ 2 }
+print(a b)
+{ 3 + }
+set c = 4`
+
+	parsed, err := p.Parse(src)
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected an ErrorList got %v", err)
+	}
+
+	want := []Position{
+		{Offset: 11, Line: 1, Col: 12},
+		{Offset: 42, Line: 3, Col: 21},
+		{Offset: 53, Line: 3, Col: 32},
+		{Offset: 67, Line: 4, Col: 9},
+		{Offset: 76, Line: 5, Col: 7},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors got %d: %v", len(want), len(errs), errs)
+	}
+	for i, e := range errs {
+		if e.Kind != SyntaxError || e.Pos.Start != want[i] {
+			t.Errorf("expected error %d to be a syntax error at %s got %v", i, want[i], e)
+		}
+	}
+
+	prog := parsed.(*programStmt)
+	if len(prog.declarations) != 4 {
+		t.Errorf("expected the partial ast to keep 4 statements got %d", len(prog.declarations))
+	}
+}
+
+// TestReadError reads programs whose input fails after the first chunk,
+// both while parsing a statement and while skipping a malformed one
+func TestReadError(t *testing.T) {
+	p := BuildParser()
+	for _, src := range []string{"set a = 1\nset b = (", "set a = 1\nset b = 1 $ 2 3"} {
+		parsed, err := p.ParseReader(iotest.TimeoutReader(strings.NewReader(src)))
+		errs, ok := err.(ErrorList)
+		if !ok {
+			t.Fatalf("expected an ErrorList for %q got %v", src, err)
+		}
+		last := errs[len(errs)-1]
+		if last.Kind != SyntaxError || last.Msg != iotest.ErrTimeout.Error() || last.Pos.Start.Offset != len(src) {
+			t.Errorf("expected %q to end with the read error at %d got %v", src, len(src), errs)
+		}
+		if prog := parsed.(*programStmt); len(prog.declarations) != 1 {
+			t.Errorf("expected %q to keep the first statement got %d", src, len(prog.declarations))
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package calculator
+
+import (
+	"calctools/layout"
+	"io"
+	"math"
+	"strings"
+)
+
+// precedence levels of the expression grammar, from the loosest binding
+// cmp_expr up to primary, see the EBNF in ebnf_parser.go
+const (
+	anyLevel = iota
+	cmpLevel
+	bitOrLevel
+	bitXorLevel
+	bitAndLevel
+	shiftLevel
+	arithLevel
+	termLevel
+	powerLevel
+	unaryLevel
+	primaryLevel
+)
+
+var opLevels = map[Op]int{
+	EQ:       cmpLevel,
+	NEQ:      cmpLevel,
+	LT:       cmpLevel,
+	GT:       cmpLevel,
+	LTE:      cmpLevel,
+	GTE:      cmpLevel,
+	BITOR:    bitOrLevel,
+	BITXOR:   bitXorLevel,
+	BITAND:   bitAndLevel,
+	LSHIFT:   shiftLevel,
+	RSHIFT:   shiftLevel,
+	PLUS:     arithLevel,
+	MINUS:    arithLevel,
+	MULTIPLY: termLevel,
+	DIVIDE:   termLevel,
+	POWER:    powerLevel,
+}
+
+// level returns the precedence level of the expression node, binary
+// expressions with a single operand take the level of their operand
+func level(node Node) int {
+	switch n := node.(type) {
+	case *binaryExpr:
+		switch {
+		case len(n.subExprs) == 1:
+			return level(n.subExprs[0])
+		case len(n.subExprs) > 1 && n.subExprs[0].Op == POWER:
+			return powerLevel
+		case len(n.subExprs) > 1:
+			return opLevels[n.subExprs[1].Op]
+		}
+	case *subExpr:
+		return level(n.Expr)
+	case *unaryExpr:
+		return unaryLevel
+	case *number:
+		//only Optimize makes negative numbers
+		if n.num < 0 {
+			return unaryLevel
+		}
+	}
+	return primaryLevel
+}
+
+// formatter writes an ast as source, the layout.Writer indents its lines
+// and places the comments around them
+type formatter struct {
+	*layout.Writer
+}
+
+// Format writes the ast rooted at node to w as source. The source is in a
+// canonical form: one statement per line, blocks indented by tabs, one
+// space around binary operators and parentheses only where the grammar
+// needs them. Parsing it gives an ast with the same structure as node,
+// apart from the parentheses that were left out. A program is written with
+// a newline after every statement.
+func Format(w io.Writer, node Node) {
+	f := &formatter{Writer: layout.NewWriter(w, nil)}
+	f.node(node)
+}
+
+// FormatSource parses src and returns it formatted like Format. Unlike the
+// ast, it keeps the comments of src: a comment is written on the line it was
+// on, before the statement that follows it or after the statement that ends
+// on its line. The error is the ErrorList of the syntax errors in src.
+func FormatSource(src string) (string, error) {
+	p := BuildParser()
+	p.Lexer.KeepComments(true)
+	node, err := p.Parse(src)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	f := &formatter{Writer: layout.NewWriter(&sb, p.Lexer.Comments())}
+	f.node(node)
+	return sb.String(), nil
+}
+
+// stmts writes each statement on a line of its own, end is where the
+// program or block holding them ends
+func (f *formatter) stmts(stmts []Node, end Position) {
+	for _, s := range stmts {
+		pos := s.position()
+		f.CommentsBefore(pos.Start)
+		f.Gap(pos.Start.Line)
+		f.Indent()
+		f.node(s)
+		f.Line = pos.End.Line
+		f.Trailing(pos.End, end)
+		f.Printf("\n")
+	}
+	f.CommentsBefore(end)
+}
+
+// openIf reports whether node is an if that would take an else written
+// after it
+func openIf(node Node) bool {
+	n, ok := node.(*ifExpr)
+	return ok && (n.elseStmt == nil || openIf(n.elseStmt))
+}
+
+func (f *formatter) node(node Node) {
+	switch n := node.(type) {
+	case *programStmt:
+		//the comments after the last statement are part of the program
+		f.stmts(n.declarations, Position{Offset: math.MaxInt32})
+	case *blockStmt:
+		prog, ok := n.program.(*programStmt)
+		if !ok {
+			f.Printf("{ ")
+			f.node(n.program)
+			f.Printf(" }")
+			return
+		}
+		if len(prog.declarations) == 0 && !f.HasComments(n.pos.End) {
+			f.Printf("{}")
+			return
+		}
+		f.Printf("{\n")
+		f.Depth++
+		f.Line = 0
+		f.stmts(prog.declarations, n.pos.End)
+		f.Depth--
+		f.Indent()
+		f.Printf("}")
+	case *assignStmt:
+		f.Printf("set %s = ", n.identifier)
+		f.expr(n.expr, anyLevel)
+	case *funcStmt:
+		f.Printf("func %s(%s) ", n.identifier, strings.Join(n.params, ", "))
+		f.node(n.block)
+	case *returnStmt:
+		f.Printf("return ")
+		f.expr(n.expr, anyLevel)
+	case *printExpr:
+		f.Printf("print(")
+		f.expr(n.expr, anyLevel)
+		f.Printf(")")
+	case *ifExpr:
+		f.Printf("if ")
+		f.expr(n.cmpExpr, anyLevel)
+		f.Printf(" then ")
+		if n.elseStmt != nil && openIf(n.thenStmt) {
+			//the else would go to the inner if, no source parses to this
+			//ast but a block keeps its meaning
+			f.node(&blockStmt{pos: n.thenStmt.position(), program: &programStmt{
+				pos:          n.thenStmt.position(),
+				declarations: []Node{n.thenStmt},
+			}})
+		} else {
+			f.node(n.thenStmt)
+		}
+		if n.elseStmt != nil {
+			f.Printf(" else ")
+			f.node(n.elseStmt)
+		}
+	default:
+		f.expr(node, anyLevel)
+	}
+}
+
+// expr writes an expression that the grammar expects at level min, in
+// parentheses when it binds looser than that
+func (f *formatter) expr(node Node, min int) {
+	if level(node) < min {
+		f.Printf("(")
+		f.expr(node, anyLevel)
+		f.Printf(")")
+		return
+	}
+
+	switch n := node.(type) {
+	case *binaryExpr:
+		if len(n.subExprs) == 1 {
+			f.expr(n.subExprs[0].Expr, min)
+			return
+		}
+		//a power chain is right associative and its operands are unary
+		//expressions, the operands of the other chains bind tighter than
+		//the chain
+		if n.subExprs[0].Op == POWER {
+			for i, se := range n.subExprs {
+				if i > 0 {
+					f.Printf(" %s ", POWER)
+				}
+				f.expr(se.Expr, unaryLevel)
+			}
+			return
+		}
+		operand := level(n) + 1
+		for i, se := range n.subExprs {
+			if i > 0 {
+				f.Printf(" %s ", se.Op)
+			}
+			f.expr(se.Expr, operand)
+		}
+	case *subExpr:
+		f.expr(n.Expr, min)
+	case *unaryExpr:
+		f.Printf("%s", n.Op)
+		f.expr(n.Right, unaryLevel)
+	case *callExpr:
+		f.expr(n.callee, primaryLevel)
+		f.Printf("(")
+		for i, a := range n.args {
+			if i > 0 {
+				f.Printf(", ")
+			}
+			f.expr(a, anyLevel)
+		}
+		f.Printf(")")
+	case *number:
+		f.Printf("%d", n.num)
+	case *identifier:
+		f.Printf("%s", n.iden)
+	default:
+		f.node(node)
+	}
+}
@@ -0,0 +1,167 @@
+package calculator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+var positions = regexp.MustCompile(` \d+:\d+\n`)
+
+// shape is the Dump of node without positions, it tells asts apart by
+// their structure
+func shape(node Node) string {
+	var out bytes.Buffer
+	Dump(&out, node)
+	return positions.ReplaceAllString(out.String(), "\n")
+}
+
+func TestFormat(t *testing.T) {
+	p := BuildParser()
+
+	formatted := map[string]string{
+		"1+2*3":                        "1 + 2 * 3\n",
+		"(1+2)*3":                      "(1 + 2) * 3\n",
+		"((a))":                        "a\n",
+		"a - (b - c)":                  "a - (b - c)\n",
+		"(a - b) - c":                  "(a - b) - c\n",
+		"a - b - c":                    "a - b - c\n",
+		"2 ** (3 ** 2)":                "2 ** (3 ** 2)\n",
+		"(2 ** 3) ** 2":                "(2 ** 3) ** 2\n",
+		"-2 ** 2":                      "-2 ** 2\n",
+		"-(2 ** 2)":                    "-(2 ** 2)\n",
+		"- - a":                        "--a\n",
+		"(a < b) == (c | d ^ e)":       "(a < b) == c | d ^ e\n",
+		"(a | b) & c << 1":             "(a | b) & c << 1\n",
+		"(f)(1)(g(2,3))":               "f(1)(g(2, 3))\n",
+		"(-f)(1)":                      "(-f)(1)\n",
+		"set x=(1)":                    "set x = 1\n",
+		"print((x))":                   "print(x)\n",
+		"{}; { };{\n\n}":               "{}\n{}\n{}\n",
+		"if (a) then b else c":         "if a then b else c\n",
+		"if a then if b then c else d": "if a then if b then c else d\n",
+		"func f(a,b){return a+b}":      "func f(a, b) {\n\treturn a + b\n}\n",
+		"func f() { { 1 }; if 1 then { 2 } else { 3 } }": "func f() {\n\t{\n\t\t1\n\t}\n\tif 1 then {\n\t\t2\n\t} else {\n\t\t3\n\t}\n}\n",
+		"a\n\n\n\nb\nc": "a\n\nb\nc\n",
+	}
+	for src, want := range formatted {
+		node, err := p.Parse(src)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", src, err)
+		}
+		var out bytes.Buffer
+		Format(&out, node)
+		if out.String() != want {
+			t.Errorf("expected %q to format as %q got %q", src, want, out.String())
+		}
+	}
+
+	//an else that belongs to the outer if needs the inner one in a block
+	node, _ := p.Parse("if a then if b then c else d")
+	outer := node.(*programStmt).declarations[0].(*ifExpr)
+	inner := outer.thenStmt.(*ifExpr)
+	outer.elseStmt, inner.elseStmt = inner.elseStmt, nil
+	var out bytes.Buffer
+	Format(&out, node)
+	if want := "if a then {\n\tif b then c\n} else d\n"; out.String() != want {
+		t.Errorf("expected %q got %q", want, out.String())
+	}
+
+	//every program of the differential sessions and testdata round trips
+	srcs := make([]string, 0)
+	for _, s := range sessions {
+		srcs = append(srcs, s...)
+	}
+	files, err := filepath.Glob("testdata/*.calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srcs = append(srcs, string(src))
+	}
+	for src := range formatted {
+		srcs = append(srcs, src)
+	}
+
+	for _, src := range srcs {
+		node, err := p.Parse(src)
+		if err != nil {
+			continue
+		}
+		var out bytes.Buffer
+		Format(&out, node)
+		again, err := p.Parse(out.String())
+		if err != nil {
+			t.Errorf("could not parse %q formatted as %q: %v", src, out.String(), err)
+			continue
+		}
+		if shape(again) != shape(node) {
+			t.Errorf("expected %q formatted as %q to parse to\n%sgot\n%s", src, out.String(), shape(node), shape(again))
+		}
+
+		var twice bytes.Buffer
+		Format(&twice, again)
+		if twice.String() != out.String() {
+			t.Errorf("expected formatting %q to be stable got %q then %q", src, out.String(), twice.String())
+		}
+	}
+}
+
+func TestFormatSource(t *testing.T) {
+	src := `# leading
+
+func plus(a,b) { return a+b }  // sum
+func f(x) {
+	# first
+	set y = x   /* trailing */
+
+
+	y * 2
+	// last
+}
+{ # empty
+}
+f(1) # end
+/* block
+   comment */`
+
+	want := `# leading
+
+func plus(a, b) {
+	return a + b
+} // sum
+func f(x) {
+	# first
+	set y = x /* trailing */
+
+	y * 2
+	// last
+}
+{
+	# empty
+}
+f(1) # end
+/* block
+   comment */
+`
+	got, err := FormatSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	if again, _ := FormatSource(got); again != got {
+		t.Errorf("expected formatting to be stable got\n%s", again)
+	}
+
+	if _, err := FormatSource("set = 1"); err == nil {
+		t.Errorf("expected a syntax error")
+	}
+}
@@ -1,3 +1,13 @@
 module calculator
 
 go 1.14
+
+require (
+	calctools v0.0.0
+	lexer v0.0.0
+)
+
+replace (
+	calctools => ../calctools
+	lexer => ../lexer
+)
@@ -0,0 +1,105 @@
+package calculator
+
+import (
+	"calctools/graph"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// astGraph draws an ast with the labels Dump gives its nodes
+type astGraph struct {
+	*graph.Graph
+}
+
+// DumpDOT writes the ast rooted at node to w as a Graphviz DOT graph, e.g.
+// for `dot -Tsvg`. A node is labelled like in Dump. A chain of binary
+// operators is drawn as the tree it is evaluated as, nested to the left or,
+// for **, to the right, so each operator has its two operands below it.
+func DumpDOT(w io.Writer, node Node) {
+	newGraph(node).WriteDOT(w)
+}
+
+// DumpMermaid writes the ast rooted at node to w as a Mermaid flowchart,
+// drawn like DumpDOT
+func DumpMermaid(w io.Writer, node Node) {
+	newGraph(node).WriteMermaid(w)
+}
+
+func newGraph(node Node) astGraph {
+	g := astGraph{&graph.Graph{}}
+	g.draw(node, -1, "")
+	return g
+}
+
+// draw adds node and the nodes below it
+func (g astGraph) draw(node Node, parent int, edgeLabel string) {
+	add := func(format string, a ...interface{}) int {
+		return g.Add(fmt.Sprintf(format, a...), parent, edgeLabel)
+	}
+
+	switch n := node.(type) {
+	case *programStmt:
+		id := add("program")
+		for _, dec := range n.declarations {
+			g.draw(dec, id, "")
+		}
+	case *blockStmt:
+		id := add("block")
+		g.draw(n.program, id, "")
+	case *assignStmt:
+		g.draw(n.expr, add("set %s", n.identifier), "")
+	case *funcStmt:
+		g.draw(n.block, add("func %s(%s)", n.identifier, strings.Join(n.params, ", ")), "")
+	case *returnStmt:
+		g.draw(n.expr, add("return"), "")
+	case *printExpr:
+		g.draw(n.expr, add("print"), "")
+	case *ifExpr:
+		id := add("if")
+		g.draw(n.cmpExpr, id, "cond")
+		g.draw(n.thenStmt, id, "then")
+		if n.elseStmt != nil {
+			g.draw(n.elseStmt, id, "else")
+		}
+	case *binaryExpr:
+		g.chain(n.subExprs, parent, edgeLabel)
+	case *subExpr:
+		g.draw(n.Expr, parent, edgeLabel)
+	case *unaryExpr:
+		g.draw(n.Right, add("unary %s", n.Op), "")
+	case *callExpr:
+		id := add("call")
+		g.draw(n.callee, id, "callee")
+		for _, a := range n.args {
+			g.draw(a, id, "")
+		}
+	case *number:
+		add("number %d", n.num)
+	case *identifier:
+		add("identifier %s", n.iden)
+	default:
+		add("%T", n)
+	}
+}
+
+// chain adds the operands of a binary expression below the operators that
+// combine them, the way Evaluator combines them. The operator of a power
+// chain follows its operand, any other operator comes before it.
+func (g astGraph) chain(subExprs []*subExpr, parent int, edgeLabel string) {
+	last := len(subExprs) - 1
+	switch {
+	case last < 0:
+		g.Add("binary", parent, edgeLabel)
+	case last == 0:
+		g.draw(subExprs[0], parent, edgeLabel)
+	case subExprs[0].Op == POWER:
+		id := g.Add(subExprs[0].Op.String(), parent, edgeLabel)
+		g.draw(subExprs[0], id, "")
+		g.chain(subExprs[1:], id, "")
+	default:
+		id := g.Add(subExprs[last].Op.String(), parent, edgeLabel)
+		g.chain(subExprs[:last], id, "")
+		g.draw(subExprs[last], id, "")
+	}
+}
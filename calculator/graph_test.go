@@ -0,0 +1,97 @@
+package calculator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDumpGraph(t *testing.T) {
+	node, err := BuildParser().Parse(`set a = 2 ** 3 ** 2 - 1 - a
+if a < 1 then print(-f(a))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//- nests to the left and ** to the right
+	want := `digraph ast {
+	node [shape=box, fontname=monospace];
+	n0 [label="program"];
+	n1 [label="set a"];
+	n2 [label="-"];
+	n3 [label="-"];
+	n4 [label="**"];
+	n5 [label="number 2"];
+	n6 [label="**"];
+	n7 [label="number 3"];
+	n8 [label="number 2"];
+	n9 [label="number 1"];
+	n10 [label="identifier a"];
+	n11 [label="if"];
+	n12 [label="<"];
+	n13 [label="identifier a"];
+	n14 [label="number 1"];
+	n15 [label="print"];
+	n16 [label="unary -"];
+	n17 [label="call"];
+	n18 [label="identifier f"];
+	n19 [label="identifier a"];
+	n0 -> n1;
+	n1 -> n2;
+	n2 -> n3;
+	n3 -> n4;
+	n4 -> n5;
+	n4 -> n6;
+	n6 -> n7;
+	n6 -> n8;
+	n3 -> n9;
+	n2 -> n10;
+	n0 -> n11;
+	n11 -> n12 [label="cond"];
+	n12 -> n13;
+	n12 -> n14;
+	n11 -> n15 [label="then"];
+	n15 -> n16;
+	n16 -> n17;
+	n17 -> n18 [label="callee"];
+	n17 -> n19;
+}
+`
+	var out strings.Builder
+	DumpDOT(&out, node)
+	if out.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out.String())
+	}
+
+	node, err = BuildParser().Parse("if 1 >= 2 then { print(3) } else print(4)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `flowchart TD
+	n0["program"]
+	n1["if"]
+	n2["#gt;="]
+	n3["number 1"]
+	n4["number 2"]
+	n5["block"]
+	n6["program"]
+	n7["print"]
+	n8["number 3"]
+	n9["print"]
+	n10["number 4"]
+	n0 --> n1
+	n1 -->|"cond"| n2
+	n2 --> n3
+	n2 --> n4
+	n1 -->|"then"| n5
+	n5 --> n6
+	n6 --> n7
+	n7 --> n8
+	n1 -->|"else"| n9
+	n9 --> n10
+`
+	out.Reset()
+	DumpMermaid(&out, node)
+	if out.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out.String())
+	}
+}
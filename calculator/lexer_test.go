@@ -0,0 +1,35 @@
+package calculator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaximalMunch(t *testing.T) {
+	p := BuildParser()
+
+	lexed := map[string][]string{
+		"settings":         {"IDENTIFIER"},
+		"set settings = 1": {"SET", "IDENTIFIER", "=", "NUMBER"},
+		"iffy if":          {"IDENTIFIER", "IF"},
+		"offset add5 x1":   {"IDENTIFIER", "IDENTIFIER", "IDENTIFIER"},
+		"printer returned": {"IDENTIFIER", "IDENTIFIER"},
+		"a<=b<<2**3":       {"IDENTIFIER", "<=", "IDENTIFIER", "<<", "NUMBER", "**", "NUMBER"},
+		"a == b = c":       {"IDENTIFIER", "==", "IDENTIFIER", "=", "IDENTIFIER"},
+	}
+
+	for src, want := range lexed {
+		p.Lexer.Input(src)
+		toks, err := p.Lexer.Tokens()
+		if err != nil {
+			t.Fatalf("could not lex %q: %v", src, err)
+		}
+		got := make([]string, len(toks))
+		for i, tok := range toks {
+			got[i] = calcRules.Name(tok.Kind)
+		}
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("expected %q to lex as %v got %v", src, want, got)
+		}
+	}
+}
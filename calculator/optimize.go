@@ -0,0 +1,167 @@
+package calculator
+
+// Optimize returns a simpler program that evaluates to the same value with
+// the same output and errors. It folds operators whose operands are
+// numbers, removes binary expressions with a single operand, drops the
+// operands of x+0, x-0, x*1 and x/1, and replaces an if with a constant
+// condition by its branch. Operators that would fail, e.g. 1/0, are left
+// to fail when the program runs. node is not changed.
+func Optimize(node Node) Node {
+	switch n := node.(type) {
+	case *programStmt:
+		decs := make([]Node, len(n.declarations))
+		for i, dec := range n.declarations {
+			decs[i] = Optimize(dec)
+		}
+		return &programStmt{pos: n.pos, declarations: decs}
+	case *blockStmt:
+		return &blockStmt{pos: n.pos, program: Optimize(n.program)}
+	case *assignStmt:
+		return &assignStmt{pos: n.pos, identifier: n.identifier, expr: Optimize(n.expr)}
+	case *funcStmt:
+		return &funcStmt{pos: n.pos, identifier: n.identifier, params: n.params, block: Optimize(n.block)}
+	case *returnStmt:
+		return &returnStmt{pos: n.pos, expr: Optimize(n.expr)}
+	case *printExpr:
+		return &printExpr{pos: n.pos, expr: Optimize(n.expr)}
+	case *ifExpr:
+		cond := Optimize(n.cmpExpr)
+		var then, els Node = Optimize(n.thenStmt), nil
+		if n.elseStmt != nil {
+			els = Optimize(n.elseStmt)
+		}
+		if c, ok := cond.(*number); ok {
+			switch {
+			case c.num != 0:
+				return then
+			case els != nil:
+				return els
+			}
+			return &number{pos: n.pos, num: 0}
+		}
+		return &ifExpr{pos: n.pos, cmpExpr: cond, thenStmt: then, elseStmt: els}
+	case *binaryExpr:
+		return optimizeBinary(n)
+	case *subExpr:
+		return &subExpr{pos: n.pos, Op: n.Op, Expr: Optimize(n.Expr)}
+	case *unaryExpr:
+		right := Optimize(n.Right)
+		if n.Op != MINUS {
+			return right
+		}
+		if r, ok := right.(*number); ok {
+			return &number{pos: n.pos, num: -r.num}
+		}
+		return &unaryExpr{pos: n.pos, Op: n.Op, Right: right}
+	case *callExpr:
+		args := make([]Node, len(n.args))
+		for i, a := range n.args {
+			args[i] = Optimize(a)
+		}
+		return &callExpr{pos: n.pos, callee: Optimize(n.callee), args: args}
+	}
+	return node
+}
+
+// constant returns the value of a number node
+func constant(node Node) (int, bool) {
+	if n, ok := node.(*number); ok {
+		return n.num, true
+	}
+	return 0, false
+}
+
+// identity reports whether applying op with the constant rhs leaves the
+// running result of a binaryExpr unchanged
+func identity(op Op, rhs int) bool {
+	switch op {
+	case PLUS, MINUS:
+		return rhs == 0
+	case MULTIPLY, DIVIDE:
+		return rhs == 1
+	}
+	return false
+}
+
+// join is the span from the start of a to the end of b
+func join(a, b Span) Span {
+	return Span{Start: a.Start, End: b.End}
+}
+
+func optimizeBinary(n *binaryExpr) Node {
+	subs := make([]*subExpr, len(n.subExprs))
+	for i, se := range n.subExprs {
+		subs[i] = &subExpr{pos: se.pos, Op: se.Op, Expr: Optimize(se.Expr)}
+	}
+
+	if len(subs) > 1 && subs[0].Op == POWER {
+		//a power chain is evaluated from the right, so constants fold from
+		//the right too
+		for len(subs) > 1 {
+			last := len(subs) - 1
+			exp, ok1 := constant(subs[last].Expr)
+			base, ok2 := constant(subs[last-1].Expr)
+			if !ok1 || !ok2 {
+				break
+			}
+			pos := join(subs[last-1].pos, subs[last].pos)
+			subs = append(subs[:last-1], &subExpr{pos: pos, Op: ILLEGALOP, Expr: &number{pos: pos, num: intPow(base, exp)}})
+		}
+	} else if len(subs) > 1 {
+		subs = foldChain(subs)
+	}
+
+	if len(subs) == 1 {
+		//the operand takes the place of the expression, which Eval checks
+		//to be a number at the position of the expression
+		e := subs[0].Expr
+		if !mayBeFunc(e) || e.position() == n.pos {
+			return e
+		}
+	}
+	return &binaryExpr{pos: n.pos, subExprs: subs}
+}
+
+// foldChain folds the leading constants of a left associative chain and
+// drops the constant operands that leave the result unchanged. Eval checks
+// each operand of a chain to be a number, so an operand that may be a
+// function is only left alone without the check when it cannot be one.
+func foldChain(subs []*subExpr) []*subExpr {
+	first := subs[0]
+	rest := subs[1:]
+	if acc, ok := constant(first.Expr); ok {
+		pos := first.pos
+		for len(rest) > 0 {
+			rhs, ok := constant(rest[0].Expr)
+			if !ok {
+				break
+			}
+			res, err := operate(rest[0].Op, acc, rhs)
+			if err != nil {
+				break
+			}
+			acc, pos = res, join(pos, rest[0].pos)
+			rest = rest[1:]
+		}
+		first = &subExpr{pos: pos, Op: ILLEGALOP, Expr: &number{pos: pos, num: acc}}
+
+		//0 + x and 1 * x are x
+		if len(rest) > 0 && !mayBeFunc(rest[0].Expr) &&
+			((acc == 0 && rest[0].Op == PLUS) || (acc == 1 && rest[0].Op == MULTIPLY)) {
+			first = &subExpr{pos: rest[0].Expr.position(), Op: ILLEGALOP, Expr: rest[0].Expr}
+			rest = rest[1:]
+		}
+	}
+
+	kept := []*subExpr{first}
+	for _, se := range rest {
+		if rhs, ok := constant(se.Expr); ok && identity(se.Op, rhs) {
+			continue
+		}
+		kept = append(kept, se)
+	}
+	if len(kept) == 1 && len(rest) > 0 && mayBeFunc(first.Expr) {
+		kept = append(kept, rest[0])
+	}
+	return kept
+}
@@ -0,0 +1,108 @@
+package calculator
+
+import (
+	"bytes"
+	"testing"
+)
+
+// nodes counts the nodes of the ast rooted at node
+func nodes(node Node) int {
+	count := 1
+	switch n := node.(type) {
+	case *programStmt:
+		for _, dec := range n.declarations {
+			count += nodes(dec)
+		}
+	case *blockStmt:
+		count += nodes(n.program)
+	case *assignStmt:
+		count += nodes(n.expr)
+	case *funcStmt:
+		count += nodes(n.block)
+	case *returnStmt:
+		count += nodes(n.expr)
+	case *printExpr:
+		count += nodes(n.expr)
+	case *ifExpr:
+		count += nodes(n.cmpExpr) + nodes(n.thenStmt)
+		if n.elseStmt != nil {
+			count += nodes(n.elseStmt)
+		}
+	case *binaryExpr:
+		for _, se := range n.subExprs {
+			count += nodes(se)
+		}
+	case *subExpr:
+		count += nodes(n.Expr)
+	case *unaryExpr:
+		count += nodes(n.Right)
+	case *callExpr:
+		count += nodes(n.callee)
+		for _, a := range n.args {
+			count += nodes(a)
+		}
+	}
+	return count
+}
+
+func TestOptimize(t *testing.T) {
+	p := BuildParser()
+
+	optimized := map[string]string{
+		"1 + 2 * 3 - 4":               "number 3 1:1\n",
+		"2 ** 3 ** 2":                 "number 512 1:1\n",
+		"-(2 + 3)":                    "number -5 1:1\n",
+		"x * 3 * 1 + 0":               "binary 1:1\n  identifier x 1:1\n  * 1:3\n    number 3 1:5\n",
+		"0 + 2 * x":                   "binary 1:5\n  number 2 1:5\n  * 1:7\n    identifier x 1:9\n",
+		"x - 2 * 3":                   "binary 1:1\n  identifier x 1:1\n  - 1:3\n    number 6 1:5\n",
+		"2 ** x ** (1 + 1)":           "binary 1:1\n  ** 1:1\n    number 2 1:1\n  ** 1:6\n    identifier x 1:6\n  number 2 1:12\n",
+		"if 1 < 2 then print(x)":      "print 1:15\n  identifier x 1:21\n",
+		"if 2 < 1 then x else 3":      "number 3 1:22\n",
+		"1 / 0":                       "binary 1:1\n  number 1 1:1\n  / 1:3\n    number 0 1:5\n",
+		"f(1 + 1)":                    "call 1:2\n  identifier f 1:1\n  number 2 1:3\n",
+		"{ set y = 2 ** 10; -y / 1 }": "block 1:1\n  program 1:1\n    set y 1:3\n      number 1024 1:11\n    unary - 1:20\n      identifier y 1:21\n",
+	}
+
+	for src, want := range optimized {
+		parsed, err := p.Parse(src)
+		if err != nil {
+			t.Fatalf("could not parse %s: %v", src, err)
+		}
+		opt := Optimize(parsed).(*programStmt)
+		var out bytes.Buffer
+		Dump(&out, opt.declarations[0])
+		if out.String() != want {
+			t.Errorf("expected %s to optimize to\n%sgot\n%s", src, want, out.String())
+		}
+		if nodes(opt) >= nodes(parsed) {
+			t.Errorf("expected %s to have fewer nodes after Optimize", src)
+		}
+	}
+
+	//f may be a function, which f * 1 and (f) check
+	for _, src := range []string{"f * 1", "1 * f", "f - 0", "(f)"} {
+		parsed, _ := p.Parse(src)
+		opt := Optimize(parsed).(*programStmt)
+		if _, ok := opt.declarations[0].(*binaryExpr); !ok {
+			t.Errorf("expected %s to keep its binary expression got %T", src, opt.declarations[0])
+		}
+	}
+}
+
+func BenchmarkOptimized(b *testing.B) {
+	for name, src := range benchmarks {
+		node, err := BuildParser().Parse(src)
+		if err != nil {
+			b.Fatal(err)
+		}
+		node = Optimize(node)
+		b.Run(name, func(b *testing.B) {
+			eval := CreateEvaluator()
+			for i := 0; i < b.N; i++ {
+				if _, err := eval.Eval(node); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
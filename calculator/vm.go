@@ -0,0 +1,268 @@
+package calculator
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+type valueKind byte
+
+const (
+	unset     valueKind = iota //a slot whose variable has not been set
+	numberVal                  //an int
+	funcVal                    //a *vmClosure
+)
+
+// value is a number or a function held on the stack or in a slot
+type value struct {
+	kind valueKind
+	num  int
+	fn   *vmClosure
+}
+
+// iface returns v the way Eval returns values, a function as something
+// that prints as <func name>
+func (v value) iface() interface{} {
+	if v.kind == funcVal {
+		return v.fn
+	}
+	return v.num
+}
+
+func numberValue(n int) value {
+	return value{kind: numberVal, num: n}
+}
+
+// frame holds the slots of one block or function call
+type frame struct {
+	slots  []value
+	parent *frame
+}
+
+// vmClosure is a compiled function with the frame it was declared in
+type vmClosure struct {
+	proto *proto
+	env   *frame
+}
+
+func (c *vmClosure) String() string {
+	return fmt.Sprintf("<func %s>", c.proto.name)
+}
+
+// callInfo is where a call returns to
+type callInfo struct {
+	chunk *Chunk
+	ip    int
+	frame *frame
+	base  int //the stack height before the call
+}
+
+// VM compiles programs to bytecode and runs them on a stack machine. It
+// gives the same results, output and errors as an Evaluator, and like one
+// it keeps the global variables between programs.
+type VM struct {
+	names   map[string]int //the slot of each global
+	globals *frame
+	stack   []value
+	calls   []callInfo
+	out     io.Writer
+}
+
+func CreateVM() *VM {
+	return &VM{
+		names:   make(map[string]int),
+		globals: &frame{},
+		out:     os.Stdout,
+	}
+}
+
+// SetOutput sets where print writes to, os.Stdout by default
+func (vm *VM) SetOutput(w io.Writer) {
+	vm.out = w
+}
+
+// Compile compiles node to run on vm. A chunk refers to the globals by
+// slot, so it can only be run by the VM it was compiled for.
+func (vm *VM) Compile(node Node) (*Chunk, error) {
+	return compile(node, vm.names)
+}
+
+// Eval compiles and runs node like Evaluator.Eval
+func (vm *VM) Eval(node Node) (int, error) {
+	res, err := vm.EvalValue(node)
+	if n, ok := res.(int); ok {
+		return n, err
+	}
+	return 0, err
+}
+
+// EvalValue compiles and runs node like Evaluator.EvalValue
+func (vm *VM) EvalValue(node Node) (interface{}, error) {
+	c, err := vm.Compile(node)
+	if err != nil {
+		return nil, err
+	}
+	return vm.Run(c)
+}
+
+// Run runs a chunk compiled by vm.Compile. After an error the VM is back
+// in the global frame so it can be reused.
+func (vm *VM) Run(c *Chunk) (interface{}, error) {
+	for len(vm.globals.slots) < len(vm.names) {
+		vm.globals.slots = append(vm.globals.slots, value{})
+	}
+	res, err := vm.run(c)
+	if err != nil {
+		vm.stack = vm.stack[:0]
+		vm.calls = vm.calls[:0]
+		return nil, err
+	}
+	return res.iface(), nil
+}
+
+// Globals returns the variables set in the global frame
+func (vm *VM) Globals() map[string]interface{} {
+	vars := make(map[string]interface{})
+	for name, slot := range vm.names {
+		if slot < len(vm.globals.slots) && vm.globals.slots[slot].kind != unset {
+			vars[name] = vm.globals.slots[slot].iface()
+		}
+	}
+	return vars
+}
+
+func (vm *VM) push(v value) {
+	vm.stack = append(vm.stack, v)
+}
+
+func (vm *VM) pop() value {
+	v := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return v
+}
+
+// load returns the value of r seen from fr
+func (vm *VM) load(r *ref, fr *frame) (value, bool) {
+	for _, l := range r.locals {
+		f := fr
+		for d := 0; d < l.depth; d++ {
+			f = f.parent
+		}
+		if v := f.slots[l.slot]; v.kind != unset {
+			return v, true
+		}
+	}
+	v := vm.globals.slots[r.global]
+	return v, v.kind != unset
+}
+
+// opcodeOps maps the opcodes of binaryOps back to their operators
+var opcodeOps = func() map[opcode]Op {
+	ops := make(map[opcode]Op, len(binaryOps))
+	for op, code := range binaryOps {
+		ops[code] = op
+	}
+	return ops
+}()
+
+func (vm *VM) run(c *Chunk) (value, error) {
+	code := c.code
+	fr := vm.globals
+	ip := 0
+
+	for {
+		start := ip
+		op := opcode(code[ip])
+		ip++
+
+		switch op {
+		case opConst:
+			vm.push(numberValue(c.consts[c.u16(ip)]))
+			ip += 2
+		case opZero:
+			vm.push(numberValue(0))
+		case opPop:
+			vm.pop()
+		case opLoad:
+			r := &c.refs[c.u16(ip)]
+			ip += 2
+			v, ok := vm.load(r, fr)
+			if !ok {
+				return value{}, newError(NameError, c.span(start), "unbound identifier %s", r.name)
+			}
+			vm.push(v)
+		case opStore:
+			fr.slots[c.u16(ip)] = vm.pop()
+			ip += 2
+		case opClosure:
+			vm.push(value{kind: funcVal, fn: &vmClosure{proto: c.protos[c.u16(ip)], env: fr}})
+			ip += 2
+		case opPrint:
+			fmt.Fprintln(vm.out, vm.pop().iface())
+		case opCheckInt:
+			if v := vm.stack[len(vm.stack)-1]; v.kind != numberVal {
+				return value{}, newError(RuntimeError, c.span(start), "expected a number got %v", v.iface())
+			}
+		case opNeg:
+			top := &vm.stack[len(vm.stack)-1]
+			top.num = -top.num
+		case opPow:
+			base := vm.pop()
+			top := &vm.stack[len(vm.stack)-1]
+			top.num = intPow(base.num, top.num)
+		case opJump:
+			ip += 2 + c.u16(ip)
+		case opJumpIfZero:
+			if vm.pop().num == 0 {
+				ip += 2 + c.u16(ip)
+			} else {
+				ip += 2
+			}
+		case opPushFrame:
+			fr = &frame{slots: make([]value, c.u16(ip)), parent: fr}
+			ip += 2
+		case opPopFrame:
+			fr = fr.parent
+		case opCallable:
+			argc := int(code[ip])
+			ip++
+			v := vm.stack[len(vm.stack)-1]
+			if v.kind != funcVal {
+				return value{}, newError(RuntimeError, c.span(start), "cannot call %v, it is not a function", v.iface())
+			}
+			if p := v.fn.proto; p.params != argc {
+				return value{}, newError(ArityError, c.span(start), "%s takes %d arguments got %d", p.name, p.params, argc)
+			}
+		case opCall:
+			argc := int(code[ip])
+			ip++
+			callee := len(vm.stack) - argc - 1
+			fn := vm.stack[callee].fn
+			callFrame := &frame{slots: make([]value, fn.proto.slots), parent: fn.env}
+			for i, slot := range fn.proto.paramSlots {
+				callFrame.slots[slot] = vm.stack[callee+1+i]
+			}
+			vm.stack = vm.stack[:callee]
+			vm.calls = append(vm.calls, callInfo{chunk: c, ip: ip, frame: fr, base: callee})
+			c, code, ip, fr = fn.proto.chunk, fn.proto.chunk.code, 0, callFrame
+		case opReturn:
+			if len(vm.calls) == 0 {
+				return vm.pop(), nil
+			}
+			ci := vm.calls[len(vm.calls)-1]
+			vm.calls = vm.calls[:len(vm.calls)-1]
+			res := vm.pop()
+			vm.stack = append(vm.stack[:ci.base], res)
+			c, code, ip, fr = ci.chunk, ci.chunk.code, ci.ip, ci.frame
+		default:
+			rhs := vm.pop()
+			top := &vm.stack[len(vm.stack)-1]
+			res, err := operate(opcodeOps[op], top.num, rhs.num)
+			if err != nil {
+				return value{}, newError(RuntimeError, c.span(start), "%s", err)
+			}
+			top.num = res
+		}
+	}
+}
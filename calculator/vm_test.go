@@ -0,0 +1,271 @@
+package calculator
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// sessions are run program by program through both backends. The programs
+// of a session share their globals, like the lines entered into the repl.
+var sessions = [][]string{
+	{"2+2", "4-6+3", "-2", "-(3 * -4)", "2 ** 3 ** 2", "-2 ** 2", "2 ** -1", "7 / 2 * 2", "-7 / 2"},
+	{"1 | 6 ^ 3 & 5", "1 << 4 >> 2", "5 >= 5", "2 != 2", "if 2 < 1 then 10", "if 0 then 1 else if 1 then 2 else 3"},
+	{"set a = 4", "set b = 7", "if a >= b then a else b", "a * b", "set a = a + 1; a", "print(a)", "set f = 3"},
+	{"func add(a, b) { return a + b }", "add(2, 3)", "add", "print(add)", "set g = add; g(g(1, 2), 3)"},
+	{`func last(a) { set b = a * 2; b + 1 }
+	last(4)`, "b"},
+	{`func early(a) { { if a < 0 then { return 0 - a } }; print(a); return a }
+	early(-6) + early(6)`},
+	{`func fact(n) { if n <= 1 then return 1 else return n * fact(n - 1) }
+	fact(10)`},
+	{`set a = 1
+	func shadow(a) { return a }
+	shadow(7) + a`},
+	{`func dup(a, a) { return a }
+	dup(1, 2)`},
+	{`func adder(n) { func add(x) { return x + n }; return add }
+	set plusfive = adder(5)
+	plusfive(10) + adder(1)(2)`},
+	{`set x = 1
+	func getx() { return x }
+	func shadow(x) { return getx() }
+	shadow(99)`},
+	{`func counter() { set c = 10; func next(d) { return c + d }; return next }
+	(counter())(5)`},
+	{`func id(f) { return f }
+	id(id)(id)(3)`, "id(id)"},
+	// a frame only holds a variable once it is set, until then the
+	// enclosing one is used
+	{`set x = 1
+	{ print(x); set x = 2; print(x); { print(x); set x = 3 }; x }`, "x"},
+	{`func f() { return y }`, "f()", "set y = 5", "f()", "{ set y = 6; f() }"},
+	{`func f(c) { if c then set v = 1; return v }`, "f(1)", "f(0)", "set v = 9", "f(0)"},
+	{`func g(n) { set n = n + 1; func h() { return n }; h }
+	g(1)()`},
+	{`func outer() { func inner() { return later }; set later = 4; return inner }
+	outer()()`},
+	{"{ }", "{ 1; 2 }", "{ set z = 1 }", "z"},
+	{"set one = 1", "func p(x) { print(x) }", "p(one)", "p(p)", "print(p(3))"},
+	{"set a = 5", "func f(x) { { return x / 0 } }", "f(a)", "a + 1", "f"},
+	{"1 + nope", "func f(a) { return a }; f()", "4 / (2 - 2)", "1 << -1"},
+	{"set a = 3; a(1)", "func f() { 1 }; f + 1", "-f", "f ** 2", "2 ** f", "if f then 1", "f(f)(1)"},
+	{"func f(x) { print(x); return f }", "f(1)(2)(3)", "f(1) + f(2)", "f(nope)", "f(1)(2, 3)"},
+	// constants that Optimize folds and operands it drops
+	{"set x = 6", "x + 0", "0 + x", "x * 1 - 0", "1 * x / 1", "2 * 3 + x * 1", "x - 2 * 3", "(x)", "-(-x)"},
+	{"set x = 2", "1 + 2 * 3 - 4", "2 ** 2 ** 3", "2 ** 3 ** x", "x ** 1 ** 2", "1 / 0 + 2", "2 + 3 / 0", "1 << 2 << -1"},
+	{"func f() { 1 }", "f * 1", "f + 0", "1 * f", "0 + f", "(f)(1)", "f - 0 + 1", "(f)", "-f * 1"},
+	{"if 1 then 2 else 3", "if 0 then 2 else 3", "if 0 then 2", "if 2 - 2 then print(1) else print(0)", "if 1 then set y = 4", "y"},
+}
+
+// outcome is everything a backend lets a program observe
+type outcome struct {
+	value   string
+	err     error
+	output  string
+	globals string
+}
+
+// backend runs programs and reports what they did
+type backend struct {
+	name    string
+	run     func(Node) (interface{}, error)
+	globals func() map[string]interface{}
+	out     *bytes.Buffer
+}
+
+func evalBackend() backend {
+	var out bytes.Buffer
+	eval := CreateEvaluator()
+	eval.SetOutput(&out)
+	return backend{"Eval", eval.EvalValue, eval.Globals, &out}
+}
+
+func vmBackend() (backend, *VM) {
+	var out bytes.Buffer
+	vm := CreateVM()
+	vm.SetOutput(&out)
+	return backend{"the VM", vm.EvalValue, vm.Globals, &out}, vm
+}
+
+// optimized runs the programs b is given through Optimize first
+func optimized(b backend) backend {
+	run := b.run
+	b.name = b.name + " after Optimize"
+	b.run = func(node Node) (interface{}, error) { return run(Optimize(node)) }
+	return b
+}
+
+func runSession(t *testing.T, p *Parser, programs []string, b backend) []outcome {
+	res := make([]outcome, len(programs))
+	for i, src := range programs {
+		node, err := p.Parse(src)
+		if err != nil {
+			t.Fatalf("could not parse %s: %v", src, err)
+		}
+		b.out.Reset()
+		v, err := b.run(node)
+		res[i] = outcome{
+			value:   fmt.Sprint(v),
+			err:     err,
+			output:  b.out.String(),
+			globals: fmt.Sprint(b.globals()),
+		}
+	}
+	return res
+}
+
+// differ runs programs through an Evaluator, a VM, and both of them after
+// Optimize, and reports where they disagree
+func differ(t *testing.T, p *Parser, programs []string) {
+	vm, machine := vmBackend()
+	optVM, optMachine := vmBackend()
+	want := runSession(t, p, programs, evalBackend())
+	for _, b := range []backend{vm, optimized(evalBackend()), optimized(optVM)} {
+		got := runSession(t, p, programs, b)
+		for i := range programs {
+			if !reflect.DeepEqual(want[i], got[i]) {
+				t.Errorf("%q: Eval gave %+v, %s gave %+v", programs[i], want[i], b.name, got[i])
+			}
+		}
+	}
+	for _, m := range []*VM{machine, optMachine} {
+		if len(m.stack) != 0 || len(m.calls) != 0 {
+			t.Errorf("%q: expected the VM to be back in the global frame", programs)
+		}
+	}
+}
+
+func TestDifferential(t *testing.T) {
+	p := BuildParser()
+	for _, s := range sessions {
+		differ(t, p, s)
+	}
+	differ(t, p, append(append([]string{}, afterError...), "a + 1"))
+
+	// the tables of the evaluator tests, each program in a fresh session
+	for _, table := range []map[string]int{mathExprs, cmpExprs, bitExprs, funcExprs, closureExprs, commentExprs} {
+		for src := range table {
+			differ(t, p, []string{src})
+		}
+	}
+	for src, want := range errExprs {
+		if want.kind != SyntaxError {
+			differ(t, p, []string{src})
+		}
+	}
+
+	files, err := filepath.Glob("testdata/*.calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		differ(t, p, []string{string(src)})
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	node, err := BuildParser().Parse("func f(x) { return x + 1 }; f(2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := CreateVM().Compile(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	c.Disassemble(&out)
+
+	want := `== program ==
+0000 CLOSURE    0 (f)
+0003 STORE      1
+0006 LOAD       0 (f)
+0009 CALLABLE   1
+0011 CONST      0 (2)
+0014 CALL       1
+0016 RETURN
+== f ==
+0000 LOAD       0 (x)
+0003 CHECKINT
+0004 CONST      0 (1)
+0007 ADD
+0008 RETURN
+0009 RETURN
+`
+	if out.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out.String())
+	}
+}
+
+var benchmarks = map[string]string{
+	"fib": `func fib(n) { if n < 2 then return n; return fib(n - 1) + fib(n - 2) }
+	fib(18)`,
+	"fold": `func fold(f, acc, n) { if n == 0 then return acc; return fold(f, f(acc, n), n - 1) }
+	func plus(a, b) { return a + b }
+	fold(plus, 0, 500)`,
+	"arith": `set a = 3; set b = 4
+	{ set c = a * a + b * b; (c << 2) - c / 5 + 2 ** 10 - (a | b ^ c & 7) }`,
+}
+
+func BenchmarkEval(b *testing.B) {
+	for name, src := range benchmarks {
+		node, err := BuildParser().Parse(src)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name, func(b *testing.B) {
+			eval := CreateEvaluator()
+			for i := 0; i < b.N; i++ {
+				if _, err := eval.Eval(node); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkVM compiles each program once, see BenchmarkCompile
+func BenchmarkVM(b *testing.B) {
+	for name, src := range benchmarks {
+		node, err := BuildParser().Parse(src)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name, func(b *testing.B) {
+			vm := CreateVM()
+			c, err := vm.Compile(node)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; i < b.N; i++ {
+				if _, err := vm.Run(c); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	for name, src := range benchmarks {
+		node, err := BuildParser().Parse(src)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name, func(b *testing.B) {
+			vm := CreateVM()
+			for i := 0; i < b.N; i++ {
+				if _, err := vm.Compile(node); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+module lexer
+
+go 1.14
@@ -0,0 +1,410 @@
+// Package lexer splits input into tokens using a set of regular expression
+// rules. It is shared by the calculators and can be used to build the lexer
+// of any small language.
+package lexer
+
+import (
+	"fmt"
+	"io"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kind identifies the type of a token. The kinds below FirstKind are used
+// by the lexer itself, a rule set numbers its own kinds from FirstKind.
+type Kind int
+
+const (
+	EOF Kind = iota
+	Newline
+	Comment //only returned by Comments
+	FirstKind
+)
+
+// Rule makes the lexer emit a token of kind Kind for input matching Pattern.
+// Name is used for the kind in messages, several rules may share a kind.
+type Rule struct {
+	Pattern string
+	Kind    Kind
+	Name    string
+}
+
+// Config controls what the lexer does with the input between tokens.
+type Config struct {
+	Whitespace    string      //bytes skipped between tokens
+	SkipNewlines  bool        //skip \n instead of emitting a Newline token
+	LineComments  []string    //prefixes of comments that run to the end of the line
+	BlockComments [][2]string //start and end delimiters of block comments
+}
+
+// DefaultConfig skips spaces and tabs and emits a Newline token for \n
+func DefaultConfig() Config {
+	return Config{Whitespace: " \t\f\r"}
+}
+
+// RuleSet is a compiled list of rules and the configuration to lex with. It
+// is not modified by lexing and can be shared between lexers.
+type RuleSet struct {
+	kinds    []Kind        //the kind of each rule
+	scanners [256]*scanner //the rules that can match input starting with each byte
+	names    map[Kind]string
+	config   Config
+}
+
+// NewRuleSet compiles the rules into a scanner for each byte a match can
+// start with, see scanner. The rules a scanner tries are compiled into a
+// single alternation matching leftmost-longest, so a rule that has a choice
+// of matches always makes its longest one.
+func NewRuleSet(rules []Rule, config Config) (*RuleSet, error) {
+	rs := &RuleSet{
+		kinds:  make([]Kind, len(rules)),
+		names:  map[Kind]string{EOF: "EOF", Newline: "NEWLINE", Comment: "COMMENT"},
+		config: config,
+	}
+
+	var starts [256][]int
+	for i, r := range rules {
+		re, err := syntax.Parse(r.Pattern, syntax.Perl)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", r.Name, err)
+		}
+		var first byteSet
+		first.add(re)
+		for b := range starts {
+			if first[b] {
+				starts[b] = append(starts[b], i)
+			}
+		}
+		rs.kinds[i] = r.Kind
+		if _, ok := rs.names[r.Kind]; !ok {
+			rs.names[r.Kind] = r.Name
+		}
+	}
+
+	//bytes that start the same rules share a scanner
+	scanners := map[string]*scanner{}
+	for b, idx := range starts {
+		if len(idx) == 0 {
+			continue
+		}
+		key := fmt.Sprint(idx)
+		if _, ok := scanners[key]; !ok {
+			scanners[key] = newScanner(rules, idx)
+		}
+		rs.scanners[b] = scanners[key]
+	}
+	return rs, nil
+}
+
+// match returns the length of the longest match of a rule at the start of
+// s, 0 if there is none, and the kind of the first rule making it
+func (rs *RuleSet) match(s string) (int, Kind) {
+	sc := rs.scanners[s[0]]
+	if sc == nil {
+		return 0, EOF
+	}
+	n, rule := sc.match(s)
+	if n == 0 {
+		return 0, EOF
+	}
+	return n, rs.kinds[rule]
+}
+
+// MustRuleSet is like NewRuleSet but panics if a rule does not compile
+func MustRuleSet(rules []Rule, config Config) *RuleSet {
+	rs, err := NewRuleSet(rules, config)
+	if err != nil {
+		panic(err)
+	}
+	return rs
+}
+
+// Name returns the name of the first rule with kind k
+func (rs *RuleSet) Name(k Kind) string {
+	if n, ok := rs.names[k]; ok {
+		return n
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+type Token struct {
+	Kind  Kind
+	Value string
+	Pos   Position
+	End   Position
+}
+
+func (t Token) Span() Span {
+	return Span{Start: t.Pos, End: t.End}
+}
+
+func (t Token) String() string {
+	return fmt.Sprintf("%d with value %q at position %s", t.Kind, t.Value, t.Pos)
+}
+
+// LexError is returned by Token when the input at Pos cannot be lexed
+type LexError struct {
+	Pos Position
+	Msg string
+}
+
+func (e *LexError) Error() string {
+	return fmt.Sprintf("%s at position %s", e.Msg, e.Pos)
+}
+
+// chunkSize is how much input is read at a time. A token is matched with at
+// least this much input buffered unless the reader is exhausted, tokens
+// that run to the end of the buffer are retried with more input.
+const chunkSize = 4096
+
+// Lexer splits input into tokens by maximal munch: at every position each
+// rule is tried and the longest match wins. When several rules match the
+// same length the one listed first wins, so keywords listed before the
+// identifier rule are only recognised when they are not the prefix of a
+// longer identifier.
+type Lexer struct {
+	rules  *RuleSet
+	r      io.Reader
+	buf    string //input read but not yet consumed
+	eof    bool   //r is exhausted
+	err    error  //read error other than io.EOF
+	chunk  []byte
+	offset int
+	line   int
+	col    int
+
+	keepComments bool
+	comments     []*Token //the comments skipped, when keepComments is set
+}
+
+func New(rules *RuleSet, r io.Reader) *Lexer {
+	l := &Lexer{rules: rules, chunk: make([]byte, chunkSize)}
+	l.Reset(r)
+	return l
+}
+
+// Reset discards any buffered input and starts lexing r from line 1
+func (l *Lexer) Reset(r io.Reader) {
+	l.r = r
+	l.buf = ""
+	l.eof = false
+	l.err = nil
+	l.offset = 0
+	l.line = 1
+	l.col = 1
+	l.comments = nil
+}
+
+// KeepComments makes the lexer record the comments it skips, see Comments
+func (l *Lexer) KeepComments(keep bool) {
+	l.keepComments = keep
+}
+
+// Comments returns the comments skipped since the last Reset as tokens of
+// kind Comment, in the order they appear in the input. Comments are only
+// recorded after KeepComments(true).
+func (l *Lexer) Comments() []*Token {
+	return l.comments
+}
+
+// comment consumes a comment of n bytes, recording it if asked to
+func (l *Lexer) comment(n int) *Token {
+	t := &Token{Kind: Comment, Value: l.buf[:n], Pos: l.Position()}
+	l.advance(n)
+	t.End = l.Position()
+	if l.keepComments {
+		l.comments = append(l.comments, t)
+	}
+	return t
+}
+
+// Input starts lexing buf
+func (l *Lexer) Input(buf string) {
+	l.Reset(strings.NewReader(buf))
+}
+
+// Err returns the error other than io.EOF that reading the input failed
+// with, or nil. Token returns it for every call once the input read before
+// it is consumed, so a caller skipping tokens after an error has to stop
+// when the error is Err.
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+// Position returns the position of the next unread byte of input
+func (l *Lexer) Position() Position {
+	return Position{Offset: l.offset, Line: l.line, Col: l.col}
+}
+
+// more reads another chunk of input and reports whether there was any
+func (l *Lexer) more() bool {
+	for !l.eof {
+		n, err := l.r.Read(l.chunk)
+		l.buf += string(l.chunk[:n])
+		if err == io.EOF {
+			l.eof = true
+		} else if err != nil {
+			l.eof = true
+			l.err = err
+		}
+		if n > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// advance consumes n bytes of input keeping track of lines and columns
+func (l *Lexer) advance(n int) {
+	for i := 0; i < n; i++ {
+		if l.buf[i] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+	}
+	l.buf = l.buf[n:]
+	l.offset += n
+}
+
+// skip consumes whitespace and comments. A block comment that spans lines
+// is returned as a Newline token when newlines are not skipped, so it still
+// separates the lines around it.
+func (l *Lexer) skip() (*Token, error) {
+	cfg := &l.rules.config
+	for {
+		for len(l.buf) < chunkSize && l.more() {
+		}
+
+		n := 0
+		for n < len(l.buf) && (strings.IndexByte(cfg.Whitespace, l.buf[n]) >= 0 || (cfg.SkipNewlines && l.buf[n] == '\n')) {
+			n++
+		}
+		l.advance(n)
+		if n > 0 {
+			continue
+		}
+
+		if c, ok := l.lineComment(); ok {
+			l.comment(c)
+			continue
+		}
+
+		c, ok, err := l.blockComment()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, nil
+		}
+		t := l.comment(c)
+		if !cfg.SkipNewlines && strings.IndexByte(t.Value, '\n') >= 0 {
+			return &Token{Kind: Newline, Value: t.Value, Pos: t.Pos, End: t.End}, nil
+		}
+	}
+}
+
+// lineComment returns the length of the line comment at the start of the
+// input, not including the newline that ends it
+func (l *Lexer) lineComment() (int, bool) {
+	for _, prefix := range l.rules.config.LineComments {
+		if !strings.HasPrefix(l.buf, prefix) {
+			continue
+		}
+		for {
+			if i := strings.IndexByte(l.buf, '\n'); i >= 0 {
+				return i, true
+			}
+			if !l.more() {
+				return len(l.buf), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// blockComment returns the length of the block comment at the start of the
+// input including its delimiters
+func (l *Lexer) blockComment() (int, bool, error) {
+	for _, delims := range l.rules.config.BlockComments {
+		if !strings.HasPrefix(l.buf, delims[0]) {
+			continue
+		}
+		for {
+			if i := strings.Index(l.buf[len(delims[0]):], delims[1]); i >= 0 {
+				return len(delims[0]) + i + len(delims[1]), true, nil
+			}
+			if !l.more() {
+				err := &LexError{Pos: l.Position(), Msg: "unterminated comment"}
+				l.advance(len(l.buf))
+				return 0, false, err
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+// Token returns the next token of the input, a token of kind EOF once the
+// input is exhausted. After a LexError the offending character has been
+// skipped, so lexing can carry on.
+func (l *Lexer) Token() (*Token, error) {
+	if t, err := l.skip(); t != nil || err != nil {
+		return t, err
+	}
+	if len(l.buf) == 0 {
+		if l.err != nil {
+			return nil, l.err
+		}
+		return &Token{Kind: EOF, Pos: l.Position(), End: l.Position()}, nil
+	}
+
+	if l.buf[0] == '\n' {
+		start := l.Position()
+		l.advance(1)
+		return &Token{Kind: Newline, Value: "\n", Pos: start, End: l.Position()}, nil
+	}
+
+	var longest int
+	var kind Kind
+	for {
+		longest, kind = l.rules.match(l.buf)
+		//the match may continue in input that has not been read yet
+		if longest < len(l.buf) || !l.more() {
+			break
+		}
+	}
+
+	if longest > 0 {
+		t := Token{
+			Kind:  kind,
+			Value: l.buf[:longest],
+			Pos:   l.Position(),
+		}
+		l.advance(longest)
+		t.End = l.Position()
+		return &t, nil
+	}
+	//skip the character so lexing can carry on after the error
+	err := &LexError{Pos: l.Position(), Msg: "could not match anything"}
+	_, size := utf8.DecodeRuneInString(l.buf)
+	l.advance(size)
+	return nil, err
+}
+
+// Tokens returns the remaining tokens of the input, not including EOF
+func (l *Lexer) Tokens() ([]*Token, error) {
+	tokens := make([]*Token, 0)
+	for {
+		tok, err := l.Token()
+		if err != nil {
+			return tokens, err
+		}
+		if tok.Kind == EOF {
+			break
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,312 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const (
+	SET Kind = FirstKind + iota
+	IF
+	NUMBER
+	DECIMAL
+	IDENTIFIER
+	POWER
+	STAR
+	LTE
+	LSHIFT
+	LT
+	ASSIGN
+	EQ
+	PLUS
+)
+
+var testRules = []Rule{
+	{`set`, SET, "SET"},
+	{`if`, IF, "IF"},
+	{`\d*\.\d+`, DECIMAL, "DECIMAL"},
+	{`\d+`, NUMBER, "NUMBER"},
+	{`[a-zA-Z_]\w*`, IDENTIFIER, "IDENTIFIER"},
+	{`\*\*`, POWER, "**"},
+	{`\*`, STAR, "*"},
+	{`<=`, LTE, "<="},
+	{`<<`, LSHIFT, "<<"},
+	{`<`, LT, "<"},
+	{`==`, EQ, "=="},
+	{`=`, ASSIGN, "="},
+	{`\+`, PLUS, "+"},
+}
+
+func kinds(t *testing.T, rs *RuleSet, src string) string {
+	toks, err := New(rs, strings.NewReader(src)).Tokens()
+	if err != nil {
+		t.Fatalf("could not lex %q: %v", src, err)
+	}
+	names := make([]string, len(toks))
+	for i, tok := range toks {
+		names[i] = rs.Name(tok.Kind)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestMaximalMunch(t *testing.T) {
+	rs := MustRuleSet(testRules, DefaultConfig())
+
+	lexed := map[string]string{
+		"settings":         "IDENTIFIER",
+		"set settings = 1": "SET IDENTIFIER = NUMBER",
+		"iffy if":          "IDENTIFIER IF",
+		"offset add5 x1":   "IDENTIFIER IDENTIFIER IDENTIFIER",
+		"a<=b<<2**3*4":     "IDENTIFIER <= IDENTIFIER << NUMBER ** NUMBER * NUMBER",
+		"a == b = c":       "IDENTIFIER == IDENTIFIER = IDENTIFIER",
+		"3.14 + 2 + .5":    "DECIMAL + NUMBER + DECIMAL",
+		"a\n\nb":           "IDENTIFIER NEWLINE NEWLINE IDENTIFIER",
+	}
+
+	for src, want := range lexed {
+		if got := kinds(t, rs, src); got != want {
+			t.Errorf("expected %q to lex as %s got %s", src, want, got)
+		}
+	}
+}
+
+// rules the scanner tables have to start from more than a first byte
+func TestScanners(t *testing.T) {
+	const (
+		XY Kind = FirstKind + iota
+		SELECT
+		DIGITS
+		ACCENT
+		STRING
+		ID
+	)
+	rs := MustRuleSet([]Rule{
+		{`x*y`, XY, "XY"},
+		{`(?i)select`, SELECT, "SELECT"},
+		{`(\d)(\d)?`, DIGITS, "DIGITS"},
+		{`é+`, ACCENT, "ACCENT"},
+		{`"[^"]*"`, STRING, "STRING"},
+		{`[a-zA-Z_]\w*`, ID, "ID"},
+	}, DefaultConfig())
+
+	lexed := map[string]string{
+		"xxy y x":                "XY XY ID",
+		"SeLeCt select selected": "SELECT SELECT ID",
+		"123":                    "DIGITS DIGITS",
+		"éé é":                   "ACCENT ACCENT",
+		`"ü ö" ""`:               "STRING STRING",
+		"y1 select_ xxyz x":      "ID ID ID ID",
+	}
+	for src, want := range lexed {
+		if got := kinds(t, rs, src); got != want {
+			t.Errorf("expected %q to lex as %s got %s", src, want, got)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	rs := MustRuleSet(testRules, DefaultConfig())
+	toks, err := New(rs, strings.NewReader("set a = 1\n  a +\tbb")).Tokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Span{
+		{Position{0, 1, 1}, Position{3, 1, 4}},
+		{Position{4, 1, 5}, Position{5, 1, 6}},
+		{Position{6, 1, 7}, Position{7, 1, 8}},
+		{Position{8, 1, 9}, Position{9, 1, 10}},
+		{Position{9, 1, 10}, Position{10, 2, 1}},
+		{Position{12, 2, 3}, Position{13, 2, 4}},
+		{Position{14, 2, 5}, Position{15, 2, 6}},
+		{Position{16, 2, 7}, Position{18, 2, 9}},
+	}
+	if len(toks) != len(want) {
+		t.Fatalf("expected %d tokens got %d", len(want), len(toks))
+	}
+	for i, tok := range toks {
+		if tok.Span() != want[i] {
+			t.Errorf("expected token %s to span %+v got %+v", tok, want[i], tok.Span())
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LineComments = []string{"#", "//"}
+	cfg.BlockComments = [][2]string{{"/*", "*/"}}
+	rs := MustRuleSet(testRules, cfg)
+
+	lexed := map[string]string{
+		"a # b\nc":             "IDENTIFIER NEWLINE IDENTIFIER",
+		"a // b = c":           "IDENTIFIER",
+		"a /* b */ + c":        "IDENTIFIER + IDENTIFIER",
+		"a /* b \n c */ d":     "IDENTIFIER NEWLINE IDENTIFIER",
+		"a /* // */ b":         "IDENTIFIER IDENTIFIER",
+		"# only a comment":     "",
+		"a /* 1 */ /* 2 */ b ": "IDENTIFIER IDENTIFIER",
+	}
+	for src, want := range lexed {
+		if got := kinds(t, rs, src); got != want {
+			t.Errorf("expected %q to lex as %s got %s", src, want, got)
+		}
+	}
+
+	cfg.SkipNewlines = true
+	rs = MustRuleSet(testRules, cfg)
+	if got := kinds(t, rs, "a\n/* b \n */\nc"); got != "IDENTIFIER IDENTIFIER" {
+		t.Errorf("expected newlines to be skipped got %s", got)
+	}
+
+	_, err := New(rs, strings.NewReader("a /* b")).Tokens()
+	if lerr, ok := err.(*LexError); !ok || lerr.Pos.Offset != 2 {
+		t.Errorf("expected an unterminated comment at offset 2 got %v", err)
+	}
+}
+
+func TestComments(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LineComments = []string{"#"}
+	cfg.BlockComments = [][2]string{{"/*", "*/"}}
+	l := New(MustRuleSet(testRules, cfg), strings.NewReader("a # b\n/* c\n */ d"))
+	l.KeepComments(true)
+	if _, err := l.Tokens(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Token{
+		{Kind: Comment, Value: "# b", Pos: Position{2, 1, 3}, End: Position{5, 1, 6}},
+		{Kind: Comment, Value: "/* c\n */", Pos: Position{6, 2, 1}, End: Position{14, 3, 4}},
+	}
+	got := l.Comments()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d comments got %d", len(want), len(got))
+	}
+	for i, c := range got {
+		if *c != want[i] {
+			t.Errorf("expected comment %#v got %#v", want[i], *c)
+		}
+	}
+
+	l.Input("a # b")
+	if _, err := l.Tokens(); err != nil || len(l.Comments()) != 1 {
+		t.Errorf("expected Input to forget the earlier comments got %d", len(l.Comments()))
+	}
+}
+
+func TestLexError(t *testing.T) {
+	rs := MustRuleSet(testRules, DefaultConfig())
+	l := New(rs, strings.NewReader("a $ b"))
+
+	var got []string
+	for {
+		tok, err := l.Token()
+		if err != nil {
+			if lerr, ok := err.(*LexError); !ok || lerr.Pos.Offset != 2 {
+				t.Errorf("expected an error at offset 2 got %v", err)
+			}
+			continue
+		}
+		if tok.Kind == EOF {
+			break
+		}
+		got = append(got, tok.Value)
+	}
+	if strings.Join(got, " ") != "a b" {
+		t.Errorf("expected lexing to carry on after the error got %v", got)
+	}
+}
+
+// a token split across reads must lex the same as when it is read at once
+func TestStreaming(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BlockComments = [][2]string{{"/*", "*/"}}
+	rs := MustRuleSet(testRules, cfg)
+
+	long := strings.Repeat("x", 3*chunkSize)
+	src := "set " + long + " = 12.75 /* " + strings.Repeat("c", chunkSize) + " */ ** 2"
+	want, err := New(rs, strings.NewReader(src)).Tokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := New(rs, iotest.OneByteReader(strings.NewReader(src))).Tokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 6 || len(got) != len(want) {
+		t.Fatalf("expected 6 tokens got %d and %d", len(want), len(got))
+	}
+	for i := range want {
+		if *got[i] != *want[i] {
+			t.Errorf("expected token %d to be %s got %s", i, want[i], got[i])
+		}
+	}
+	if got[1].Value != long {
+		t.Errorf("expected the long identifier to be read whole")
+	}
+}
+
+func TestDiagnostic(t *testing.T) {
+	src := "a = 1\n\tb +"
+	span := Span{Start: Position{7, 2, 2}, End: Position{8, 2, 3}}
+	want := `unbound identifier b
+ --> 2:2
+  |
+2 | 	b +
+  | 	^
+`
+	if got := RenderDiagnostic(src, span, "unbound identifier b"); got != want {
+		t.Errorf("expected diagnostic\n%s\ngot\n%s", want, got)
+	}
+
+	//a span on the \r of a CRLF line or at the end of the input points
+	//past the end of the line
+	for _, src := range []string{"set a =\r\nprint(a)", "set a ="} {
+		span := Span{Start: Position{7, 1, 8}, End: Position{7, 1, 8}}
+		want := "expected an expression\n --> 1:8\n  |\n1 | set a =\n  |        ^\n"
+		if got := RenderDiagnostic(src, span, "expected an expression"); got != want {
+			t.Errorf("expected diagnostic of %q\n%s\ngot\n%s", src, want, got)
+		}
+	}
+
+	if got := RenderDiagnostic(src, Span{}, "read failed"); got != "read failed\n" {
+		t.Errorf("expected a zero span to render just the message got %q", got)
+	}
+}
+
+func BenchmarkLexer(b *testing.B) {
+	line := "set offset = settings ** 2 <= iffy << 3.5 * if_not\n"
+	src := strings.Repeat(line, 400)
+	rs := MustRuleSet(testRules, DefaultConfig())
+	l := New(rs, nil)
+
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Input(src)
+		l.Tokens()
+	}
+}
+
+func TestReadError(t *testing.T) {
+	rs := MustRuleSet(testRules, DefaultConfig())
+	l := New(rs, iotest.TimeoutReader(strings.NewReader("set a = 1")))
+
+	toks, err := l.Tokens()
+	if err != iotest.ErrTimeout || len(toks) != 4 {
+		t.Fatalf("expected 4 tokens and a timeout got %d tokens and %v", len(toks), err)
+	}
+	if l.Err() != iotest.ErrTimeout {
+		t.Errorf("expected Err to be the timeout got %v", l.Err())
+	}
+	if _, err := l.Token(); err != iotest.ErrTimeout {
+		t.Errorf("expected the timeout to be returned again got %v", err)
+	}
+
+	l.Input("set")
+	if l.Err() != nil {
+		t.Errorf("expected Input to clear the read error got %v", l.Err())
+	}
+}
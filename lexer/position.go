@@ -0,0 +1,78 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Position is a location in the lexer input. Line and Col start at 1 and Col
+// counts bytes from the start of the line.
+type Position struct {
+	Offset int
+	Line   int
+	Col    int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// Span is the input covered by a token or an ast node, End is exclusive
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// RenderDiagnostic formats msg followed by the line of src that span starts
+// on, with the span underlined by carets. A span that runs past the end of
+// its first line is underlined to the end of that line. A zero span, for
+// an error that is not at any place in src, renders just msg.
+func RenderDiagnostic(src string, span Span, msg string) string {
+	if span == (Span{}) {
+		return msg + "\n"
+	}
+	start := span.Start.Offset - (span.Start.Col - 1)
+	if start < 0 || start > len(src) {
+		return fmt.Sprintf("%s\n --> %s\n", msg, span.Start)
+	}
+	end := strings.IndexByte(src[start:], '\n')
+	if end < 0 {
+		end = len(src)
+	} else {
+		end += start
+	}
+	line := strings.TrimRight(src[start:end], "\r")
+
+	width := span.End.Offset - span.Start.Offset
+	if span.End.Line != span.Start.Line || span.Start.Offset+width > start+len(line) {
+		width = start + len(line) - span.Start.Offset
+	}
+	if width < 1 {
+		width = 1
+	}
+
+	//a span can start on the \r of a line ending or just past the input
+	col := span.Start.Col - 1
+	if col < 0 {
+		col = 0
+	} else if col > len(line) {
+		col = len(line)
+	}
+
+	num := fmt.Sprintf("%d", span.Start.Line)
+	gutter := strings.Repeat(" ", len(num))
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, line[:col])
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n", msg)
+	fmt.Fprintf(&sb, "%s--> %s\n", gutter, span.Start)
+	fmt.Fprintf(&sb, "%s |\n", gutter)
+	fmt.Fprintf(&sb, "%s | %s\n", num, line)
+	fmt.Fprintf(&sb, "%s | %s%s\n", gutter, indent, strings.Repeat("^", width))
+	return sb.String()
+}
@@ -0,0 +1,167 @@
+package lexer
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// scanner matches the rules that can start with one byte of input. The
+// rules that only match a fixed string are compared without the regexp
+// engine, the others are compiled into a single anchored alternation with
+// a named group for each rule, so a token costs one pass of the engine
+// whatever the number of rules.
+type scanner struct {
+	literals []literal
+	re       *regexp.Regexp
+	groups   []int //the rule of each subexpression of re, or -1
+}
+
+type literal struct {
+	rule  int
+	value string
+}
+
+// newScanner compiles the rules of rules numbered idx, in order. The
+// patterns have already been parsed by NewRuleSet.
+func newScanner(rules []Rule, idx []int) *scanner {
+	sc := &scanner{}
+	var alts []string
+	names := map[string]int{}
+	for _, i := range idx {
+		re := regexp.MustCompile(rules[i].Pattern)
+		if lit, complete := re.LiteralPrefix(); complete && lit != "" {
+			sc.literals = append(sc.literals, literal{rule: i, value: lit})
+			continue
+		}
+		name := fmt.Sprintf("r%d", i)
+		names[name] = i
+		alts = append(alts, fmt.Sprintf("(?P<%s>%s)", name, rules[i].Pattern))
+	}
+	if len(alts) == 0 {
+		return sc
+	}
+
+	sc.re = regexp.MustCompile(`^(?:` + strings.Join(alts, "|") + `)`)
+	sc.re.Longest()
+	for _, name := range sc.re.SubexpNames() {
+		if i, ok := names[name]; ok {
+			sc.groups = append(sc.groups, i)
+		} else {
+			sc.groups = append(sc.groups, -1)
+		}
+	}
+	return sc
+}
+
+// match returns the length of the longest match at the start of s and the
+// first rule making it, or 0
+func (sc *scanner) match(s string) (int, int) {
+	longest, first := 0, -1
+	if sc.re != nil {
+		//the groups of the rules that are not part of the match are unset,
+		//when rules match the same length the earlier one is reported
+		if m := sc.re.FindStringSubmatchIndex(s); m != nil && m[1] > 0 {
+			for g, i := range sc.groups {
+				if i >= 0 && m[2*g] >= 0 {
+					longest, first = m[1], i
+					break
+				}
+			}
+		}
+	}
+	for _, lit := range sc.literals {
+		n := len(lit.value)
+		if (n > longest || n == longest && lit.rule < first) && strings.HasPrefix(s, lit.value) {
+			longest, first = n, lit.rule
+		}
+	}
+	return longest, first
+}
+
+// byteSet is a set of bytes, indexed by the byte
+type byteSet [256]bool
+
+func (s *byteSet) addRange(lo, hi int) {
+	for b := lo; b <= hi; b++ {
+		s[b] = true
+	}
+}
+
+// addRune adds the first byte of r. Runes outside ASCII add every byte that
+// does not start an ASCII rune, as invalid UTF-8 is read as RuneError.
+func (s *byteSet) addRune(r rune) {
+	if r < utf8.RuneSelf {
+		s[r] = true
+	} else {
+		s.addRange(utf8.RuneSelf, 0xff)
+	}
+}
+
+// add adds the bytes a non-empty match of re can start with and reports
+// whether re can match the empty string. It may add more bytes than can
+// start a match, never fewer.
+func (s *byteSet) add(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return false
+	case syntax.OpLiteral:
+		if len(re.Rune) == 0 {
+			return true
+		}
+		r := re.Rune[0]
+		s.addRune(r)
+		if re.Flags&syntax.FoldCase != 0 {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				s.addRune(f)
+			}
+		}
+		return false
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			lo, hi := re.Rune[i], re.Rune[i+1]
+			if hi >= utf8.RuneSelf {
+				s.addRune(hi)
+				hi = utf8.RuneSelf - 1
+			}
+			if lo <= hi {
+				s.addRange(int(lo), int(hi))
+			}
+		}
+		return false
+	case syntax.OpAnyCharNotNL:
+		s.addRange(0, '\n'-1)
+		s.addRange('\n'+1, 0xff)
+		return false
+	case syntax.OpAnyChar:
+		s.addRange(0, 0xff)
+		return false
+	case syntax.OpCapture, syntax.OpPlus:
+		return s.add(re.Sub[0])
+	case syntax.OpStar, syntax.OpQuest:
+		s.add(re.Sub[0])
+		return true
+	case syntax.OpRepeat:
+		return s.add(re.Sub[0]) || re.Min == 0
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !s.add(sub) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpAlternate:
+		empty := false
+		for _, sub := range re.Sub {
+			if s.add(sub) {
+				empty = true
+			}
+		}
+		return empty
+	}
+	//empty strings and the assertions of ^, $, \b and \B
+	return true
+}
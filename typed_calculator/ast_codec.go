@@ -0,0 +1,320 @@
+// Code generated by generator/generate_ast.go. DO NOT EDIT.
+
+package typedcalculator
+
+// writeNode writes n and the nodes below it with w, a nil n as null
+func writeNode(w nodeWriter, n Node) {
+	switch f := n.(type) {
+	case *Program:
+		w.begin("Program")
+		w.lines("lines", f.Lines)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Line:
+		w.begin("Line")
+		w.node("stmt", f.Stmt)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Assignment:
+		w.begin("Assignment")
+		w.typ("type", f.Type)
+		w.bool("const", f.Const)
+		w.str("identifier", f.Identifier)
+		w.node("expr", f.Expr)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Reassign:
+		w.begin("Reassign")
+		w.typ("type", f.Type)
+		w.op("op", f.Op)
+		w.str("identifier", f.Identifier)
+		w.node("expr", f.Expr)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Print:
+		w.begin("Print")
+		w.node("expr", f.Expr)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Reset:
+		w.begin("Reset")
+		w.str("identifier", f.Identifier)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Block:
+		w.begin("Block")
+		w.lines("lines", f.Lines)
+		w.span("pos", f.Pos)
+		w.end()
+	case *If:
+		w.begin("If")
+		w.node("cond", f.Cond)
+		w.node("then", f.Then)
+		w.node("else", f.Else)
+		w.span("pos", f.Pos)
+		w.end()
+	case *While:
+		w.begin("While")
+		w.node("cond", f.Cond)
+		w.node("body", f.Body)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Func:
+		w.begin("Func")
+		w.str("name", f.Name)
+		w.strings("params", f.Params)
+		w.types("paramTypes", f.ParamTypes)
+		w.typ("result", f.Result)
+		w.block("body", f.Body)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Return:
+		w.begin("Return")
+		w.node("expr", f.Expr)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Call:
+		w.begin("Call")
+		w.typ("type", f.Type)
+		w.str("name", f.Name)
+		w.nodes("args", f.Args)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Binary2:
+		w.begin("Binary2")
+		w.typ("type", f.Type)
+		w.op("op", f.Op)
+		w.node("lhs", f.Lhs)
+		w.node("rhs", f.Rhs)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Unary:
+		w.begin("Unary")
+		w.typ("type", f.Type)
+		w.op("op", f.Op)
+		w.node("expr", f.Expr)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Cast:
+		w.begin("Cast")
+		w.typ("type", f.Type)
+		w.bool("exact", f.Exact)
+		w.node("expr", f.Expr)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Identifier:
+		w.begin("Identifier")
+		w.str("val", f.Val)
+		w.span("pos", f.Pos)
+		w.end()
+	case *Number:
+		w.begin("Number")
+		w.typ("type", f.Type)
+		w.bool("fixed", f.Fixed)
+		w.int32("num", f.Num)
+		w.int64("long", f.Long)
+		w.bigInt("big", f.Big)
+		w.float32("flt", f.Flt)
+		w.float64("dbl", f.Dbl)
+		w.rat("dec", f.Dec)
+		w.bool("bool", f.Bool)
+		w.span("pos", f.Pos)
+		w.end()
+	default:
+		w.null()
+	}
+}
+
+// readNode reads a node written by writeNode, null as nil
+func readNode(r nodeReader) Node {
+	switch tag := r.begin(); tag {
+	case "":
+		return nil
+	case "Program":
+		f := &Program{}
+		f.Lines = r.lines("lines")
+		f.Pos = r.span("pos")
+		r.end()
+		return f
+	case "Line":
+		f := &Line{}
+		f.Stmt = r.node("stmt")
+		f.Pos = r.span("pos")
+		if f.Stmt == nil {
+			r.fail("Line without stmt")
+		}
+		r.end()
+		return f
+	case "Assignment":
+		f := &Assignment{}
+		f.Type = r.typ("type")
+		f.Const = r.bool("const")
+		f.Identifier = r.str("identifier")
+		f.Expr = r.node("expr")
+		f.Pos = r.span("pos")
+		if f.Expr == nil {
+			r.fail("Assignment without expr")
+		}
+		r.end()
+		return f
+	case "Reassign":
+		f := &Reassign{}
+		f.Type = r.typ("type")
+		f.Op = r.op("op")
+		f.Identifier = r.str("identifier")
+		f.Expr = r.node("expr")
+		f.Pos = r.span("pos")
+		if f.Expr == nil {
+			r.fail("Reassign without expr")
+		}
+		r.end()
+		return f
+	case "Print":
+		f := &Print{}
+		f.Expr = r.node("expr")
+		f.Pos = r.span("pos")
+		if f.Expr == nil {
+			r.fail("Print without expr")
+		}
+		r.end()
+		return f
+	case "Reset":
+		f := &Reset{}
+		f.Identifier = r.str("identifier")
+		f.Pos = r.span("pos")
+		r.end()
+		return f
+	case "Block":
+		f := &Block{}
+		f.Lines = r.lines("lines")
+		f.Pos = r.span("pos")
+		r.end()
+		return f
+	case "If":
+		f := &If{}
+		f.Cond = r.node("cond")
+		f.Then = r.node("then")
+		f.Else = r.node("else")
+		f.Pos = r.span("pos")
+		if f.Cond == nil {
+			r.fail("If without cond")
+		}
+		if f.Then == nil {
+			r.fail("If without then")
+		}
+		r.end()
+		return f
+	case "While":
+		f := &While{}
+		f.Cond = r.node("cond")
+		f.Body = r.node("body")
+		f.Pos = r.span("pos")
+		if f.Cond == nil {
+			r.fail("While without cond")
+		}
+		if f.Body == nil {
+			r.fail("While without body")
+		}
+		r.end()
+		return f
+	case "Func":
+		f := &Func{}
+		f.Name = r.str("name")
+		f.Params = r.strings("params")
+		f.ParamTypes = r.types("paramTypes")
+		f.Result = r.typ("result")
+		f.Body = r.block("body")
+		f.Pos = r.span("pos")
+		if f.Body == nil {
+			r.fail("Func without body")
+		}
+		if err := f.valid(); err != nil {
+			r.fail("Func: %v", err)
+		}
+		r.end()
+		return f
+	case "Return":
+		f := &Return{}
+		f.Expr = r.node("expr")
+		f.Pos = r.span("pos")
+		if f.Expr == nil {
+			r.fail("Return without expr")
+		}
+		r.end()
+		return f
+	case "Call":
+		f := &Call{}
+		f.Type = r.typ("type")
+		f.Name = r.str("name")
+		f.Args = r.nodes("args")
+		f.Pos = r.span("pos")
+		r.end()
+		return f
+	case "Binary2":
+		f := &Binary2{}
+		f.Type = r.typ("type")
+		f.Op = r.op("op")
+		f.Lhs = r.node("lhs")
+		f.Rhs = r.node("rhs")
+		f.Pos = r.span("pos")
+		if f.Lhs == nil {
+			r.fail("Binary2 without lhs")
+		}
+		if err := f.valid(); err != nil {
+			r.fail("Binary2: %v", err)
+		}
+		r.end()
+		return f
+	case "Unary":
+		f := &Unary{}
+		f.Type = r.typ("type")
+		f.Op = r.op("op")
+		f.Expr = r.node("expr")
+		f.Pos = r.span("pos")
+		if f.Expr == nil {
+			r.fail("Unary without expr")
+		}
+		if err := f.valid(); err != nil {
+			r.fail("Unary: %v", err)
+		}
+		r.end()
+		return f
+	case "Cast":
+		f := &Cast{}
+		f.Type = r.typ("type")
+		f.Exact = r.bool("exact")
+		f.Expr = r.node("expr")
+		f.Pos = r.span("pos")
+		if f.Expr == nil {
+			r.fail("Cast without expr")
+		}
+		r.end()
+		return f
+	case "Identifier":
+		f := &Identifier{}
+		f.Val = r.str("val")
+		f.Pos = r.span("pos")
+		r.end()
+		return f
+	case "Number":
+		f := &Number{}
+		f.Type = r.typ("type")
+		f.Fixed = r.bool("fixed")
+		f.Num = r.int32("num")
+		f.Long = r.int64("long")
+		f.Big = r.bigInt("big")
+		f.Flt = r.float32("flt")
+		f.Dbl = r.float64("dbl")
+		f.Dec = r.rat("dec")
+		f.Bool = r.bool("bool")
+		f.Pos = r.span("pos")
+		if err := f.valid(); err != nil {
+			r.fail("Number: %v", err)
+		}
+		r.end()
+		return f
+	default:
+		r.fail("unknown node %s", tag)
+		return nil
+	}
+}
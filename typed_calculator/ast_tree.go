@@ -1,7 +1,10 @@
 package typedcalculator
 
+import "math/big"
+
 type Node interface {
 	isNode()
+	position() Span
 	accept(Visitor)
 }
 
@@ -15,6 +18,15 @@ const (
 	MULTIPLY
 	DIVIDE
 	POWER
+	EQ
+	NEQ
+	LT
+	GT
+	LTE
+	GTE
+	AND
+	OR
+	NOT
 )
 
 var OpStringMap = map[Op]string{
@@ -24,6 +36,15 @@ var OpStringMap = map[Op]string{
 	MULTIPLY: "MULTIPLY",
 	DIVIDE:   "DIVIDE",
 	POWER:    "POWER",
+	EQ:       "EQ",
+	NEQ:      "NEQ",
+	LT:       "LT",
+	GT:       "GT",
+	LTE:      "LTE",
+	GTE:      "GTE",
+	AND:      "AND",
+	OR:       "OR",
+	NOT:      "NOT",
 }
 
 var StringOpMap = map[string]Op{
@@ -33,34 +54,53 @@ var StringOpMap = map[string]Op{
 	"MULTIPLY": MULTIPLY,
 	"DIVIDE":   DIVIDE,
 	"POWER":    POWER,
+	"EQ":       EQ,
+	"NEQ":      NEQ,
+	"LT":       LT,
+	"GT":       GT,
+	"LTE":      LTE,
+	"GTE":      GTE,
+	"AND":      AND,
+	"OR":       OR,
+	"NOT":      NOT,
 }
 
 type Type int
 
 const (
 	NOTYPE Type = iota
-	FLOAT
-	DOUBLE
 	INT
 	LONG
+	BIGINT
+	FLOAT
+	DOUBLE
+	DECIMAL
+	BOOL
 )
 
 var TypeStringMap = map[Type]string{
-	FLOAT:  "FLOAT",
-	DOUBLE: "DOUBLE",
-	INT:    "INT",
-	LONG:   "LONG",
+	INT:     "INT",
+	LONG:    "LONG",
+	BIGINT:  "BIGINT",
+	FLOAT:   "FLOAT",
+	DOUBLE:  "DOUBLE",
+	DECIMAL: "DECIMAL",
+	BOOL:    "BOOL",
 }
 
 var StringTypeMap = map[string]Type{
-	"FLOAT":  FLOAT,
-	"DOUBLE": DOUBLE,
-	"INT":    INT,
-	"LONG":   LONG,
+	"INT":     INT,
+	"LONG":    LONG,
+	"BIGINT":  BIGINT,
+	"FLOAT":   FLOAT,
+	"DOUBLE":  DOUBLE,
+	"DECIMAL": DECIMAL,
+	"BOOL":    BOOL,
 }
 
 type Program struct {
 	Lines []*Line
+	Pos   Span
 }
 
 func (f *Program) accept(v Visitor) {
@@ -69,6 +109,7 @@ func (f *Program) accept(v Visitor) {
 
 type Line struct {
 	Stmt Node
+	Pos  Span
 }
 
 func (f *Line) accept(v Visitor) {
@@ -77,16 +118,31 @@ func (f *Line) accept(v Visitor) {
 
 type Assignment struct {
 	Type       Type
+	Const      bool
 	Identifier string
 	Expr       Node
+	Pos        Span
 }
 
 func (f *Assignment) accept(v Visitor) {
 	v.visitAssignmentStmt(f)
 }
 
+type Reassign struct {
+	Type       Type
+	Op         Op
+	Identifier string
+	Expr       Node
+	Pos        Span
+}
+
+func (f *Reassign) accept(v Visitor) {
+	v.visitReassignStmt(f)
+}
+
 type Print struct {
 	Expr Node
+	Pos  Span
 }
 
 func (f *Print) accept(v Visitor) {
@@ -94,25 +150,114 @@ func (f *Print) accept(v Visitor) {
 }
 
 type Reset struct {
+	Identifier string
+	Pos        Span
 }
 
 func (f *Reset) accept(v Visitor) {
 	v.visitResetStmt(f)
 }
 
+type Block struct {
+	Lines []*Line
+	Pos   Span
+}
+
+func (f *Block) accept(v Visitor) {
+	v.visitBlockStmt(f)
+}
+
+type If struct {
+	Cond Node
+	Then Node
+	Else Node
+	Pos  Span
+}
+
+func (f *If) accept(v Visitor) {
+	v.visitIfStmt(f)
+}
+
+type While struct {
+	Cond Node
+	Body Node
+	Pos  Span
+}
+
+func (f *While) accept(v Visitor) {
+	v.visitWhileStmt(f)
+}
+
+type Func struct {
+	Name       string
+	Params     []string
+	ParamTypes []Type
+	Result     Type
+	Body       *Block
+	Pos        Span
+}
+
+func (f *Func) accept(v Visitor) {
+	v.visitFuncStmt(f)
+}
+
+type Return struct {
+	Expr Node
+	Pos  Span
+}
+
+func (f *Return) accept(v Visitor) {
+	v.visitReturnStmt(f)
+}
+
+type Call struct {
+	Type Type
+	Name string
+	Args []Node
+	Pos  Span
+}
+
+func (f *Call) accept(v Visitor) {
+	v.visitCallStmt(f)
+}
+
 type Binary2 struct {
 	Type Type
 	Op   Op
 	Lhs  Node
 	Rhs  Node
+	Pos  Span
 }
 
 func (f *Binary2) accept(v Visitor) {
 	v.visitBinary2Stmt(f)
 }
 
+type Unary struct {
+	Type Type
+	Op   Op
+	Expr Node
+	Pos  Span
+}
+
+func (f *Unary) accept(v Visitor) {
+	v.visitUnaryStmt(f)
+}
+
+type Cast struct {
+	Type  Type
+	Exact bool
+	Expr  Node
+	Pos   Span
+}
+
+func (f *Cast) accept(v Visitor) {
+	v.visitCastStmt(f)
+}
+
 type Identifier struct {
 	Val string
+	Pos Span
 }
 
 func (f *Identifier) accept(v Visitor) {
@@ -122,8 +267,14 @@ func (f *Identifier) accept(v Visitor) {
 type Number struct {
 	Type  Type
 	Fixed bool
-	Num   int
-	Flt   float64
+	Num   int32
+	Long  int64
+	Big   *big.Int
+	Flt   float32
+	Dbl   float64
+	Dec   *big.Rat
+	Bool  bool
+	Pos   Span
 }
 
 func (f *Number) accept(v Visitor) {
@@ -133,19 +284,55 @@ func (f *Number) accept(v Visitor) {
 func (f *Program) isNode()    {}
 func (f *Line) isNode()       {}
 func (f *Assignment) isNode() {}
+func (f *Reassign) isNode()   {}
 func (f *Print) isNode()      {}
 func (f *Reset) isNode()      {}
+func (f *Block) isNode()      {}
+func (f *If) isNode()         {}
+func (f *While) isNode()      {}
+func (f *Func) isNode()       {}
+func (f *Return) isNode()     {}
+func (f *Call) isNode()       {}
 func (f *Binary2) isNode()    {}
+func (f *Unary) isNode()      {}
+func (f *Cast) isNode()       {}
 func (f *Identifier) isNode() {}
 func (f *Number) isNode()     {}
 
+func (f *Program) position() Span    { return f.Pos }
+func (f *Line) position() Span       { return f.Pos }
+func (f *Assignment) position() Span { return f.Pos }
+func (f *Reassign) position() Span   { return f.Pos }
+func (f *Print) position() Span      { return f.Pos }
+func (f *Reset) position() Span      { return f.Pos }
+func (f *Block) position() Span      { return f.Pos }
+func (f *If) position() Span         { return f.Pos }
+func (f *While) position() Span      { return f.Pos }
+func (f *Func) position() Span       { return f.Pos }
+func (f *Return) position() Span     { return f.Pos }
+func (f *Call) position() Span       { return f.Pos }
+func (f *Binary2) position() Span    { return f.Pos }
+func (f *Unary) position() Span      { return f.Pos }
+func (f *Cast) position() Span       { return f.Pos }
+func (f *Identifier) position() Span { return f.Pos }
+func (f *Number) position() Span     { return f.Pos }
+
 type Visitor interface {
 	visitProgramStmt(f *Program)
 	visitLineStmt(f *Line)
 	visitAssignmentStmt(f *Assignment)
+	visitReassignStmt(f *Reassign)
 	visitPrintStmt(f *Print)
 	visitResetStmt(f *Reset)
+	visitBlockStmt(f *Block)
+	visitIfStmt(f *If)
+	visitWhileStmt(f *While)
+	visitFuncStmt(f *Func)
+	visitReturnStmt(f *Return)
+	visitCallStmt(f *Call)
 	visitBinary2Stmt(f *Binary2)
+	visitUnaryStmt(f *Unary)
+	visitCastStmt(f *Cast)
 	visitIdentifierStmt(f *Identifier)
 	visitNumberStmt(f *Number)
 }
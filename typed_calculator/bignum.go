@@ -0,0 +1,199 @@
+package typedcalculator
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// DefaultDecimalPlaces is the number of digits after the point that the
+// result of a decimal division, or of a decimal raised to a negative power,
+// is rounded to unless an Eval sets its own DecimalPlaces. Addition,
+// subtraction and multiplication of decimals are exact.
+const DefaultDecimalPlaces = 28
+
+// maxPowBits bounds the size of a bigint or decimal power, so that a
+// typo such as 10**10**10 fails instead of exhausting memory
+const maxPowBits = 1 << 20
+
+var bigOne = big.NewInt(1)
+
+func addBig(a, b *big.Int) (*big.Int, error) { return new(big.Int).Add(a, b), nil }
+func subBig(a, b *big.Int) (*big.Int, error) { return new(big.Int).Sub(a, b), nil }
+func mulBig(a, b *big.Int) (*big.Int, error) { return new(big.Int).Mul(a, b), nil }
+
+// divBig truncates towards zero like divLong
+func divBig(a, b *big.Int) (*big.Int, error) {
+	if b.Sign() == 0 {
+		return nil, fmt.Errorf("divide by zero")
+	}
+	return new(big.Int).Quo(a, b), nil
+}
+
+// powBig raises x to y, a negative power truncates towards zero like powLong
+func powBig(x, y *big.Int) (*big.Int, error) {
+	if y.Sign() < 0 {
+		switch {
+		case x.Sign() == 0:
+			return nil, fmt.Errorf("divide by zero")
+		case x.CmpAbs(bigOne) == 0:
+			if x.Sign() > 0 || y.Bit(0) == 0 {
+				return big.NewInt(1), nil
+			}
+			return big.NewInt(-1), nil
+		}
+		return new(big.Int), nil
+	}
+	if err := checkPow(x, y); err != nil {
+		return nil, err
+	}
+	return new(big.Int).Exp(x, y, nil), nil
+}
+
+// checkPow reports a power of x that would have more than maxPowBits bits
+func checkPow(x, y *big.Int) error {
+	if x.CmpAbs(bigOne) <= 0 {
+		return nil
+	}
+	if !y.IsInt64() || float64(x.BitLen()-1)*float64(y.Int64()) > maxPowBits {
+		return fmt.Errorf("%s**%s is too large", x, y)
+	}
+	return nil
+}
+
+func addRat(a, b *big.Rat) (*big.Rat, error) { return new(big.Rat).Add(a, b), nil }
+func subRat(a, b *big.Rat) (*big.Rat, error) { return new(big.Rat).Sub(a, b), nil }
+func mulRat(a, b *big.Rat) (*big.Rat, error) { return new(big.Rat).Mul(a, b), nil }
+
+// divRat rounds the quotient to places digits after the point
+func divRat(a, b *big.Rat, places int) (*big.Rat, error) {
+	if b.Sign() == 0 {
+		return nil, fmt.Errorf("divide by zero")
+	}
+	return roundDecimal(new(big.Rat).Quo(a, b), places), nil
+}
+
+// powRat raises x to the integer y, a negative power is rounded to places
+// digits after the point
+func powRat(x, y *big.Rat, places int) (*big.Rat, error) {
+	if !y.IsInt() {
+		return nil, fmt.Errorf("a decimal can only be raised to an integer power, not %s", decimalString(y))
+	}
+	e := new(big.Int).Abs(y.Num())
+	if err := checkPow(x.Num(), e); err != nil {
+		return nil, err
+	}
+	if err := checkPow(x.Denom(), e); err != nil {
+		return nil, err
+	}
+	num := new(big.Int).Exp(x.Num(), e, nil)
+	den := new(big.Int).Exp(x.Denom(), e, nil)
+	if y.Sign() >= 0 {
+		return new(big.Rat).SetFrac(num, den), nil
+	}
+	if num.Sign() == 0 {
+		return nil, fmt.Errorf("divide by zero")
+	}
+	return roundDecimal(new(big.Rat).SetFrac(den, num), places), nil
+}
+
+// roundDecimal rounds r to places digits after the point, halfway values
+// are rounded to the even digit
+func roundDecimal(r *big.Rat, places int) *big.Rat {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil)
+	num := new(big.Int).Mul(r.Num(), scale)
+	q, m := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	m.Abs(m).Lsh(m, 1)
+	if c := m.Cmp(r.Denom()); c > 0 || (c == 0 && q.Bit(0) == 1) {
+		if num.Sign() < 0 {
+			q.Sub(q, bigOne)
+		} else {
+			q.Add(q, bigOne)
+		}
+	}
+	return new(big.Rat).SetFrac(q, scale)
+}
+
+// decimalString formats r with every digit it has after the point. A
+// decimal always has a finite number of them, as its divisions are rounded.
+func decimalString(r *big.Rat) string {
+	d := new(big.Int).Set(r.Denom())
+	twos := d.TrailingZeroBits()
+	d.Rsh(d, twos)
+	fives := uint(0)
+	five := big.NewInt(5)
+	for m := new(big.Int); ; fives++ {
+		q, _ := new(big.Int).QuoRem(d, five, m)
+		if m.Sign() != 0 {
+			break
+		}
+		d = q
+	}
+	if d.Cmp(bigOne) != 0 {
+		return r.FloatString(DefaultDecimalPlaces)
+	}
+	if fives > twos {
+		twos = fives
+	}
+	return r.FloatString(int(twos))
+}
+
+// bigValue truncates n towards zero, a NaN or an infinity is 0
+func bigValue(n Number) *big.Int {
+	switch n.Type {
+	case INT:
+		return big.NewInt(int64(n.Num))
+	case LONG:
+		return big.NewInt(n.Long)
+	case BIGINT:
+		return n.Big
+	case DECIMAL:
+		return new(big.Int).Quo(n.Dec.Num(), n.Dec.Denom())
+	}
+	d := floatValue(n)
+	if math.IsNaN(d) || math.IsInf(d, 0) {
+		return new(big.Int)
+	}
+	res, _ := big.NewFloat(d).Int(nil)
+	return res
+}
+
+// ratValue returns the exact value of n, or nil for a NaN or an infinity.
+// A decimal literal keeps the digits it was written with.
+func ratValue(n Number) *big.Rat {
+	switch n.Type {
+	case INT:
+		return new(big.Rat).SetInt64(int64(n.Num))
+	case LONG:
+		return new(big.Rat).SetInt64(n.Long)
+	case BIGINT:
+		return new(big.Rat).SetInt(n.Big)
+	case DECIMAL:
+		return n.Dec
+	}
+	if n.Dec != nil {
+		return n.Dec
+	}
+	return new(big.Rat).SetFloat64(floatValue(n))
+}
+
+// floatValue returns n rounded to the nearest float64
+func floatValue(n Number) float64 {
+	switch n.Type {
+	case INT:
+		return float64(n.Num)
+	case LONG:
+		return float64(n.Long)
+	case BIGINT:
+		d, _ := new(big.Float).SetInt(n.Big).Float64()
+		return d
+	case FLOAT:
+		return float64(n.Flt)
+	case DOUBLE:
+		return n.Dbl
+	case DECIMAL:
+		d, _ := n.Dec.Float64()
+		return d
+	}
+	return 0
+}
@@ -0,0 +1,31 @@
+// Command tcalcfmt formats typed calculator programs. Given .calc files it
+// writes each of them formatted to stdout, or rewrites or lists the ones
+// that are not formatted. Without files it formats stdin. Comments are
+// kept, see typedcalculator.FormatSource.
+package main
+
+import (
+	"calctools/fmtcmd"
+	typedcalculator "calculator"
+)
+
+// diagnostics renders each syntax error of err
+func diagnostics(src string, err error) []string {
+	errs, ok := err.(typedcalculator.ErrorList)
+	if !ok {
+		return []string{err.Error() + "\n"}
+	}
+	res := make([]string, len(errs))
+	for i, cerr := range errs {
+		res[i] = cerr.Diagnostic(src)
+	}
+	return res
+}
+
+func newFormatter() *fmtcmd.Formatter {
+	return &fmtcmd.Formatter{Format: typedcalculator.FormatSource, Diagnostics: diagnostics}
+}
+
+func main() {
+	fmtcmd.Main("tcalcfmt", newFormatter())
+}
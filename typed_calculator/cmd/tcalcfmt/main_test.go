@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcalcfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	messy := filepath.Join(dir, "messy.calc")
+	tidy := filepath.Join(dir, "tidy.calc")
+	broken := filepath.Join(dir, "broken.calc")
+	for file, src := range map[string]string{
+		messy:  "int a=(1+2) # three\nprint( a )",
+		tidy:   "int a = 1 + 2 # three\nprint a\n",
+		broken: "int = 1",
+	} {
+		if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out, errOut bytes.Buffer
+	f := newFormatter()
+	f.Out, f.ErrOut = &out, &errOut
+	if !f.FormatFile(messy) || out.String() != "int a = 1 + 2 # three\nprint a\n" {
+		t.Errorf("expected messy.calc to be formatted got %q", out.String())
+	}
+
+	out.Reset()
+	f.List, f.Write = true, true
+	for _, file := range []string{messy, tidy} {
+		if !f.FormatFile(file) {
+			t.Fatalf("could not format %s: %s", file, errOut.String())
+		}
+	}
+	if out.String() != messy+"\n" {
+		t.Errorf("expected only messy.calc to be listed got %q", out.String())
+	}
+	if src, _ := ioutil.ReadFile(messy); string(src) != "int a = 1 + 2 # three\nprint a\n" {
+		t.Errorf("expected messy.calc to be rewritten got %q", src)
+	}
+
+	if f.FormatFile(broken) {
+		t.Errorf("expected broken.calc to fail")
+	}
+	if !strings.HasPrefix(errOut.String(), broken+": syntax error") {
+		t.Errorf("expected a syntax error in broken.calc got %q", errOut.String())
+	}
+	if src, _ := ioutil.ReadFile(broken); string(src) != "int = 1" {
+		t.Errorf("expected broken.calc to be left alone got %q", src)
+	}
+}
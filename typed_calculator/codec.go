@@ -0,0 +1,876 @@
+package typedcalculator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+)
+
+// nodeWriter encodes the nodes writeNode walks. A node is written as begin
+// with its tag, its fields in the order they are declared, and end.
+type nodeWriter interface {
+	begin(tag string)
+	end()
+	null()
+	node(name string, n Node)
+	block(name string, b *Block)
+	lines(name string, ls []*Line)
+	nodes(name string, ns []Node)
+	strings(name string, ss []string)
+	types(name string, ts []Type)
+	typ(name string, t Type)
+	op(name string, o Op)
+	bool(name string, b bool)
+	str(name string, s string)
+	int32(name string, v int32)
+	int64(name string, v int64)
+	float32(name string, v float32)
+	float64(name string, v float64)
+	bigInt(name string, v *big.Int)
+	rat(name string, v *big.Rat)
+	span(name string, s Span)
+}
+
+// nodeReader decodes what a nodeWriter encoded. begin returns the tag of
+// the next node, or "" for null. After the first error, which fail
+// records, it returns zero values.
+type nodeReader interface {
+	begin() string
+	end()
+	fail(format string, a ...interface{})
+	node(name string) Node
+	block(name string) *Block
+	lines(name string) []*Line
+	nodes(name string) []Node
+	strings(name string) []string
+	types(name string) []Type
+	typ(name string) Type
+	op(name string) Op
+	bool(name string) bool
+	str(name string) string
+	int32(name string) int32
+	int64(name string) int64
+	float32(name string) float32
+	float64(name string) float64
+	bigInt(name string) *big.Int
+	rat(name string) *big.Rat
+	span(name string) Span
+}
+
+// EncodeJSON encodes the ast rooted at node as JSON. A node is an object
+// whose "node" member is the name of its type, e.g. {"node": "Identifier",
+// "val": "x", "pos": [0, 1, 1, 1, 1, 2]}. Its other members are its fields
+// with a lower case first letter, fields with a zero value are left out.
+// Ops and types are their names in OpStringMap and TypeStringMap, a Span
+// is the offset, line and column of its start and then of its end, and
+// big numbers are strings.
+func EncodeJSON(node Node) []byte {
+	w := &jsonWriter{}
+	writeNode(w, node)
+	return w.buf.Bytes()
+}
+
+// DecodeJSON decodes an ast encoded by EncodeJSON. Input that is not an
+// ast, e.g. a Line without a statement or a bigint without a value, is an
+// error.
+func DecodeJSON(data []byte) (Node, error) {
+	r := &jsonReader{next: data}
+	n := readNode(r)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return n, nil
+}
+
+// binaryMagic starts every binary encoding, its last byte is the version
+const binaryMagic = "TCA\x01"
+
+// EncodeBinary encodes the ast rooted at node compactly. Numbers are
+// varints, and a string, which includes the tags of the nodes and the
+// names of ops and types, is only written the first time and referred to
+// by number after that.
+func EncodeBinary(node Node) []byte {
+	w := &binaryWriter{buf: []byte(binaryMagic), strs: make(map[string]int)}
+	writeNode(w, node)
+	return w.buf
+}
+
+// DecodeBinary decodes an ast encoded by EncodeBinary, and fails like
+// DecodeJSON on input that is not an ast
+func DecodeBinary(data []byte) (Node, error) {
+	if !bytes.HasPrefix(data, []byte(binaryMagic)) {
+		return nil, fmt.Errorf("not a binary encoded ast")
+	}
+	r := &binaryReader{data: data[len(binaryMagic):]}
+	n := readNode(r)
+	if r.err == nil && len(r.data) > 0 {
+		r.fail("%d bytes after the ast", len(r.data))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return n, nil
+}
+
+// jsonWriter writes each node as an object, its members in field order
+type jsonWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *jsonWriter) begin(tag string) {
+	fmt.Fprintf(&w.buf, `{"node":%q`, tag)
+}
+
+func (w *jsonWriter) end() {
+	w.buf.WriteByte('}')
+}
+
+func (w *jsonWriter) null() {
+	w.buf.WriteString("null")
+}
+
+// member writes the name of a member, the value follows
+func (w *jsonWriter) member(name string) {
+	fmt.Fprintf(&w.buf, ",%q:", name)
+}
+
+// value writes a member whose value json.Marshal can encode
+func (w *jsonWriter) value(name string, v interface{}) {
+	w.member(name)
+	data, _ := json.Marshal(v)
+	w.buf.Write(data)
+}
+
+func (w *jsonWriter) node(name string, n Node) {
+	if n != nil {
+		w.member(name)
+		writeNode(w, n)
+	}
+}
+
+func (w *jsonWriter) block(name string, b *Block) {
+	if b != nil {
+		w.node(name, b)
+	}
+}
+
+func (w *jsonWriter) lines(name string, ls []*Line) {
+	ns := make([]Node, len(ls))
+	for i, l := range ls {
+		ns[i] = l
+	}
+	w.nodes(name, ns)
+}
+
+func (w *jsonWriter) nodes(name string, ns []Node) {
+	w.member(name)
+	w.buf.WriteByte('[')
+	for i, n := range ns {
+		if i > 0 {
+			w.buf.WriteByte(',')
+		}
+		writeNode(w, n)
+	}
+	w.buf.WriteByte(']')
+}
+
+func (w *jsonWriter) strings(name string, ss []string) {
+	if len(ss) > 0 {
+		w.value(name, ss)
+	}
+}
+
+func (w *jsonWriter) types(name string, ts []Type) {
+	if len(ts) > 0 {
+		names := make([]string, len(ts))
+		for i, t := range ts {
+			names[i] = TypeStringMap[t]
+		}
+		w.value(name, names)
+	}
+}
+
+func (w *jsonWriter) typ(name string, t Type) {
+	if t != NOTYPE {
+		w.value(name, TypeStringMap[t])
+	}
+}
+
+func (w *jsonWriter) op(name string, o Op) {
+	if o != ILLEGALOP {
+		w.value(name, OpStringMap[o])
+	}
+}
+
+func (w *jsonWriter) bool(name string, b bool) {
+	if b {
+		w.value(name, b)
+	}
+}
+
+func (w *jsonWriter) str(name string, s string) {
+	if s != "" {
+		w.value(name, s)
+	}
+}
+
+func (w *jsonWriter) int32(name string, v int32) {
+	if v != 0 {
+		w.value(name, v)
+	}
+}
+
+func (w *jsonWriter) int64(name string, v int64) {
+	if v != 0 {
+		w.value(name, v)
+	}
+}
+
+// float writes a float as a number, or as a string when JSON has no number
+// for it, e.g. "+Inf"
+func (w *jsonWriter) float(name string, v float64, size int) {
+	if v == 0 && !math.Signbit(v) {
+		return
+	}
+	w.member(name)
+	s := strconv.FormatFloat(v, 'g', -1, size)
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		s = strconv.Quote(s)
+	}
+	w.buf.WriteString(s)
+}
+
+func (w *jsonWriter) float32(name string, v float32) {
+	w.float(name, float64(v), 32)
+}
+
+func (w *jsonWriter) float64(name string, v float64) {
+	w.float(name, v, 64)
+}
+
+func (w *jsonWriter) bigInt(name string, v *big.Int) {
+	if v != nil {
+		w.value(name, v.String())
+	}
+}
+
+func (w *jsonWriter) rat(name string, v *big.Rat) {
+	if v != nil {
+		w.value(name, v.String())
+	}
+}
+
+func (w *jsonWriter) span(name string, s Span) {
+	if s != (Span{}) {
+		w.value(name, [6]int{s.Start.Offset, s.Start.Line, s.Start.Col, s.End.Offset, s.End.Line, s.End.Col})
+	}
+}
+
+// jsonReader reads the members of the objects being decoded, the innermost
+// last
+type jsonReader struct {
+	objs []map[string]json.RawMessage
+	next json.RawMessage //the node begin decodes
+	err  error
+}
+
+func (r *jsonReader) fail(format string, a ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf(format, a...)
+	}
+}
+
+func (r *jsonReader) begin() string {
+	if r.err != nil || len(r.next) == 0 || string(r.next) == "null" {
+		return ""
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(r.next, &obj); err != nil {
+		r.fail("%v", err)
+		return ""
+	}
+	var tag string
+	if err := json.Unmarshal(obj["node"], &tag); err != nil || tag == "" {
+		r.fail("an object without a node tag")
+		return ""
+	}
+	r.objs = append(r.objs, obj)
+	return tag
+}
+
+func (r *jsonReader) end() {
+	r.objs = r.objs[:len(r.objs)-1]
+}
+
+// value decodes the member name of the current object into v, a missing
+// member leaves v alone
+func (r *jsonReader) value(name string, v interface{}) {
+	if r.err != nil {
+		return
+	}
+	data, ok := r.objs[len(r.objs)-1][name]
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		r.fail("member %s: %v", name, err)
+	}
+}
+
+func (r *jsonReader) node(name string) Node {
+	if r.err != nil {
+		return nil
+	}
+	r.next = r.objs[len(r.objs)-1][name]
+	return readNode(r)
+}
+
+func (r *jsonReader) block(name string) *Block {
+	n := r.node(name)
+	b, ok := n.(*Block)
+	if n != nil && !ok {
+		r.fail("member %s: expected a Block got %T", name, n)
+	}
+	return b
+}
+
+func (r *jsonReader) lines(name string) []*Line {
+	ns := r.nodes(name)
+	ls := make([]*Line, len(ns))
+	for i, n := range ns {
+		l, ok := n.(*Line)
+		if !ok {
+			r.fail("member %s: expected a Line got %T", name, n)
+		}
+		ls[i] = l
+	}
+	return ls
+}
+
+func (r *jsonReader) nodes(name string) []Node {
+	var raw []json.RawMessage
+	r.value(name, &raw)
+	ns := make([]Node, len(raw))
+	for i := range raw {
+		r.next = raw[i]
+		if ns[i] = readNode(r); ns[i] == nil {
+			r.fail("member %s: a null node", name)
+		}
+	}
+	return ns
+}
+
+func (r *jsonReader) strings(name string) []string {
+	ss := make([]string, 0)
+	r.value(name, &ss)
+	return ss
+}
+
+func (r *jsonReader) types(name string) []Type {
+	names := r.strings(name)
+	ts := make([]Type, len(names))
+	for i, s := range names {
+		ts[i] = r.typeNamed(s)
+	}
+	return ts
+}
+
+// typeNamed looks up the type with the name s, "" is NOTYPE
+func (r *jsonReader) typeNamed(s string) Type {
+	t, ok := StringTypeMap[s]
+	if s != "" && !ok {
+		r.fail("unknown type %s", s)
+	}
+	return t
+}
+
+func (r *jsonReader) typ(name string) Type {
+	var s string
+	r.value(name, &s)
+	return r.typeNamed(s)
+}
+
+func (r *jsonReader) op(name string) Op {
+	var s string
+	r.value(name, &s)
+	o, ok := StringOpMap[s]
+	if s != "" && !ok {
+		r.fail("unknown op %s", s)
+	}
+	return o
+}
+
+func (r *jsonReader) bool(name string) bool {
+	var b bool
+	r.value(name, &b)
+	return b
+}
+
+func (r *jsonReader) str(name string) string {
+	var s string
+	r.value(name, &s)
+	return s
+}
+
+func (r *jsonReader) int32(name string) int32 {
+	var v int32
+	r.value(name, &v)
+	return v
+}
+
+func (r *jsonReader) int64(name string) int64 {
+	var v int64
+	r.value(name, &v)
+	return v
+}
+
+// float reads a number, or a string written for a float JSON has no
+// number for
+func (r *jsonReader) float(name string, size int) float64 {
+	var raw json.RawMessage
+	r.value(name, &raw)
+	if r.err != nil || raw == nil {
+		return 0
+	}
+	s := string(raw)
+	if uq, err := strconv.Unquote(s); err == nil {
+		s = uq
+	}
+	v, err := strconv.ParseFloat(s, size)
+	if err != nil {
+		r.fail("member %s: %v", name, err)
+	}
+	return v
+}
+
+func (r *jsonReader) float32(name string) float32 {
+	return float32(r.float(name, 32))
+}
+
+func (r *jsonReader) float64(name string) float64 {
+	return r.float(name, 64)
+}
+
+func (r *jsonReader) bigInt(name string) *big.Int {
+	var s *string
+	r.value(name, &s)
+	if s == nil {
+		return nil
+	}
+	v, ok := new(big.Int).SetString(*s, 10)
+	if !ok {
+		r.fail("member %s: %q is not an integer", name, *s)
+	}
+	return v
+}
+
+func (r *jsonReader) rat(name string) *big.Rat {
+	var s *string
+	r.value(name, &s)
+	if s == nil {
+		return nil
+	}
+	v, ok := new(big.Rat).SetString(*s)
+	if !ok {
+		r.fail("member %s: %q is not a fraction", name, *s)
+	}
+	return v
+}
+
+func (r *jsonReader) span(name string) Span {
+	var s [6]int
+	r.value(name, &s)
+	return Span{
+		Start: Position{Offset: s[0], Line: s[1], Col: s[2]},
+		End:   Position{Offset: s[3], Line: s[4], Col: s[5]},
+	}
+}
+
+// binaryWriter writes every field in order without its name. strs numbers
+// the strings written so far.
+type binaryWriter struct {
+	buf  []byte
+	strs map[string]int
+}
+
+func (w *binaryWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func (w *binaryWriter) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf = append(w.buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+// bytes writes a length and then data
+func (w *binaryWriter) bytes(data []byte) {
+	w.uvarint(uint64(len(data)))
+	w.buf = append(w.buf, data...)
+}
+
+// string writes 0 and s the first time s is written, and the number of s
+// plus one after that
+func (w *binaryWriter) string(s string) {
+	if i, ok := w.strs[s]; ok {
+		w.uvarint(uint64(i) + 1)
+		return
+	}
+	w.strs[s] = len(w.strs)
+	w.uvarint(0)
+	w.bytes([]byte(s))
+}
+
+// begin writes the tag of a node, null is the empty tag
+func (w *binaryWriter) begin(tag string) { w.string(tag) }
+func (w *binaryWriter) end()             {}
+func (w *binaryWriter) null()            { w.string("") }
+
+func (w *binaryWriter) node(name string, n Node) {
+	writeNode(w, n)
+}
+
+func (w *binaryWriter) block(name string, b *Block) {
+	if b == nil {
+		w.null()
+		return
+	}
+	writeNode(w, b)
+}
+
+func (w *binaryWriter) lines(name string, ls []*Line) {
+	w.uvarint(uint64(len(ls)))
+	for _, l := range ls {
+		writeNode(w, l)
+	}
+}
+
+func (w *binaryWriter) nodes(name string, ns []Node) {
+	w.uvarint(uint64(len(ns)))
+	for _, n := range ns {
+		writeNode(w, n)
+	}
+}
+
+func (w *binaryWriter) strings(name string, ss []string) {
+	w.uvarint(uint64(len(ss)))
+	for _, s := range ss {
+		w.string(s)
+	}
+}
+
+func (w *binaryWriter) types(name string, ts []Type) {
+	w.uvarint(uint64(len(ts)))
+	for _, t := range ts {
+		w.string(TypeStringMap[t])
+	}
+}
+
+func (w *binaryWriter) typ(name string, t Type)   { w.string(TypeStringMap[t]) }
+func (w *binaryWriter) op(name string, o Op)      { w.string(OpStringMap[o]) }
+func (w *binaryWriter) str(name string, s string) { w.string(s) }
+
+func (w *binaryWriter) bool(name string, b bool) {
+	if b {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *binaryWriter) int32(name string, v int32) { w.varint(int64(v)) }
+func (w *binaryWriter) int64(name string, v int64) { w.varint(v) }
+
+// float64 writes the bits of v with their bytes reversed, like encoding/gob
+// does, so the varint of a float with a short mantissa is short
+func (w *binaryWriter) float64(name string, v float64) {
+	w.uvarint(bits.ReverseBytes64(math.Float64bits(v)))
+}
+
+func (w *binaryWriter) float32(name string, v float32) {
+	w.float64(name, float64(v))
+}
+
+// bigInt writes the gob encoding of v, which is empty for nil
+func (w *binaryWriter) bigInt(name string, v *big.Int) {
+	data, _ := v.GobEncode()
+	w.bytes(data)
+}
+
+func (w *binaryWriter) rat(name string, v *big.Rat) {
+	data, _ := v.GobEncode()
+	w.bytes(data)
+}
+
+// span writes the start of s and the distance to its end
+func (w *binaryWriter) span(name string, s Span) {
+	w.varint(int64(s.Start.Offset))
+	w.varint(int64(s.Start.Line))
+	w.varint(int64(s.Start.Col))
+	w.varint(int64(s.End.Offset - s.Start.Offset))
+	w.varint(int64(s.End.Line - s.Start.Line))
+	w.varint(int64(s.End.Col))
+}
+
+// binaryReader reads the fields in the order binaryWriter wrote them
+type binaryReader struct {
+	data []byte
+	strs []string
+	err  error
+}
+
+func (r *binaryReader) fail(format string, a ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf(format, a...)
+	}
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.fail("malformed varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.fail("malformed varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// count reads the length of a list or of bytes, which cannot be longer
+// than what is left to read
+func (r *binaryReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.fail("a length of %d with %d bytes left", n, len(r.data))
+		return 0
+	}
+	return int(n)
+}
+
+func (r *binaryReader) bytes() []byte {
+	n := r.count()
+	data := r.data[:n]
+	r.data = r.data[n:]
+	return data
+}
+
+func (r *binaryReader) string() string {
+	i := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if i == 0 {
+		s := string(r.bytes())
+		r.strs = append(r.strs, s)
+		return s
+	}
+	if i > uint64(len(r.strs)) {
+		r.fail("unknown string %d", i)
+		return ""
+	}
+	return r.strs[i-1]
+}
+
+func (r *binaryReader) begin() string { return r.string() }
+func (r *binaryReader) end()          {}
+
+func (r *binaryReader) node(name string) Node {
+	return readNode(r)
+}
+
+func (r *binaryReader) block(name string) *Block {
+	n := readNode(r)
+	b, ok := n.(*Block)
+	if n != nil && !ok {
+		r.fail("%s: expected a Block got %T", name, n)
+	}
+	return b
+}
+
+func (r *binaryReader) lines(name string) []*Line {
+	ls := make([]*Line, r.count())
+	for i := range ls {
+		n := readNode(r)
+		l, ok := n.(*Line)
+		if !ok {
+			r.fail("%s: expected a Line got %T", name, n)
+		}
+		ls[i] = l
+	}
+	return ls
+}
+
+func (r *binaryReader) nodes(name string) []Node {
+	ns := make([]Node, r.count())
+	for i := range ns {
+		if ns[i] = readNode(r); ns[i] == nil {
+			r.fail("%s: a null node", name)
+		}
+	}
+	return ns
+}
+
+func (r *binaryReader) strings(name string) []string {
+	ss := make([]string, r.count())
+	for i := range ss {
+		ss[i] = r.string()
+	}
+	return ss
+}
+
+func (r *binaryReader) types(name string) []Type {
+	ts := make([]Type, r.count())
+	for i := range ts {
+		ts[i] = r.typ(name)
+	}
+	return ts
+}
+
+func (r *binaryReader) typ(name string) Type {
+	s := r.string()
+	t, ok := StringTypeMap[s]
+	if s != "" && !ok {
+		r.fail("unknown type %s", s)
+	}
+	return t
+}
+
+func (r *binaryReader) op(name string) Op {
+	s := r.string()
+	o, ok := StringOpMap[s]
+	if s != "" && !ok {
+		r.fail("unknown op %s", s)
+	}
+	return o
+}
+
+func (r *binaryReader) bool(name string) bool {
+	if r.err != nil {
+		return false
+	}
+	if len(r.data) == 0 || r.data[0] > 1 {
+		r.fail("malformed bool")
+		return false
+	}
+	b := r.data[0] == 1
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *binaryReader) str(name string) string { return r.string() }
+
+func (r *binaryReader) int32(name string) int32 {
+	v := r.varint()
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		r.fail("%s: %d does not fit in 32 bits", name, v)
+	}
+	return int32(v)
+}
+
+func (r *binaryReader) int64(name string) int64 { return r.varint() }
+
+func (r *binaryReader) float64(name string) float64 {
+	return math.Float64frombits(bits.ReverseBytes64(r.uvarint()))
+}
+
+func (r *binaryReader) float32(name string) float32 {
+	return float32(r.float64(name))
+}
+
+func (r *binaryReader) bigInt(name string) *big.Int {
+	data := r.bytes()
+	if len(data) == 0 {
+		return nil
+	}
+	v := new(big.Int)
+	if err := v.GobDecode(data); err != nil {
+		r.fail("%s: %v", name, err)
+	}
+	return v
+}
+
+func (r *binaryReader) rat(name string) *big.Rat {
+	data := r.bytes()
+	if len(data) == 0 {
+		return nil
+	}
+	v := new(big.Rat)
+	if err := v.GobDecode(data); err != nil {
+		r.fail("%s: %v", name, err)
+	}
+	return v
+}
+
+func (r *binaryReader) span(name string) Span {
+	var s Span
+	s.Start.Offset = int(r.varint())
+	s.Start.Line = int(r.varint())
+	s.Start.Col = int(r.varint())
+	s.End.Offset = s.Start.Offset + int(r.varint())
+	s.End.Line = s.Start.Line + int(r.varint())
+	s.End.Col = int(r.varint())
+	return s
+}
+
+// valid reports a function whose parameters do not each have a type
+func (f *Func) valid() error {
+	if len(f.Params) != len(f.ParamTypes) {
+		return fmt.Errorf("%d params with %d types", len(f.Params), len(f.ParamTypes))
+	}
+	return nil
+}
+
+// valid reports a binary operator that is not one, or whose rhs is missing.
+// Only the NOOP wrapper of an operand has no rhs.
+func (f *Binary2) valid() error {
+	switch {
+	case f.Op == ILLEGALOP || f.Op == NOT:
+		return fmt.Errorf("%s is not a binary operator", OpStringMap[f.Op])
+	case f.Op == NOOP && f.Rhs != nil:
+		return fmt.Errorf("NOOP with a rhs")
+	case f.Op != NOOP && f.Rhs == nil:
+		return fmt.Errorf("%s without a rhs", OpStringMap[f.Op])
+	}
+	return nil
+}
+
+func (f *Unary) valid() error {
+	if f.Op != MINUS && f.Op != NOT {
+		return fmt.Errorf("%s is not a unary operator", OpStringMap[f.Op])
+	}
+	return nil
+}
+
+// valid reports a literal without a type or without the value its type
+// keeps in a big number
+func (f *Number) valid() error {
+	switch {
+	case f.Type == NOTYPE:
+		return fmt.Errorf("a literal without a type")
+	case f.Type == BIGINT && f.Big == nil:
+		return fmt.Errorf("a bigint without a value")
+	case f.Type == DECIMAL && f.Dec == nil:
+		return fmt.Errorf("a decimal without a value")
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package typedcalculator
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestCodec(t *testing.T) {
+	srcs := []string{
+		"int x = 6; const long l = 5; x += 1; x -= 2; reset x; reset; print -(3 - 5) ** 2",
+		"float f = -0.0; double d = 0.1; decimal m = 0.1; print f + d * m; print 2147483648 * 9223372036854775808",
+		"print true && 1 < 2 || !false; print float(0.1) + int(2.75) / 1",
+		"if 1 < 2 then print 1 else { print 2 }; while false {}",
+		"func f(int a, double b) long { return a * 1 + 2 * 3 }; print f(2 + 2, 1)",
+	}
+	for _, file := range []string{"testdata/gcd.calc", "testdata/circle.calc"} {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srcs = append(srcs, string(src))
+	}
+
+	nodes := make([]Node, 0)
+	for _, src := range srcs {
+		//parsed, checked and optimized
+		for i := 0; i < 3; i++ {
+			node, err := BuildParser().Parse(src)
+			if err != nil {
+				t.Fatalf("could not parse %q: %v", src, err)
+			}
+			if i > 0 {
+				if err := NewTypeChecker(nil, nil).Run(node); err != nil {
+					t.Fatalf("could not check %q: %v", src, err)
+				}
+			}
+			if i > 1 {
+				node = Optimize(node)
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	nodes = append(nodes, &Print{Expr: &Binary2{Type: DOUBLE, Op: PLUS,
+		Lhs: &Number{Type: DOUBLE, Dbl: math.Inf(-1)},
+		Rhs: &Cast{Type: DOUBLE, Exact: true, Expr: &Number{Type: FLOAT, Flt: float32(math.NaN())}}}}, nil)
+
+	codecs := []struct {
+		name   string
+		encode func(Node) []byte
+		decode func([]byte) (Node, error)
+	}{
+		{"json", EncodeJSON, DecodeJSON},
+		{"binary", EncodeBinary, DecodeBinary},
+	}
+	for _, node := range nodes {
+		for _, c := range codecs {
+			data := c.encode(node)
+			got, err := c.decode(data)
+			if err != nil {
+				t.Errorf("could not decode the %s of %s: %v", c.name, shape(reflect.ValueOf(node)), err)
+				continue
+			}
+			//the shape leaves out positions, which the encoding has
+			if shape(reflect.ValueOf(got)) != shape(reflect.ValueOf(node)) || !bytes.Equal(c.encode(got), data) {
+				t.Errorf("expected %s to decode to\n%s\ngot\n%s", c.name, shape(reflect.ValueOf(node)), shape(reflect.ValueOf(got)))
+			}
+		}
+		if node != nil && len(EncodeBinary(node)) >= len(EncodeJSON(node)) {
+			t.Errorf("expected the binary encoding of %s to be smaller", shape(reflect.ValueOf(node)))
+		}
+	}
+
+	node := &Assignment{Type: BIGINT, Identifier: "b", Expr: &Number{Type: BIGINT, Big: big.NewInt(-7)},
+		Pos: Span{Start: Position{Offset: 0, Line: 1, Col: 1}, End: Position{Offset: 13, Line: 1, Col: 14}}}
+	want := `{"node":"Assignment","type":"BIGINT","identifier":"b","expr":{"node":"Number","type":"BIGINT","big":"-7"},"pos":[0,1,1,13,1,14]}`
+	if got := string(EncodeJSON(node)); got != want {
+		t.Errorf("expected %s got %s", want, got)
+	}
+
+	//a decoded program runs like the source it was parsed from
+	for _, file := range []string{"testdata/gcd.calc", "testdata/circle.calc"} {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := CreateEvaluator(false)
+		if err := want.Run(string(src)); err != nil {
+			t.Fatal(err)
+		}
+		node, _ := BuildParser().Parse(string(src))
+		NewTypeChecker(nil, nil).Run(node)
+		node = Optimize(node)
+		for _, c := range codecs {
+			decoded, err := c.decode(c.encode(node))
+			got := CreateEvaluator(false)
+			if err == nil {
+				err = got.RunNode(decoded)
+			}
+			if err != nil || fmt.Sprint(got.PrintVals) != fmt.Sprint(want.PrintVals) {
+				t.Errorf("expected %s decoded from %s to print %v got %v, %v", file, c.name, want.PrintVals, got.PrintVals, err)
+			}
+		}
+	}
+
+	//malformed input is an error
+	data := EncodeBinary(nodes[len(nodes)-2])
+	for i := 0; i < len(data); i++ {
+		if _, err := DecodeBinary(data[:i]); err == nil {
+			t.Errorf("expected %q to be malformed", data[:i])
+		}
+	}
+	for _, bad := range []string{
+		`{`,
+		`{"lines":[]}`,
+		`{"node":"Sum"}`,
+		`{"node":"Print","expr":{"node":"Binary2","op":"XOR"}}`,
+		`{"node":"Number","type":"COMPLEX"}`,
+		`{"node":"Number","big":"1x"}`,
+		`{"node":"Func","body":{"node":"Print"}}`,
+		`{"node":"Program","lines":[{"node":"Print"}]}`,
+		`{"node":"Identifier","pos":"here"}`,
+		`{"node":"Line"}`,
+		`{"node":"Binary2","op":"PLUS"}`,
+		`{"node":"Binary2","op":"PLUS","lhs":{"node":"Identifier","val":"x"}}`,
+		`{"node":"Unary","op":"PLUS","expr":{"node":"Identifier","val":"x"}}`,
+		`{"node":"Number","type":"BIGINT"}`,
+		`{"node":"Number","type":"DECIMAL","num":1}`,
+		`{"node":"Number"}`,
+		`{"node":"Func","params":["a"],"body":{"node":"Block","lines":[]}}`,
+		`{"node":"Call","name":"f","args":[null]}`,
+		`{"node":"If","then":{"node":"Print","expr":{"node":"Identifier","val":"x"}}}`,
+	} {
+		if _, err := DecodeJSON([]byte(bad)); err == nil {
+			t.Errorf("expected %s to be malformed", bad)
+		}
+	}
+	for _, bad := range []Node{&Line{}, &Number{Type: BIGINT}, &Binary2{Op: MINUS, Lhs: &Identifier{Val: "x"}}} {
+		if _, err := DecodeBinary(EncodeBinary(bad)); err == nil {
+			t.Errorf("expected the binary encoding of %s to be malformed", shape(reflect.ValueOf(bad)))
+		}
+	}
+}
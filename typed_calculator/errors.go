@@ -0,0 +1,102 @@
+package typedcalculator
+
+import (
+	"fmt"
+	"lexer"
+)
+
+// SyntaxError is raised by the Parser when the program is malformed. Pos is
+// the span of the offending token. When a specific token was required the
+// accepted token types and the type that was found are recorded as well.
+type SyntaxError struct {
+	Pos      Span
+	Msg      string
+	Expected []string
+	Got      string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %s: %s", e.Pos.Start, e.Msg)
+}
+
+// Diagnostic renders the error against the program source it came from,
+// pointing a caret at the offending token.
+func (e *SyntaxError) Diagnostic(src string) string {
+	return lexer.RenderDiagnostic(src, e.Pos, "syntax error: "+e.Msg)
+}
+
+// RuntimeError stops the evaluation of a program, Pos is the span of the
+// statement or expression that could not be evaluated.
+type RuntimeError struct {
+	Pos Span
+	Msg string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("runtime error at %s: %s", e.Pos.Start, e.Msg)
+}
+
+// Diagnostic renders the error against the program source it came from
+func (e *RuntimeError) Diagnostic(src string) string {
+	return lexer.RenderDiagnostic(src, e.Pos, "runtime error: "+e.Msg)
+}
+
+// TypeError is reported by the TypeChecker, Pos is the span of the
+// statement or expression whose types do not match.
+type TypeError struct {
+	Pos Span
+	Msg string
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("type error at %s: %s", e.Pos.Start, e.Msg)
+}
+
+// Diagnostic renders the error against the program source it came from
+func (e *TypeError) Diagnostic(src string) string {
+	return lexer.RenderDiagnostic(src, e.Pos, "type error: "+e.Msg)
+}
+
+// TypeErrorList is returned by TypeChecker.Run, it holds every type error
+// found in the program in source order.
+type TypeErrorList []*TypeError
+
+func (l TypeErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Err returns nil when the list is empty and the list otherwise
+func (l TypeErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// ErrorList is returned by Parser.Parse, it holds every syntax error found
+// in the program in source order.
+type ErrorList []*SyntaxError
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Err returns nil when the list is empty and the list otherwise
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
@@ -2,7 +2,6 @@ package typedcalculator
 
 import (
 	"fmt"
-	"math"
 	//"reflect"
 )
 
@@ -10,10 +9,12 @@ func CreateEvaluator(p bool) *Eval {
 	parser := BuildParser()
 
 	return &Eval{
-		parser:    parser,
-		pr:        p,
-		env:       make(map[string]Number),
-		PrintVals: make([]Number, 0),
+		parser:        parser,
+		pr:            p,
+		optimize:      true,
+		DecimalPlaces: DefaultDecimalPlaces,
+		vars:          newScopes(),
+		PrintVals:     make([]Number, 0),
 	}
 }
 
@@ -21,15 +22,75 @@ type Eval struct {
 	parser    *Parser
 	current   int
 	res       Number
-	env       map[string]Number
+	vars      scopes   //the program's variables and those of the blocks being run
+	returning bool     //set by a return statement until the call completes
 	pr        bool     //whether to print the value
+	optimize  bool     //whether to run Optimize on checked programs
 	PrintVals []Number //values that are printed used for testing
+
+	//DecimalPlaces is the number of digits after the point that a decimal
+	//division is rounded to, see DefaultDecimalPlaces
+	DecimalPlaces int
+}
+
+// Run parses, type checks, optimizes and evaluates program. Nothing is
+// evaluated when the program has syntax errors or type errors, they are
+// returned as an ErrorList or a TypeErrorList. Evaluation stops at the first
+// *RuntimeError, the lines before it keep their effects.
+func (e *Eval) Run(program string) error {
+	node, err := e.parser.Parse(program)
+	if err != nil {
+		return err
+	}
+	return e.RunNode(node)
 }
 
-func (e *Eval) Run(program string) {
-	node := e.parser.Parse(program)
+// RunNode type checks, optimizes and evaluates the program rooted at node
+// like Run, e.g. one that DecodeJSON or DecodeBinary read back. It is
+// checked again against the variables of e, which may differ from those it
+// was checked with before.
+func (e *Eval) RunNode(node Node) (err error) {
+	if err := checkerFor(e.vars[0]).Run(node); err != nil {
+		return err
+	}
+	if e.optimize {
+		node = (&optimizer{places: e.DecimalPlaces}).optimize(node)
+	}
+	//a runtime error leaves the blocks and calls it occurred in
+	e.vars = e.vars[:1]
+	e.returning = false
+	defer func() {
+		if r := recover(); r != nil {
+			rerr, ok := r.(*RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = rerr
+		}
+	}()
 	//printValue("", reflect.ValueOf(node), make(map[interface{}]bool))
 	node.accept(e)
+	return nil
+}
+
+// Globals returns the variables the programs run so far have defined
+func (e *Eval) Globals() map[string]Number {
+	return e.vars[0].vars
+}
+
+// changeTo changes n to the type t the checker chose, a NaN or an infinity
+// cannot become a decimal
+func changeTo(pos Span, n Number, t Type) Number {
+	res := changeType(n, t)
+	if t == DECIMAL && res.Dec == nil {
+		fail(pos, fmt.Errorf("%s does not fit in a decimal", n))
+	}
+	return res
+}
+
+// fail stops the evaluation with a *RuntimeError at pos
+func fail(pos Span, err error) {
+	panic(&RuntimeError{Pos: pos, Msg: err.Error()})
 }
 
 func (e *Eval) visitProgramStmt(f *Program) {
@@ -38,14 +99,81 @@ func (e *Eval) visitProgramStmt(f *Program) {
 	}
 }
 
+// visitFuncStmt declares the function in the innermost scope, it keeps the
+// scopes it is declared in
+func (e *Eval) visitFuncStmt(f *Func) {
+	env := append(scopes(nil), e.vars...)
+	e.vars.innermost().funcs[f.Name] = &function{decl: f, env: env}
+}
+
+func (e *Eval) visitReturnStmt(f *Return) {
+	f.Expr.accept(e)
+	e.returning = true
+}
+
+// visitCallStmt evaluates the arguments where the function is called and
+// runs the body in the scopes the function was declared in, with a scope
+// of its own for the parameters
+func (e *Eval) visitCallStmt(f *Call) {
+	fn := e.vars.lookupFunc(f.Name)
+	if fn == nil {
+		fail(f.Pos, fmt.Errorf("unknown function %s", f.Name))
+	}
+	d := fn.decl
+	args := newScope()
+	for i, a := range f.Args {
+		a.accept(e)
+		args.vars[d.Params[i]] = changeTo(a.position(), e.res, d.ParamTypes[i])
+	}
+
+	caller := e.vars
+	e.vars = append(append(scopes(nil), fn.env...), args)
+	d.Body.accept(e)
+	e.returning = false
+	e.vars = caller
+	e.res = changeTo(f.Pos, e.res, d.Result)
+}
+
 func (e *Eval) visitLineStmt(f *Line) {
 	f.Stmt.accept(e)
 }
 
 func (e *Eval) visitAssignmentStmt(f *Assignment) {
+	if e.vars.innermost().consts[f.Identifier] {
+		fail(f.Pos, fmt.Errorf("cannot assign to const %s", f.Identifier))
+	}
 	f.Expr.accept(e)
-	e.res.Fixed = true
-	e.env[f.Identifier] = e.res
+	e.res = changeTo(f.Pos, e.res, f.Type)
+	e.vars.declare(f.Identifier, e.res, f.Const)
+}
+
+// visitReassignStmt stores the new value with the type the variable was
+// declared with. The result of a compound assignment is narrowed to that
+// type, which fails when it is not exactly representable.
+func (e *Eval) visitReassignStmt(f *Reassign) {
+	//a reset in a loop or function may have removed the variable since the
+	//checker saw it declared
+	s := e.vars.lookup(f.Identifier)
+	if s == nil {
+		fail(f.Pos, fmt.Errorf("unknown identifier %s", f.Identifier))
+	}
+	if s.consts[f.Identifier] {
+		fail(f.Pos, fmt.Errorf("cannot assign to const %s", f.Identifier))
+	}
+	old := s.vars[f.Identifier]
+	f.Expr.accept(e)
+	if f.Op != NOOP {
+		res, err := applyOp(f.Op, changeTo(f.Pos, old, f.Type), changeTo(f.Pos, e.res, f.Type), e.DecimalPlaces)
+		if err == nil && res.Type != old.Type {
+			res, err = exactly(res, old.Type)
+		}
+		if err != nil {
+			fail(f.Pos, err)
+		}
+		e.res = res
+	}
+	e.res = changeTo(f.Pos, e.res, old.Type)
+	s.vars[f.Identifier] = e.res
 }
 
 func (e *Eval) visitPrintStmt(f *Print) {
@@ -57,242 +185,145 @@ func (e *Eval) visitPrintStmt(f *Print) {
 	}
 }
 
-func (e *Eval) visitResetStmt(f *Reset) {}
-func (e *Eval) visitBinary2Stmt(f *Binary2) {
-	f.Lhs.accept(e)
-	lhs := e.res
+// Reset clears the variables, the last result and the printed values, so
+// the evaluator can run another program as if it were new
+func (e *Eval) Reset() {
+	e.vars.clear()
+	e.res = Number{}
+	e.PrintVals = make([]Number, 0)
+}
 
-	var rhs Number
-	if f.Op != NOOP {
-		f.Rhs.accept(e)
-		rhs = e.res
+func (e *Eval) visitResetStmt(f *Reset) {
+	if f.Identifier == "" {
+		e.Reset()
+		return
 	}
+	e.vars.unbind(f.Identifier)
+}
 
-	rt := inferType(lhs, rhs)
-
-	//change the types of the lhs and the rhs to the inferred type
-	lhs = changeType(lhs, rt)
-	rhs = changeType(rhs, rt)
-
-	switch o := f.Op; o {
-	case PLUS:
-		{
-			e.res = AddNums(lhs, rhs)
-		}
-	case MINUS:
-		{
-			e.res = SubNums(lhs, rhs)
-		}
-	case MULTIPLY:
-		{
-			e.res = MultiplyNums(lhs, rhs)
-		}
-	case DIVIDE:
-		{
-			e.res = DivideNums(lhs, rhs)
-		}
-	case POWER:
-		{
-			e.res = PowerNums(lhs, rhs)
-		}
-	case NOOP:
-		{
-			break
+// visitBlockStmt runs the lines of a block in a scope of their own
+func (e *Eval) visitBlockStmt(f *Block) {
+	e.vars.push()
+	defer e.vars.pop()
+	for _, l := range f.Lines {
+		l.accept(e)
+		if e.returning {
+			return
 		}
 	}
-
-	e.res.Type = rt
-
 }
 
-func (e *Eval) visitIdentifierStmt(f *Identifier) {
-	if v, ok := e.env[f.Val]; !ok {
-		panic(fmt.Sprintf("unknown identifier %s", f.Val))
-	} else {
-		e.res = v
-	}
+// scoped runs a branch or a loop body in a scope of its own
+func (e *Eval) scoped(n Node) {
+	e.vars.push()
+	defer e.vars.pop()
+	n.accept(e)
 }
 
-func (e *Eval) visitNumberStmt(f *Number) {
-	e.res = *f
+// condition evaluates the condition of an if or a while
+func (e *Eval) condition(n Node) bool {
+	n.accept(e)
+	return e.res.Bool
 }
 
-//at this point both Numbers should have the same type
-func AddNums(lhs Number, rhs Number) Number {
-	if lhs.Type != rhs.Type {
-		panic(fmt.Sprintf("lhs type %s is not equal to rhs type %s", TypeStringMap[lhs.Type], TypeStringMap[rhs.Type]))
-	}
-	if lhs.Type == INT {
-		return Number{
-			Type: lhs.Type,
-			Num:  lhs.Num + rhs.Num,
-		}
-	}
-
-	if lhs.Type == FLOAT {
-		return Number{
-			Type: lhs.Type,
-			Flt:  lhs.Flt + rhs.Flt,
-		}
+func (e *Eval) visitIfStmt(f *If) {
+	if e.condition(f.Cond) {
+		e.scoped(f.Then)
+	} else if f.Else != nil {
+		e.scoped(f.Else)
 	}
-	panic(fmt.Sprintf("Unknown type %s", TypeStringMap[lhs.Type]))
-	return Number{}
 }
 
-func SubNums(lhs Number, rhs Number) Number {
-	if lhs.Type != rhs.Type {
-		panic(fmt.Sprintf("lhs type %s is not equal to rhs type %s", TypeStringMap[lhs.Type], TypeStringMap[rhs.Type]))
-	}
-	if lhs.Type == INT {
-		return Number{
-			Type: lhs.Type,
-			Num:  lhs.Num - rhs.Num,
-		}
-	}
-
-	if lhs.Type == FLOAT {
-		return Number{
-			Type: lhs.Type,
-			Flt:  lhs.Flt - rhs.Flt,
-		}
+func (e *Eval) visitWhileStmt(f *While) {
+	for !e.returning && e.condition(f.Cond) {
+		e.scoped(f.Body)
 	}
-	panic(fmt.Sprintf("Unknown type %s", TypeStringMap[lhs.Type]))
-	return Number{}
 }
 
-func MultiplyNums(lhs Number, rhs Number) Number {
-	if lhs.Type != rhs.Type {
-		panic(fmt.Sprintf("lhs type %s is not equal to rhs type %s", TypeStringMap[lhs.Type], TypeStringMap[rhs.Type]))
-	}
-	if lhs.Type == INT {
-		return Number{
-			Type: lhs.Type,
-			Num:  lhs.Num * rhs.Num,
-		}
-	}
+func (e *Eval) visitBinary2Stmt(f *Binary2) {
+	f.Lhs.accept(e)
+	lhs := e.res
 
-	if lhs.Type == FLOAT {
-		return Number{
-			Type: lhs.Type,
-			Flt:  lhs.Flt * rhs.Flt,
+	switch f.Op {
+	case NOOP:
+		return
+	case AND, OR:
+		//the rhs is only evaluated when it decides the result
+		if lhs.Bool == (f.Op == OR) {
+			return
 		}
+		f.Rhs.accept(e)
+		return
 	}
-	panic(fmt.Sprintf("Unknown type %d", lhs.Type))
-	return Number{}
-}
+	f.Rhs.accept(e)
+	rhs := e.res
 
-func DivideNums(lhs Number, rhs Number) Number {
-	if lhs.Type != rhs.Type {
-		panic(fmt.Sprintf("lhs type %d is not equal to rhs type %d", lhs.Type, rhs.Type))
-	}
-	if lhs.Type == INT {
-		return Number{
-			Type: lhs.Type,
-			Num:  lhs.Num / rhs.Num,
-		}
+	//change the types of the lhs and the rhs to the checked type
+	res, err := applyOp(f.Op, changeTo(f.Lhs.position(), lhs, f.Type), changeTo(f.Rhs.position(), rhs, f.Type), e.DecimalPlaces)
+	if err != nil {
+		fail(f.Pos, err)
 	}
+	e.res = res
+}
 
-	if lhs.Type == FLOAT {
-		return Number{
-			Type: lhs.Type,
-			Flt:  lhs.Flt / rhs.Flt,
-		}
+// applyOp applies a binary operator to two numbers of the same type, a
+// decimal division is rounded to places digits after the point
+func applyOp(op Op, lhs Number, rhs Number, places int) (Number, error) {
+	switch op {
+	case PLUS:
+		return AddNums(lhs, rhs)
+	case MINUS:
+		return SubNums(lhs, rhs)
+	case MULTIPLY:
+		return MultiplyNums(lhs, rhs)
+	case DIVIDE:
+		return divideNums(lhs, rhs, places)
+	case POWER:
+		return powerNums(lhs, rhs, places)
+	case EQ, NEQ, LT, GT, LTE, GTE:
+		return CompareNums(op, lhs, rhs)
 	}
-	panic(fmt.Sprintf("Unknown type %d", lhs.Type))
-	return Number{}
+	return Number{}, fmt.Errorf("unknown operator %s", OpStringMap[op])
 }
 
-func PowerNums(lhs Number, rhs Number) Number {
-	if lhs.Type != rhs.Type {
-		panic(fmt.Sprintf("lhs type %d is not equal to rhs type %d", lhs.Type, rhs.Type))
+func (e *Eval) visitUnaryStmt(f *Unary) {
+	f.Expr.accept(e)
+	if f.Op == NOT {
+		e.res = Number{Type: BOOL, Bool: !e.res.Bool}
+		return
 	}
-	if lhs.Type == INT {
-		return Number{
-			Type: lhs.Type,
-			Num:  intPow(lhs.Num, rhs.Num),
-		}
+	if f.Op != MINUS {
+		return
 	}
-
-	if lhs.Type == FLOAT {
-		return Number{
-			Type: lhs.Type,
-			Flt:  math.Pow(lhs.Flt, rhs.Flt),
-		}
+	res, err := NegateNum(e.res)
+	if err != nil {
+		fail(f.Pos, err)
 	}
-	panic(fmt.Sprintf("Unknown type %d", lhs.Type))
-	return Number{}
-}
-
-func intPow(x, y int) int {
-	return int(math.Pow(float64(x), float64(y)))
+	e.res = res
 }
 
-// TYPE CHECKING
-
-func inferType(n1 Number, n2 Number) Type {
+func (e *Eval) visitCastStmt(f *Cast) {
+	f.Expr.accept(e)
 	var err error
-	var t Type
-	//TODO should we allow a type to change if it fixed
-	if (n1.Fixed && n2.Fixed) && (n1.Type != n2.Type) {
-		panic(fmt.Sprintf("Unmatched types %s and %s", TypeStringMap[n1.Type], TypeStringMap[n2.Type]))
-	} else if n1.Fixed && n2.Fixed {
-		return n1.Type
-	} else if n1.Fixed {
-		t, err = convertible(n2, n1.Type)
-		if err != nil {
-			panic(err)
-		}
-		return t
-	} else if n2.Fixed {
-		t, err = convertible(n1, n2.Type)
-		if err != nil {
-			panic(err)
-		}
-		return t
+	if f.Exact {
+		e.res, err = exactly(e.res, f.Type)
+	} else {
+		e.res, err = convertNum(e.res, f.Type)
 	}
-	return maxType(n1.Type, n2.Type)
-}
-
-func convertible(n Number, t Type) (Type, error) {
-	if n.Type <= t {
-		return t, nil
+	if err != nil {
+		fail(f.Pos, err)
 	}
-
-	//TODO a float can be converted to a int if it has no decimal part eg 3.0 -> 3
-
-	return NOTYPE, fmt.Errorf("cannot change %d to %d", t, n.Type)
 }
 
-func maxType(t1 Type, t2 Type) Type {
-	if t1 >= t2 {
-		return t1
+func (e *Eval) visitIdentifierStmt(f *Identifier) {
+	//a variable a function uses may have been reset since it was declared
+	s := e.vars.lookup(f.Val)
+	if s == nil {
+		fail(f.Pos, fmt.Errorf("unknown identifier %s", f.Val))
 	}
-	return t2
+	e.res = s.vars[f.Val]
 }
 
-func changeType(n1 Number, t Type) Number {
-	if n1.Type == t {
-		return n1
-	}
-
-	switch t {
-	case INT:
-		{
-			return Number{
-				Type: INT,
-				Num:  int(n1.Flt),
-			}
-		}
-	case FLOAT:
-		{
-			return Number{
-				Type: FLOAT,
-				Flt:  float64(n1.Num),
-			}
-		}
-
-	}
-
-	panic(fmt.Sprintf("Unknown type %s", TypeStringMap[t]))
-	return Number{}
+func (e *Eval) visitNumberStmt(f *Number) {
+	e.res = *f
 }
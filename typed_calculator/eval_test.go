@@ -1,16 +1,25 @@
 package typedcalculator
 
 import (
+	"io/ioutil"
 	"math"
+	"math/big"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 const float64EqualityThreshold = 1e-9
+const float32EqualityThreshold = 1e-6
 
 func almostEqual(a, b float64) bool {
 	return math.Abs(a-b) <= float64EqualityThreshold
 }
 
+func almostEqual32(a, b float32) bool {
+	return math.Abs(float64(a-b)) <= float32EqualityThreshold*math.Max(1, math.Abs(float64(a)))
+}
+
 func NumberSliceEqual(s1 []Number, s2 []Number) bool {
 	if len(s1) != len(s2) {
 		return false
@@ -23,16 +32,30 @@ func NumberSliceEqual(s1 []Number, s2 []Number) bool {
 		if s1[i].Type == INT && s1[i].Num != s2[i].Num {
 			return false
 		}
-		if s1[i].Type == FLOAT && !almostEqual(s1[i].Flt, s2[i].Flt) {
+		if s1[i].Type == LONG && s1[i].Long != s2[i].Long {
+			return false
+		}
+		if s1[i].Type == FLOAT && !almostEqual32(s1[i].Flt, s2[i].Flt) {
+			return false
+		}
+		if s1[i].Type == DOUBLE && !almostEqual(s1[i].Dbl, s2[i].Dbl) {
+			return false
+		}
+		if s1[i].Type == BOOL && s1[i].Bool != s2[i].Bool {
+			return false
+		}
+		if s1[i].Type == BIGINT && s1[i].Big.Cmp(s2[i].Big) != 0 {
+			return false
+		}
+		if s1[i].Type == DECIMAL && s1[i].Dec.Cmp(s2[i].Dec) != 0 {
 			return false
 		}
 	}
 	return true
 }
 
-//TODO when reset is implemented the tests can be refactored so we dont
-//create a new evaluator for every test.
 func TestEval(t *testing.T) {
+	iptr := CreateEvaluator(false)
 
 	intTable := map[string][]Number{
 		"int val = 23; print val+2": []Number{Number{Type: INT, Num: 25}},
@@ -41,10 +64,23 @@ func TestEval(t *testing.T) {
 		"print 2**3+5":              []Number{Number{Type: INT, Num: 13}},
 		"print 2**3*2+43-21":        []Number{Number{Type: INT, Num: 38}},
 		"print 2**3**2":             []Number{Number{Type: INT, Num: 512}},
+		"int offset = 4; int integer = 3; print offset*integer": []Number{Number{Type: INT, Num: 12}},
+		"int reset_count = 2; print reset_count":                []Number{Number{Type: INT, Num: 2}},
+		"print (2+3)*4":                                         []Number{Number{Type: INT, Num: 20}},
+		"print 2*(3+4)**2":                                      []Number{Number{Type: INT, Num: 98}},
+		"print ((1))":                                           []Number{Number{Type: INT, Num: 1}},
+		"print -2**2":                                           []Number{Number{Type: INT, Num: -4}},
+		"print (-2)**2":                                         []Number{Number{Type: INT, Num: 4}},
+		"print 2**-1*4":                                         []Number{Number{Type: INT, Num: 0}},
+		"print 2*-3":                                            []Number{Number{Type: INT, Num: -6}},
+		"print --3":                                             []Number{Number{Type: INT, Num: 3}},
+		"print -(2+3)*2":                                        []Number{Number{Type: INT, Num: -10}},
+		"int a = 5; print -a+1":                                 []Number{Number{Type: INT, Num: -4}},
+		"print 10-(4-3)":                                        []Number{Number{Type: INT, Num: 9}},
 	}
 
 	for pr, res := range intTable {
-		iptr := CreateEvaluator(false)
+		iptr.Reset()
 		iptr.Run(pr)
 		if !NumberSliceEqual(iptr.PrintVals, res) {
 			t.Errorf("expected %+v got %+v", res, iptr.PrintVals)
@@ -56,7 +92,7 @@ func TestEval(t *testing.T) {
 	}
 
 	for pr, res := range floatTable {
-		iptr := CreateEvaluator(false)
+		iptr.Reset()
 		iptr.Run(pr)
 		if !NumberSliceEqual(iptr.PrintVals, res) {
 			t.Errorf("expected %+v got %+v", res, iptr.PrintVals)
@@ -64,3 +100,719 @@ func TestEval(t *testing.T) {
 	}
 
 }
+
+func TestNumericTower(t *testing.T) {
+	iptr := CreateEvaluator(false)
+
+	towerTable := map[string][]Number{
+		"print -1.5*2.0":                             []Number{Number{Type: DOUBLE, Dbl: -3}},
+		"print (1.5+0.5)**2.0":                       []Number{Number{Type: DOUBLE, Dbl: 4}},
+		"print 2.0**-1.0":                            []Number{Number{Type: DOUBLE, Dbl: 0.5}},
+		"print 3000000000":                           []Number{Number{Type: LONG, Long: 3000000000}},
+		"long l = 2; print l**40":                    []Number{Number{Type: LONG, Long: 1 << 40}},
+		"long l = 7; int i = 2; print l*i":           []Number{Number{Type: LONG, Long: 14}},
+		"int i = 7; print i/2":                       []Number{Number{Type: INT, Num: 3}},
+		"float f = 0.1; print f":                     []Number{Number{Type: FLOAT, Flt: 0.1}},
+		"float f = 1.5; int i = 2; print f*i":        []Number{Number{Type: FLOAT, Flt: 3}},
+		"double d = 0.1; float f = 0.1; print d":     []Number{Number{Type: DOUBLE, Dbl: 0.1}},
+		"double d = 1.0; float f = 0.5; print d+f":   []Number{Number{Type: DOUBLE, Dbl: 1.5}},
+		"double d = 1.0; long l = 3; print d/l":      []Number{Number{Type: DOUBLE, Dbl: 1.0 / 3}},
+		"long l = 9223372036854775807; print l":      []Number{Number{Type: LONG, Long: math.MaxInt64}},
+		"int i = 2147483647; print i":                []Number{Number{Type: INT, Num: math.MaxInt32}},
+		"long l = -2147483648; int i = 1; print l-i": []Number{Number{Type: LONG, Long: math.MinInt32 - 1}},
+		//an expression of literals takes the type its value needs
+		"print 2**62":             []Number{Number{Type: LONG, Long: 1 << 62}},
+		"long l = 2**62; print l": []Number{Number{Type: LONG, Long: 1 << 62}},
+		"print 65536*65536*1":     []Number{Number{Type: LONG, Long: 1 << 32}},
+		"print -(2**31) + 1":      []Number{Number{Type: LONG, Long: math.MinInt32 + 1}},
+		"print 2**31 / 2":         []Number{Number{Type: LONG, Long: 1 << 30}},
+	}
+
+	for pr, res := range towerTable {
+		iptr.Reset()
+		if err := iptr.Run(pr); err != nil {
+			t.Errorf("could not run %q: %v", pr, err)
+			continue
+		}
+		if !NumberSliceEqual(iptr.PrintVals, res) || iptr.PrintVals[0].Type != res[0].Type {
+			t.Errorf("expected %q to print %+v got %+v", pr, res, iptr.PrintVals)
+		}
+	}
+
+	errTable := map[string]string{
+		"int i = 2147483647; print i+1":            "int overflow",
+		"int i = 65536; print i*65536":             "int overflow",
+		"int i = 2; print i**31":                   "int overflow",
+		"long l = 9223372036854775807; print l+1":  "long overflow",
+		"long l = 3037000500; print l*l":           "long overflow",
+		"long l = -9223372036854775807; print l-2": "long overflow",
+		"int i = 1; print i/0":                     "divide by zero",
+	}
+
+	for pr, msg := range errTable {
+		iptr.Reset()
+		err := iptr.Run(pr)
+		rerr, ok := err.(*RuntimeError)
+		if !ok || !strings.HasPrefix(rerr.Msg, msg) {
+			t.Errorf("expected %q to fail with %q got %v", pr, msg, err)
+		}
+	}
+
+	iptr.Reset()
+	if _, ok := iptr.Run("int i = 2**40").(TypeErrorList); !ok {
+		t.Errorf("expected a literal expression that does not fit in an int to be a type error")
+	}
+
+	iptr.Reset()
+	if err := iptr.Run("print 9223372036854775808"); err != nil || iptr.PrintVals[0].Type != BIGINT {
+		t.Errorf("expected a literal that does not fit in a long to be a bigint got %+v, %v", iptr.PrintVals, err)
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	src := `int i = 3000000000
+double d = 1.5; int j = 2.5
+print nope + 1
+long l = 2; print i + l * j
+reset x; reset; print l`
+
+	node, err := BuildParser().Parse(src)
+	if err != nil {
+		t.Fatalf("could not parse: %v", err)
+	}
+	err = NewTypeChecker(nil, nil).Run(node)
+	errs, ok := err.(TypeErrorList)
+	if !ok {
+		t.Fatalf("expected a TypeErrorList got %v", err)
+	}
+
+	want := []struct {
+		pos Position
+		msg string
+	}{
+		{Position{Offset: 0, Line: 1, Col: 1}, "cannot assign to int i: 3000000000 is not exactly representable as an int"},
+		{Position{Offset: 35, Line: 2, Col: 17}, "cannot assign to int j: 2.5 is not exactly representable as an int"},
+		{Position{Offset: 53, Line: 3, Col: 7}, "unknown identifier nope"},
+		{Position{Offset: 90, Line: 5, Col: 1}, "unknown identifier x"},
+		{Position{Offset: 112, Line: 5, Col: 23}, "unknown identifier l"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors got %d: %v", len(want), len(errs), errs)
+	}
+	for i, e := range errs {
+		if e.Pos.Start != want[i].pos || e.Msg != want[i].msg {
+			t.Errorf("expected error %d to be %q at %s got %v", i, want[i].msg, want[i].pos, e)
+		}
+	}
+
+	//the checker annotates every operator with the type it works in
+	node, _ = BuildParser().Parse("int i = 1; long l = 2; float f = 0.5; print i + l * f; print i * 2; print -(f * 2.0)")
+	if err := NewTypeChecker(nil, nil).Run(node); err != nil {
+		t.Fatalf("could not type check: %v", err)
+	}
+	prog := node.(*Program)
+	types := []struct {
+		node Node
+		ty   Type
+	}{
+		{prog.Lines[3].Stmt.(*Print).Expr, FLOAT},
+		{prog.Lines[3].Stmt.(*Print).Expr.(*Binary2).Rhs, FLOAT},
+		{prog.Lines[4].Stmt.(*Print).Expr, INT},
+		{prog.Lines[5].Stmt.(*Print).Expr, FLOAT},
+	}
+	for i, tt := range types {
+		var got Type
+		switch n := tt.node.(type) {
+		case *Binary2:
+			got = n.Type
+		case *Unary:
+			got = n.Type
+		}
+		if got != tt.ty {
+			t.Errorf("expected expression %d to be a %s got %s", i, TypeStringMap[tt.ty], TypeStringMap[got])
+		}
+	}
+
+	//nothing runs when a program does not type check
+	iptr := CreateEvaluator(false)
+	if _, ok := iptr.Run("print 1; print nope").(TypeErrorList); !ok || len(iptr.PrintVals) != 0 {
+		t.Errorf("expected a type error before anything is printed got %+v", iptr.PrintVals)
+	}
+	//variables of earlier programs are known to the checker
+	iptr.Run("long l = 5")
+	if err := iptr.Run("print l * 2"); err != nil || !NumberSliceEqual(iptr.PrintVals, []Number{Number{Type: LONG, Long: 10}}) {
+		t.Errorf("expected l * 2 to print a long 10 got %+v, %v", iptr.PrintVals, err)
+	}
+}
+
+func TestCast(t *testing.T) {
+	iptr := CreateEvaluator(false)
+
+	castTable := map[string][]Number{
+		"print int(3.9)":                         []Number{Number{Type: INT, Num: 3}},
+		"print int(-3.9)":                        []Number{Number{Type: INT, Num: -3}},
+		"print long(2) * 3":                      []Number{Number{Type: LONG, Long: 6}},
+		"print float(1) / 4":                     []Number{Number{Type: FLOAT, Flt: 0.25}},
+		"print double(1) / 3":                    []Number{Number{Type: DOUBLE, Dbl: 1.0 / 3}},
+		"double d = 0.1; print float(d)":         []Number{Number{Type: FLOAT, Flt: 0.1}},
+		"float f = 2.5; print int(f * 2.0)":      []Number{Number{Type: INT, Num: 5}},
+		"long l = 5; print int(l) + 1":           []Number{Number{Type: INT, Num: 6}},
+		"print float(16777217)":                  []Number{Number{Type: FLOAT, Flt: 16777216}},
+		"int x = 3.0; print x":                   []Number{Number{Type: INT, Num: 3}},
+		"double d = 7.0; int x = d; print x":     []Number{Number{Type: INT, Num: 7}},
+		"long l = 8; int x = l * 2; print x":     []Number{Number{Type: INT, Num: 16}},
+		"double d = 1.5; float f = d; print f":   []Number{Number{Type: FLOAT, Flt: 1.5}},
+		"long l = 3000000000.0; print l":         []Number{Number{Type: LONG, Long: 3000000000}},
+		"float f = 1.5; int x = int(f); print x": []Number{Number{Type: INT, Num: 1}},
+	}
+
+	for pr, res := range castTable {
+		iptr.Reset()
+		if err := iptr.Run(pr); err != nil {
+			t.Errorf("could not run %q: %v", pr, err)
+			continue
+		}
+		if !NumberSliceEqual(iptr.PrintVals, res) {
+			t.Errorf("expected %q to print %+v got %+v", pr, res, iptr.PrintVals)
+		}
+	}
+
+	errTable := map[string]string{
+		"print int(3000000000.0)":            "3e+09 does not fit in an int",
+		"print long(10000000000000000000.0)": "1e+19 does not fit in a long",
+		"long l = 3000000000; print int(l)":  "3000000000 does not fit in an int",
+		"double d = 1.5; int x = d":          "1.5 is not exactly representable as an int",
+		"double d = 0.1; float f = d":        "0.1 is not exactly representable as a float",
+		"long l = 3000000000; int x = l":     "3000000000 is not exactly representable as an int",
+	}
+
+	for pr, msg := range errTable {
+		iptr.Reset()
+		err := iptr.Run(pr)
+		rerr, ok := err.(*RuntimeError)
+		if !ok || rerr.Msg != msg {
+			t.Errorf("expected %q to fail with %q got %v", pr, msg, err)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	iptr := CreateEvaluator(false)
+
+	resetTable := map[string][]Number{
+		"int a = 1; print a; reset; int b = 2; print b":         []Number{Number{Type: INT, Num: 2}},
+		"int a = 1; reset a; int a = 3; print a":                []Number{Number{Type: INT, Num: 3}},
+		"int a = 1; int b = 2; reset a; print b":                []Number{Number{Type: INT, Num: 2}},
+		"float f = 1.5\nprint f\nreset\nfloat f = 2.5\nprint f": []Number{Number{Type: FLOAT, Flt: 2.5}},
+	}
+
+	for pr, res := range resetTable {
+		iptr.Run("reset")
+		if err := iptr.Run(pr); err != nil {
+			t.Errorf("could not run %q: %v", pr, err)
+			continue
+		}
+		if !NumberSliceEqual(iptr.PrintVals, res) {
+			t.Errorf("expected %q to print %+v got %+v", pr, res, iptr.PrintVals)
+		}
+	}
+
+	iptr.Run("int a = 1; int b = 2; reset a")
+	if _, ok := iptr.Globals()["a"]; ok {
+		t.Errorf("expected reset a to unbind a")
+	}
+	if _, ok := iptr.Globals()["b"]; !ok {
+		t.Errorf("expected reset a to keep b")
+	}
+
+	//the checker still sees a variable a loop or function has reset
+	for _, pr := range []string{
+		"int x = 1; int i = 0; while i < 2 { x = 2; reset x; i += 1 }",
+		"int x = 1; func f() int { x += 2; reset x; return 0 }; print f(); print f()",
+	} {
+		iptr.Run("reset")
+		err, ok := iptr.Run(pr).(*RuntimeError)
+		if !ok || err.Msg != "unknown identifier x" {
+			t.Errorf("expected %q to fail with unknown identifier x got %v", pr, err)
+		}
+	}
+
+	iptr.Reset()
+	if len(iptr.Globals()) != 0 || len(iptr.PrintVals) != 0 || iptr.res != (Number{}) {
+		t.Errorf("expected Reset to clear the evaluator got %+v", iptr)
+	}
+}
+
+func TestReassign(t *testing.T) {
+	iptr := CreateEvaluator(false)
+
+	reassignTable := map[string][]Number{
+		"int a = 1; a = 2; print a":                   []Number{Number{Type: INT, Num: 2}},
+		"float f = 1.5; f = 2; print f":               []Number{Number{Type: FLOAT, Flt: 2}},
+		"int a = 1; a = 3.0; print a":                 []Number{Number{Type: INT, Num: 3}},
+		"int a = 2; a += 3; a *= 4; print a":          []Number{Number{Type: INT, Num: 20}},
+		"int a = 7; a -= 2; a /= 2; print a":          []Number{Number{Type: INT, Num: 2}},
+		"int a = 3; a *= 1.5 * 2; print a":            []Number{Number{Type: INT, Num: 9}},
+		"long l = 1; l += 3000000000; print l":        []Number{Number{Type: LONG, Long: 3000000001}},
+		"double d = 1; d /= 4; print d":               []Number{Number{Type: DOUBLE, Dbl: 0.25}},
+		"const int c = 2; int a = c; a += c; print a": []Number{Number{Type: INT, Num: 4}},
+	}
+
+	for pr, res := range reassignTable {
+		iptr.Reset()
+		if err := iptr.Run(pr); err != nil {
+			t.Errorf("could not run %q: %v", pr, err)
+			continue
+		}
+		if !NumberSliceEqual(iptr.PrintVals, res) {
+			t.Errorf("expected %q to print %+v got %+v", pr, res, iptr.PrintVals)
+		}
+	}
+
+	typeErrTable := map[string]string{
+		"a = 1":                           "unknown identifier a",
+		"const int c = 1; c = 2":          "cannot assign to const c",
+		"const int c = 1; c += 2":         "cannot assign to const c",
+		"const int c = 1; int c = 2":      "cannot assign to const c",
+		"int a = 1; a = 1.5":              "cannot assign to int a: 1.5 is not exactly representable as an int",
+		"const int c = 1; reset c; c = 2": "unknown identifier c",
+	}
+
+	for pr, msg := range typeErrTable {
+		iptr.Reset()
+		err := iptr.Run(pr)
+		errs, ok := err.(TypeErrorList)
+		if !ok || len(errs) != 1 || errs[0].Msg != msg {
+			t.Errorf("expected %q to fail with %q got %v", pr, msg, err)
+		}
+	}
+
+	errTable := map[string]string{
+		"int a = 3; a *= 1.5":                "4.5 is not exactly representable as an int",
+		"int a = 2147483647; a += 1":         "int overflow, 2147483648 does not fit in 32 bits",
+		"double d = 0.1; float f = 1; f = d": "0.1 is not exactly representable as a float",
+	}
+
+	for pr, msg := range errTable {
+		iptr.Reset()
+		err := iptr.Run(pr)
+		rerr, ok := err.(*RuntimeError)
+		if !ok || rerr.Msg != msg {
+			t.Errorf("expected %q to fail with %q got %v", pr, msg, err)
+		}
+	}
+
+	//a const declared by an earlier program stays const
+	iptr.Reset()
+	if err := iptr.Run("const double pi = 3.14"); err != nil {
+		t.Fatalf("could not declare pi: %v", err)
+	}
+	if _, ok := iptr.Run("pi = 3").(TypeErrorList); !ok {
+		t.Errorf("expected pi to stay const")
+	}
+	if err := iptr.Run("reset pi; double pi = 3; pi += 1"); err != nil {
+		t.Errorf("expected reset pi to unbind the const got %v", err)
+	}
+}
+
+// bigNum and decNum parse the value of a BIGINT and of a DECIMAL
+func bigNum(s string) Number {
+	v, _ := new(big.Int).SetString(s, 10)
+	return Number{Type: BIGINT, Big: v}
+}
+
+func decNum(s string) Number {
+	v, _ := new(big.Rat).SetString(s)
+	return Number{Type: DECIMAL, Dec: v}
+}
+
+func TestBigNumbers(t *testing.T) {
+	iptr := CreateEvaluator(false)
+
+	bigTable := map[string][]Number{
+		"bigint b = 2; print b**100":                              []Number{bigNum("1267650600228229401496703205376")},
+		"bigint b = 2; print b**62":                               []Number{bigNum("4611686018427387904")},
+		"bigint b = 2**62; print b":                               []Number{bigNum("4611686018427387904")},
+		"bigint b = 2**64 - 1; print b":                           []Number{bigNum("18446744073709551615")},
+		"long l = 9223372036854775807; bigint b = l; print b + 1": []Number{bigNum("9223372036854775808")},
+		"print 100000000000000000000 * 3":                         []Number{bigNum("300000000000000000000")},
+		"bigint b = -7; print b / 2; print b / -2":                []Number{bigNum("-3"), bigNum("3")},
+		"bigint b = 2; print b ** -1; print -b < b":               []Number{bigNum("0"), Number{Type: BOOL, Bool: true}},
+		"decimal d = 0.1; print d + 0.2":                          []Number{decNum("0.3")},
+		"decimal d = 0.1; print d * 3 == 0.3":                     []Number{Number{Type: BOOL, Bool: true}},
+		"decimal price = 19.99; int qty = 3; print price * qty":   []Number{decNum("59.97")},
+		"decimal d = 1; print d / 3":                              []Number{decNum("0.3333333333333333333333333333")},
+		"decimal d = 2; print d / 3":                              []Number{decNum("0.6666666666666666666666666667")},
+		"decimal d = 1.5; print d ** 2; print d ** -1":            []Number{decNum("2.25"), decNum("0.6666666666666666666666666667")},
+		"print decimal(0.1) + decimal(0.2)":                       []Number{decNum("0.3")},
+		//an expression of literals that becomes a decimal is computed as one
+		"decimal d = 0.1 + 0.2; print d":                      []Number{decNum("0.3")},
+		"decimal d = 1.1 * 1; print d":                        []Number{decNum("1.1")},
+		"decimal d = 1.0 / 3; print d":                        []Number{decNum("0.3333333333333333333333333333")},
+		"decimal d = 1; d += 0.1 * 3; print d + (0.1 + 0.2)":  []Number{decNum("1.6")},
+		"decimal d = 1 / 2 + 0.5; print d":                    []Number{decNum("0.5")},
+		"print 0.1 + 0.2 == 0.3":                              []Number{Number{Type: BOOL, Bool: false}},
+		"decimal d = 2.75; print int(d); print int(-d)":       []Number{Number{Type: INT, Num: 2}, Number{Type: INT, Num: -2}},
+		"bigint b = 10; double x = 0.5; print b * x":          []Number{decNum("5")},
+		"bigint b = 10; print b + 0.5; print b < float(10.5)": []Number{decNum("10.5"), Number{Type: BOOL, Bool: true}},
+		"bigint b = 3; float f = b; print f":                  []Number{Number{Type: FLOAT, Flt: 3}},
+		"bigint b = 10; decimal d = 0.5; print b * d":         []Number{decNum("5")},
+		"long l = bigint(7); print l":                         []Number{Number{Type: LONG, Long: 7}},
+		"float f = 2.5; print decimal(f)":                     []Number{decNum("2.5")},
+	}
+
+	for pr, res := range bigTable {
+		iptr.Reset()
+		if err := iptr.Run(pr); err != nil {
+			t.Errorf("could not run %q: %v", pr, err)
+			continue
+		}
+		if !NumberSliceEqual(iptr.PrintVals, res) {
+			t.Errorf("expected %q to print %+v got %+v", pr, res, iptr.PrintVals)
+		}
+	}
+
+	errTable := map[string]string{
+		"bigint b = 10; bigint e = 10000000000; print b**e": "10**10000000000 is too large",
+		"bigint b = 0; print 1 / b":                         "divide by zero",
+		"decimal d = 0; print 1 / d":                        "divide by zero",
+		"decimal d = 2; print d ** 0.5":                     "a decimal can only be raised to an integer power, not 0.5",
+		"bigint b = 3000000000; int i = b":                  "3000000000 is not exactly representable as an int",
+		"bigint b = 16777217; float f = b":                  "16777217 is not exactly representable as a float",
+		"bigint b = 1; double x = 0.0; print b + 1.0 / x":   "+Inf does not fit in a decimal",
+		"decimal d = 0.1; double x = d":                     "0.1 is not exactly representable as a double",
+		"double z = 0.0; decimal d = 1; print d + 1.0 / z":  "+Inf does not fit in a decimal",
+		"print long(100000000000000000000)":                 "100000000000000000000 does not fit in a long",
+	}
+
+	for pr, msg := range errTable {
+		iptr.Reset()
+		err := iptr.Run(pr)
+		rerr, ok := err.(*RuntimeError)
+		if !ok || rerr.Msg != msg {
+			t.Errorf("expected %q to fail with %q got %v", pr, msg, err)
+		}
+	}
+
+	if _, ok := iptr.Run("long l = 100000000000000000000").(TypeErrorList); !ok {
+		t.Errorf("expected a bigint literal that does not fit in a long to be a type error")
+	}
+
+	//every digit of a decimal is printed
+	for _, tt := range []struct {
+		num  Number
+		want string
+	}{
+		{decNum("0.1"), "0.1"},
+		{decNum("-12"), "-12"},
+		{decNum("1/1024"), "0.0009765625"},
+		{bigNum("-123456789012345678901234567890"), "-123456789012345678901234567890"},
+	} {
+		if got := tt.num.String(); got != tt.want {
+			t.Errorf("expected a number to print as %s got %s", tt.want, got)
+		}
+	}
+
+	//each evaluator rounds to its own number of places
+	iptr.Reset()
+	iptr.DecimalPlaces = 2
+	other := CreateEvaluator(false)
+	if err := other.Run("decimal d = 1; print d / 3"); err != nil || !NumberSliceEqual(other.PrintVals, []Number{decNum("0.3333333333333333333333333333")}) {
+		t.Errorf("expected divisions rounded to %d places got %+v, %v", DefaultDecimalPlaces, other.PrintVals, err)
+	}
+	if err := iptr.Run("decimal d = 1; print d / 8; print d / 3; print decimal(2) / 3"); err != nil || !NumberSliceEqual(iptr.PrintVals, []Number{decNum("0.12"), decNum("0.33"), decNum("0.67")}) {
+		t.Errorf("expected divisions rounded half to even to 2 places got %+v, %v", iptr.PrintVals, err)
+	}
+}
+
+func TestControlFlow(t *testing.T) {
+	iptr := CreateEvaluator(false)
+
+	yes, no := Number{Type: BOOL, Bool: true}, Number{Type: BOOL, Bool: false}
+	flowTable := map[string][]Number{
+		"print 1 < 2; print 2 <= 1; print 1.5 == 1.5; print 3 != 3":   []Number{yes, no, yes, no},
+		"int i = 2; print i >= 2.0; print i > 2":                      []Number{yes, no},
+		"print 1 < 2 && 2 < 1 || !false":                              []Number{yes},
+		"bool b = 1 + 1 == 2; print b == true; print !b":              []Number{yes, no},
+		"int i = 0; print i != 0 && 10 / i > 1":                       []Number{no},
+		"int x = 5; if x > 3 then print 1 else print 2":               []Number{Number{Type: INT, Num: 1}},
+		"int x = 1; if x > 3 then print 1 else if x > 0 then print 2": []Number{Number{Type: INT, Num: 2}},
+		"int x = 1; if x > 3 then { print 1 } else { x = 7; print x }; print x": []Number{
+			Number{Type: INT, Num: 7}, Number{Type: INT, Num: 7},
+		},
+		"int i = 0; int sum = 0; while i < 5 { i += 1; sum += i }; print sum": []Number{Number{Type: INT, Num: 15}},
+		"int x = 1; { float x = 2.5; print x }; print x": []Number{
+			Number{Type: FLOAT, Flt: 2.5}, Number{Type: INT, Num: 1},
+		},
+		"const int c = 1; { int c = 2; c += 1; print c }; print c": []Number{
+			Number{Type: INT, Num: 3}, Number{Type: INT, Num: 1},
+		},
+		"int x = 1; if true then int x = 2; print x": []Number{Number{Type: INT, Num: 1}},
+	}
+
+	for pr, res := range flowTable {
+		iptr.Reset()
+		if err := iptr.Run(pr); err != nil {
+			t.Errorf("could not run %q: %v", pr, err)
+			continue
+		}
+		if !NumberSliceEqual(iptr.PrintVals, res) {
+			t.Errorf("expected %q to print %+v got %+v", pr, res, iptr.PrintVals)
+		}
+	}
+
+	typeErrTable := map[string]string{
+		"if 1 then print 1":                "condition must be a bool, got an int",
+		"while 1.5 { print 1 }":            "condition must be a bool, got a double",
+		"print true + 1":                   "operator + is not defined on bool and int",
+		"print 1 < true":                   "operator < is not defined on int and bool",
+		"print true < false":               "operator < is not defined on bool and bool",
+		"print 1 && true":                  "operator && is not defined on int and bool",
+		"print -true":                      "operator - is not defined on bool",
+		"print !1":                         "operator ! is not defined on int",
+		"int x = true":                     "cannot assign a bool to int x",
+		"bool b = true; b = 1":             "cannot assign an int to bool b",
+		"bool b = true; b += true":         "operator += is not defined on bool",
+		"print int(true)":                  "cannot cast a bool to int",
+		"{ int x = 1 }; print x":           "unknown identifier x",
+		"while false { int y = 1 }; y = 2": "unknown identifier y",
+	}
+
+	for pr, msg := range typeErrTable {
+		iptr.Reset()
+		err := iptr.Run(pr)
+		errs, ok := err.(TypeErrorList)
+		if !ok || len(errs) != 1 || errs[0].Msg != msg {
+			t.Errorf("expected %q to fail with %q got %v", pr, msg, err)
+		}
+	}
+
+	//a runtime error in a block leaves it
+	iptr.Reset()
+	if _, ok := iptr.Run("int x = 1; { int y = 0; print x / y }").(*RuntimeError); !ok {
+		t.Fatalf("expected a division by zero")
+	}
+	if err := iptr.Run("print x"); err != nil || len(iptr.vars) != 1 {
+		t.Errorf("expected the block scope to be popped got %v", err)
+	}
+
+	src, err := ioutil.ReadFile("testdata/gcd.calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iptr.Reset()
+	want := []Number{Number{Type: INT, Num: 21}, Number{Type: LONG, Long: 3628800}}
+	if err := iptr.Run(string(src)); err != nil || !NumberSliceEqual(iptr.PrintVals, want) {
+		t.Errorf("expected testdata/gcd.calc to print %+v got %+v, %v", want, iptr.PrintVals, err)
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	iptr := CreateEvaluator(false)
+
+	funcTable := map[string][]Number{
+		"func add(int a, float b) float { return a + b }; print add(1, 2.5)": []Number{Number{Type: FLOAT, Flt: 3.5}},
+		"func half(double d) double { return d / 2 }; print half(3)":         []Number{Number{Type: DOUBLE, Dbl: 1.5}},
+		"func one() int { return 1 }; print one() + one()":                   []Number{Number{Type: INT, Num: 2}},
+		"func trunc(float f) int { return int(f) }; print trunc(2.75)":       []Number{Number{Type: INT, Num: 2}},
+		"func id(int i) int { return i }; print id(4.0)":                     []Number{Number{Type: INT, Num: 4}},
+		"func fact(int n) long {\n\tif n <= 1 then return 1\n\treturn n * fact(n - 1)\n}\nprint fact(20)": []Number{
+			Number{Type: LONG, Long: 2432902008176640000},
+		},
+		"func fib(int n) int { if n < 2 then { return n } else { return fib(n-1) + fib(n-2) } }; print fib(15)": []Number{
+			Number{Type: INT, Num: 610},
+		},
+		"func even(int n) bool { while n > 1 { n -= 2 }; return n == 0 }; print even(10); print even(7)": []Number{
+			Number{Type: BOOL, Bool: true}, Number{Type: BOOL, Bool: false},
+		},
+		"func show(int n) int { print n; return n }; show(3)": []Number{Number{Type: INT, Num: 3}},
+		//a function sees the variables where it is declared, not where it is called
+		"int x = 1; func getx() int { return x }; { int x = 2; print getx() }": []Number{Number{Type: INT, Num: 1}},
+		"int x = 1; func inc() int { x += 1; return x }; print inc(); print x": []Number{
+			Number{Type: INT, Num: 2}, Number{Type: INT, Num: 2},
+		},
+		//a reset in a body runs when the function is called
+		"int x = 0\nfunc f() int { reset x; return 1 }\nprint x":   []Number{Number{Type: INT, Num: 0}},
+		"int x = 0\nfunc f() int { reset; return 1 }\nprint x + 1": []Number{Number{Type: INT, Num: 1}},
+		//functions can call the ones declared after them
+		"func even(int n) bool { if n == 0 then return true; return odd(n - 1) }\nfunc odd(int n) bool { if n == 0 then return false; return even(n - 1) }\nprint even(10); print odd(7)": []Number{
+			Number{Type: BOOL, Bool: true}, Number{Type: BOOL, Bool: true},
+		},
+	}
+
+	for pr, res := range funcTable {
+		iptr.Reset()
+		if err := iptr.Run(pr); err != nil {
+			t.Errorf("could not run %q: %v", pr, err)
+			continue
+		}
+		if !NumberSliceEqual(iptr.PrintVals, res) {
+			t.Errorf("expected %q to print %+v got %+v", pr, res, iptr.PrintVals)
+		}
+	}
+
+	typeErrTable := map[string]string{
+		"print f(1)": "unknown function f",
+		"func f(int a) int { return a }; print f()":                  "f takes 1 arguments got 0",
+		"func f(int a) int { return a }; print f(1.5)":               "cannot assign to int parameter a of f: 1.5 is not exactly representable as an int",
+		"func f(int a) int { return a }; print f(true)":              "cannot assign a bool to int parameter a of f",
+		"func f(int a) int { return 2.5 }":                           "cannot assign to the int result of f: 2.5 is not exactly representable as an int",
+		"func f(int a) bool { return a }":                            "cannot assign an int to the bool result of f",
+		"func f(int a) int { if a > 0 then return 1 }":               "func f does not return an int on every path",
+		"func f(int a) int { while true { return 1 } }":              "func f does not return an int on every path",
+		"func f(int a) int { return b }":                             "unknown identifier b",
+		"func f(int a) int { return a }; print a":                    "unknown identifier a",
+		"func f(bool b) bool { return !b }; print f(1)":              "cannot assign an int to bool parameter b of f",
+		"func g() int { return h() }; { func h() int { return 1 } }": "unknown function h",
+		"print h(); func h() int { return 1 }":                       "unknown function h",
+	}
+
+	for pr, msg := range typeErrTable {
+		iptr.Reset()
+		err := iptr.Run(pr)
+		errs, ok := err.(TypeErrorList)
+		if !ok || len(errs) != 1 || errs[0].Msg != msg {
+			t.Errorf("expected %q to fail with %q got %v", pr, msg, err)
+		}
+	}
+
+	//a function declared by an earlier program can be called
+	iptr.Reset()
+	if err := iptr.Run("func sq(long l) long { return l * l }"); err != nil {
+		t.Fatalf("could not declare sq: %v", err)
+	}
+	if err := iptr.Run("print sq(4000000000)"); err == nil {
+		t.Errorf("expected sq(4000000000) to overflow")
+	}
+	if err := iptr.Run("print sq(3)"); err != nil || !NumberSliceEqual(iptr.PrintVals, []Number{Number{Type: LONG, Long: 9}}) {
+		t.Errorf("expected sq(3) to print 9 got %+v, %v", iptr.PrintVals, err)
+	}
+	if _, ok := iptr.Run("reset sq; print sq(3)").(TypeErrorList); !ok {
+		t.Errorf("expected reset sq to unbind sq")
+	}
+
+	//a function called before the one it calls is declared, or after a reset
+	//in its body, fails when it runs
+	runtimeErrTable := map[string]string{
+		"func g() int { return h() }; print g(); func h() int { return 1 }": "unknown function h",
+		"int x = 0; func f() int { reset x; return 1 }; print f(); print x": "unknown identifier x",
+	}
+	for pr, msg := range runtimeErrTable {
+		iptr.Reset()
+		err, ok := iptr.Run(pr).(*RuntimeError)
+		if !ok || err.Msg != msg {
+			t.Errorf("expected %q to fail with %q got %v", pr, msg, err)
+		}
+	}
+
+	if _, err := BuildParser().Parse("return 1"); err == nil || !strings.Contains(err.Error(), "return outside of a function") {
+		t.Errorf("expected a return outside of a function to be a syntax error got %v", err)
+	}
+}
+
+func TestComments(t *testing.T) {
+	commentTable := map[string][]Number{
+		"print 2+3 # five":                   []Number{Number{Type: INT, Num: 5}},
+		"print 6/2 // three":                 []Number{Number{Type: INT, Num: 3}},
+		"print 2 + /* three */ 3":            []Number{Number{Type: INT, Num: 5}},
+		"# int a = 1\nint a = 2\nprint a":    []Number{Number{Type: INT, Num: 2}},
+		"int a = 1 /* a\nb */ print a + 1":   []Number{Number{Type: INT, Num: 2}},
+		"print 1 /* one */; print 2 # two\n": []Number{Number{Type: INT, Num: 1}, Number{Type: INT, Num: 2}},
+	}
+
+	for pr, res := range commentTable {
+		iptr := CreateEvaluator(false)
+		if err := iptr.Run(pr); err != nil {
+			t.Errorf("could not run %q: %v", pr, err)
+			continue
+		}
+		if !NumberSliceEqual(iptr.PrintVals, res) {
+			t.Errorf("expected %q to print %+v got %+v", pr, res, iptr.PrintVals)
+		}
+	}
+
+	src, err := ioutil.ReadFile("testdata/circle.calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iptr := CreateEvaluator(false)
+	want := []Number{Number{Type: FLOAT, Flt: 12.56}, Number{Type: FLOAT, Flt: 12.56}}
+	if err := iptr.Run(string(src)); err != nil || !NumberSliceEqual(iptr.PrintVals, want) {
+		t.Errorf("expected testdata/circle.calc to print %+v got %+v, %v", want, iptr.PrintVals, err)
+	}
+}
+
+func TestSyntaxDiagnostic(t *testing.T) {
+	src := "int a = 2; print a +;"
+	want := `syntax error: expected one of the types [IDENTIFIER DECIMAL NUMBER BOOLEAN TYPE (] got type ;
+ --> 1:21
+  |
+1 | int a = 2; print a +;
+  |                     ^
+`
+
+	_, err := BuildParser().Parse(src)
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("expected %q to fail with one syntax error got %v", src, err)
+	}
+	if got := errs[0].Diagnostic(src); got != want {
+		t.Errorf("expected diagnostic\n%s\ngot\n%s", want, got)
+	}
+	if errs[0].Got != ";" {
+		t.Errorf("expected the unexpected token type to be ; got %s", errs[0].Got)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	src := "int a = 2 +; print a\nint = 3\n\nprint $ 1; print 0; float f = 1.5 *\nprint a"
+
+	node, err := BuildParser().Parse(src)
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected an ErrorList got %v", err)
+	}
+
+	want := []Position{
+		{Offset: 11, Line: 1, Col: 12},
+		{Offset: 25, Line: 2, Col: 5},
+		{Offset: 36, Line: 4, Col: 7},
+		{Offset: 65, Line: 4, Col: 36},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors got %d: %v", len(want), len(errs), errs)
+	}
+	for i, e := range errs {
+		if e.Pos.Start != want[i] {
+			t.Errorf("expected error %d at %s got %v", i, want[i], e)
+		}
+	}
+
+	if lines := node.(*Program).Lines; len(lines) != 3 {
+		t.Errorf("expected the partial ast to keep 3 lines got %d", len(lines))
+	}
+
+	if err := CreateEvaluator(false).Run(src); err == nil {
+		t.Errorf("expected Run to return the syntax errors")
+	}
+}
+
+// TestReadError reads programs whose input fails after the first chunk,
+// both while parsing a line and while skipping a malformed one
+func TestReadError(t *testing.T) {
+	p := BuildParser()
+	for _, src := range []string{"int a = 1\nint b = (", "int a = 1\nint b = 1 $ 2 3"} {
+		node, err := p.ParseReader(iotest.TimeoutReader(strings.NewReader(src)))
+		errs, ok := err.(ErrorList)
+		if !ok {
+			t.Fatalf("expected an ErrorList for %q got %v", src, err)
+		}
+		last := errs[len(errs)-1]
+		if last.Msg != iotest.ErrTimeout.Error() || last.Pos.Start.Offset != len(src) {
+			t.Errorf("expected %q to end with the read error at %d got %v", src, len(src), errs)
+		}
+		if lines := node.(*Program).Lines; len(lines) != 1 {
+			t.Errorf("expected %q to keep the first line got %d", src, len(lines))
+		}
+	}
+}
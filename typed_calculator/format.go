@@ -0,0 +1,323 @@
+package typedcalculator
+
+import (
+	"calctools/layout"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// precedence levels of the expression grammar, from the loosest binding
+// expression up to factor, see EBNF_CALCULATOR.md
+const (
+	anyLevel = iota
+	orLevel
+	andLevel
+	cmpLevel
+	sumLevel
+	termLevel
+	unaryLevel
+	powerLevel
+	factorLevel
+)
+
+var opLevels = map[Op]int{
+	OR:       orLevel,
+	AND:      andLevel,
+	EQ:       cmpLevel,
+	NEQ:      cmpLevel,
+	LT:       cmpLevel,
+	GT:       cmpLevel,
+	LTE:      cmpLevel,
+	GTE:      cmpLevel,
+	PLUS:     sumLevel,
+	MINUS:    sumLevel,
+	MULTIPLY: termLevel,
+	DIVIDE:   termLevel,
+	POWER:    powerLevel,
+}
+
+// level returns the precedence level of an expression. A NOOP wrapper and
+// a cast the checker inserted are not written, they take the level of
+// their operand.
+func level(n Node) int {
+	switch f := n.(type) {
+	case *Binary2:
+		if f.Op == NOOP {
+			return level(f.Lhs)
+		}
+		return opLevels[f.Op]
+	case *Unary:
+		return unaryLevel
+	case *Cast:
+		if f.Exact {
+			return level(f.Expr)
+		}
+	case *Number:
+		//only the optimizer makes negative literals
+		if strings.HasPrefix(literalString(*f), "-") {
+			return unaryLevel
+		}
+	}
+	return factorLevel
+}
+
+// formatter writes an ast as source, the layout.Writer indents its lines
+// and places the comments around them
+type formatter struct {
+	*layout.Writer
+	end Position //where the lines being written end
+}
+
+// Format writes the ast rooted at node to w as source. The source is in a
+// canonical form: one statement per line, blocks indented by tabs, one
+// space around binary operators and parentheses only where the grammar
+// needs them. Parsing it gives an ast with the same structure as node,
+// apart from the NOOP wrappers of the parentheses that were left out. The
+// casts a TypeChecker inserts are left out as it inserts them again. A
+// program is written with a newline after every line.
+func Format(w io.Writer, node Node) {
+	f := &formatter{Writer: layout.NewWriter(w, nil)}
+	node.accept(f)
+}
+
+// FormatSource parses src and returns it formatted like Format. Unlike the
+// ast, it keeps the comments of src: a comment is written on the line it was
+// on, before the line that follows it or after the line that ends on its
+// line. The error is the ErrorList of the syntax errors in src.
+func FormatSource(src string) (string, error) {
+	p := BuildParser()
+	p.Lexer.KeepComments(true)
+	node, err := p.Parse(src)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	f := &formatter{Writer: layout.NewWriter(&sb, p.Lexer.Comments())}
+	node.accept(f)
+	return sb.String(), nil
+}
+
+// lines writes each line of a program or block, which ends at end
+func (f *formatter) lines(lines []*Line, end Position) {
+	outer := f.end
+	f.end = end
+	for _, l := range lines {
+		l.accept(f)
+	}
+	f.CommentsBefore(end)
+	f.end = outer
+}
+
+// expr writes an expression that the grammar expects at level min, in
+// parentheses when it binds looser than that
+func (f *formatter) expr(n Node, min int) {
+	if level(n) < min {
+		f.Printf("(")
+		n.accept(f)
+		f.Printf(")")
+		return
+	}
+	n.accept(f)
+}
+
+// openIf reports whether n is an if that would take an else written after
+// it
+func openIf(n Node) bool {
+	i, ok := n.(*If)
+	return ok && (i.Else == nil || openIf(i.Else))
+}
+
+func (f *formatter) visitProgramStmt(p *Program) {
+	//the comments after the last line are part of the program
+	f.lines(p.Lines, Position{Offset: math.MaxInt32})
+}
+
+func (f *formatter) visitLineStmt(l *Line) {
+	f.CommentsBefore(l.Pos.Start)
+	f.Gap(l.Pos.Start.Line)
+	f.Indent()
+	l.Stmt.accept(f)
+	f.Line = l.Pos.End.Line
+	f.Trailing(l.Pos.End, f.end)
+	f.Printf("\n")
+}
+
+func (f *formatter) visitAssignmentStmt(a *Assignment) {
+	if a.Const {
+		f.Printf("const ")
+	}
+	f.Printf("%s %s = ", typeName(a.Type), a.Identifier)
+	f.expr(a.Expr, anyLevel)
+}
+
+func (f *formatter) visitReassignStmt(r *Reassign) {
+	f.Printf("%s %s= ", r.Identifier, opSymbols[r.Op])
+	f.expr(r.Expr, anyLevel)
+}
+
+func (f *formatter) visitPrintStmt(p *Print) {
+	f.Printf("print ")
+	f.expr(p.Expr, anyLevel)
+}
+
+func (f *formatter) visitResetStmt(r *Reset) {
+	f.Printf("reset")
+	if r.Identifier != "" {
+		f.Printf(" %s", r.Identifier)
+	}
+}
+
+func (f *formatter) visitBlockStmt(b *Block) {
+	if len(b.Lines) == 0 && !f.HasComments(b.Pos.End) {
+		f.Printf("{}")
+		return
+	}
+	f.Printf("{\n")
+	f.Depth++
+	f.Line = 0
+	f.lines(b.Lines, b.Pos.End)
+	f.Depth--
+	f.Indent()
+	f.Printf("}")
+}
+
+func (f *formatter) visitIfStmt(i *If) {
+	f.Printf("if ")
+	f.expr(i.Cond, anyLevel)
+	f.Printf(" then ")
+	if i.Else != nil && openIf(i.Then) {
+		//the else would go to the inner if, no source parses to this ast
+		//but a block keeps its meaning
+		pos := i.Then.position()
+		(&Block{Lines: []*Line{{Stmt: i.Then, Pos: pos}}, Pos: pos}).accept(f)
+	} else {
+		i.Then.accept(f)
+	}
+	if i.Else != nil {
+		f.Printf(" else ")
+		i.Else.accept(f)
+	}
+}
+
+func (f *formatter) visitWhileStmt(w *While) {
+	f.Printf("while ")
+	f.expr(w.Cond, anyLevel)
+	f.Printf(" ")
+	w.Body.accept(f)
+}
+
+func (f *formatter) visitFuncStmt(fn *Func) {
+	params := make([]string, len(fn.Params))
+	for i, p := range fn.Params {
+		params[i] = typeName(fn.ParamTypes[i]) + " " + p
+	}
+	f.Printf("func %s(%s) %s ", fn.Name, strings.Join(params, ", "), typeName(fn.Result))
+	fn.Body.accept(f)
+}
+
+func (f *formatter) visitReturnStmt(r *Return) {
+	f.Printf("return ")
+	f.expr(r.Expr, anyLevel)
+}
+
+func (f *formatter) visitCallStmt(c *Call) {
+	f.Printf("%s(", c.Name)
+	for i, a := range c.Args {
+		if i > 0 {
+			f.Printf(", ")
+		}
+		f.expr(a, anyLevel)
+	}
+	f.Printf(")")
+}
+
+// visitBinary2Stmt writes a chain of left associative operators as it is
+// nested, so only a nested rhs needs parentheses. A comparison does not
+// chain and the lhs of ** is a factor.
+func (f *formatter) visitBinary2Stmt(b *Binary2) {
+	if b.Op == NOOP {
+		f.expr(b.Lhs, anyLevel)
+		return
+	}
+	lhs, rhs := opLevels[b.Op], opLevels[b.Op]+1
+	switch b.Op {
+	case POWER:
+		lhs, rhs = factorLevel, unaryLevel
+	case EQ, NEQ, LT, GT, LTE, GTE:
+		lhs = cmpLevel + 1
+	}
+	f.expr(b.Lhs, lhs)
+	f.Printf(" %s ", opSymbols[b.Op])
+	f.expr(b.Rhs, rhs)
+}
+
+func (f *formatter) visitUnaryStmt(u *Unary) {
+	f.Printf("%s", opSymbols[u.Op])
+	f.expr(u.Expr, unaryLevel)
+}
+
+func (f *formatter) visitCastStmt(c *Cast) {
+	if c.Exact {
+		f.expr(c.Expr, anyLevel)
+		return
+	}
+	f.Printf("%s(", typeName(c.Type))
+	f.expr(c.Expr, anyLevel)
+	f.Printf(")")
+}
+
+func (f *formatter) visitIdentifierStmt(i *Identifier) {
+	f.Printf("%s", i.Val)
+}
+
+func (f *formatter) visitNumberStmt(n *Number) {
+	f.Printf("%s", literalString(*n))
+}
+
+// literalString writes n the way the parser reads it back. A value that no
+// literal has, e.g. a float or a long that fits in an int, is cast to its
+// type.
+func literalString(n Number) string {
+	switch n.Type {
+	case INT:
+		return strconv.FormatInt(int64(n.Num), 10)
+	case LONG:
+		if _, err := intNum(n.Long); err == nil {
+			return fmt.Sprintf("long(%d)", n.Long)
+		}
+		return strconv.FormatInt(n.Long, 10)
+	case BIGINT:
+		if n.Big.IsInt64() {
+			return fmt.Sprintf("bigint(%s)", n.Big)
+		}
+		return n.Big.String()
+	case FLOAT:
+		return fmt.Sprintf("float(%s)", pointed(strconv.FormatFloat(float64(n.Flt), 'f', -1, 64)))
+	case DOUBLE:
+		//a decimal literal keeps the digits it was written with, and any
+		//other double becomes the decimal with its exact value
+		if n.Dec != nil {
+			return pointed(decimalString(n.Dec))
+		}
+		if r := new(big.Rat).SetFloat64(n.Dbl); r != nil {
+			return pointed(decimalString(r))
+		}
+	case DECIMAL:
+		return fmt.Sprintf("decimal(%s)", pointed(decimalString(n.Dec)))
+	}
+	return n.String()
+}
+
+// pointed adds a point to the digits of a whole number, so they are read
+// back as a decimal literal
+func pointed(digits string) string {
+	if strings.ContainsAny(digits, ".IN") {
+		return digits
+	}
+	return digits + ".0"
+}
@@ -0,0 +1,205 @@
+package typedcalculator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// shape writes the structure of an ast without its positions and NOOP
+// wrappers, so asts parsed from different sources can be compared
+func shape(v reflect.Value) string {
+	if !v.IsValid() || (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return "nil"
+	}
+	switch x := v.Interface().(type) {
+	case *big.Int:
+		return x.String()
+	case *big.Rat:
+		return x.String()
+	case *Binary2:
+		if x.Op == NOOP {
+			return shape(reflect.ValueOf(x.Lhs))
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return shape(v.Elem())
+	case reflect.Slice:
+		elems := make([]string, v.Len())
+		for i := range elems {
+			elems[i] = shape(v.Index(i))
+		}
+		return "[" + strings.Join(elems, " ") + "]"
+	case reflect.Struct:
+		fields := make([]string, 0, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			if name := v.Type().Field(i).Name; name != "Pos" {
+				fields = append(fields, name+":"+shape(v.Field(i)))
+			}
+		}
+		return v.Type().Name() + "{" + strings.Join(fields, " ") + "}"
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func TestFormat(t *testing.T) {
+	formatted := map[string]string{
+		"print 1+2*3":                                       "print 1 + 2 * 3\n",
+		"print (1+2)*3":                                     "print (1 + 2) * 3\n",
+		"print ((x))":                                       "print x\n",
+		"print a - (b - c)":                                 "print a - (b - c)\n",
+		"print (a - b) - c":                                 "print a - b - c\n",
+		"print 2 ** 3 ** 2":                                 "print 2 ** 3 ** 2\n",
+		"print (2 ** 3) ** 2":                               "print (2 ** 3) ** 2\n",
+		"print -2 ** 2; print (-2) ** 2":                    "print -2 ** 2\nprint (-2) ** 2\n",
+		"print 2 ** -x":                                     "print 2 ** -x\n",
+		"print !(a && b) || c && (d || e)":                  "print !(a && b) || c && (d || e)\n",
+		"print (a < b) == (c + 1 > d)":                      "print (a < b) == (c + 1 > d)\n",
+		"print -(a + b) * int(c / 2)":                       "print -(a + b) * int(c / 2)\n",
+		"print 0.10 + 3.0 + 123456789012345678901234567890": "print 0.1 + 3.0 + 123456789012345678901234567890\n",
+		"const decimal d=0.5;x+=1;x=2;reset x;reset":        "const decimal d = 0.5\nx += 1\nx = 2\nreset x\nreset\n",
+		"if a then if b then c() else d()":                  "if a then if b then c() else d()\n",
+		"while i<3 {i+=1}; while false {}":                  "while i < 3 {\n\ti += 1\n}\nwhile false {}\n",
+		"func f(int a,double b) long { if a > 0 then { return f(a - 1, b) } else { return 1 } }": "func f(int a, double b) long {\n\tif a > 0 then {\n\t\treturn f(a - 1, b)\n\t} else {\n\t\treturn 1\n\t}\n}\n",
+		"print 1\n\n\n\nprint 2\nprint 3": "print 1\n\nprint 2\nprint 3\n",
+	}
+	for src, want := range formatted {
+		node, err := BuildParser().Parse(src)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", src, err)
+		}
+		var out strings.Builder
+		Format(&out, node)
+		if out.String() != want {
+			t.Errorf("expected %q to format as %q got %q", src, want, out.String())
+		}
+	}
+
+	//an else that belongs to the outer if needs the inner one in a block
+	node, _ := BuildParser().Parse("if a then if b then c() else d()")
+	outer := node.(*Program).Lines[0].Stmt.(*If)
+	inner := outer.Then.(*If)
+	outer.Else, inner.Else = inner.Else, nil
+	var out strings.Builder
+	Format(&out, node)
+	if want := "if a then {\n\tif b then c()\n} else d()\n"; out.String() != want {
+		t.Errorf("expected %q got %q", want, out.String())
+	}
+
+	srcs := []string{
+		"int x = 6; print x * 1; print 1 * x; print x + 0 - 0; print x / 1",
+		"float f = -0.0; print f + 0; print f * 1; double d = 2; print d * 1.5 + 0",
+		"decimal d = 0.1; print d + 0.2; print d * 1; print (0.1 + 0.2) + d",
+		"bigint b = 3; print b * 1 + 9223372036854775807 * 2; print long(2) ** 40",
+		"print float(0.1) + 1; print int(2.75) + 1; print decimal(1) / 3; print -(1 + 1) * 3",
+		"int i = 0; print i != 0 && 10 / i > 1; print true || 1 / i > 0; print !(1 < 2)",
+		"if 1 < 2 then print 1 else print 2; if false then { print 4 } else { print 5 }",
+	}
+	for _, file := range []string{"testdata/gcd.calc", "testdata/circle.calc"} {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srcs = append(srcs, string(src))
+	}
+	for src := range formatted {
+		srcs = append(srcs, src)
+	}
+
+	for _, src := range srcs {
+		//the formatted source parses to the same ast and formats the same
+		node, err := BuildParser().Parse(src)
+		if err != nil {
+			t.Fatalf("could not parse %q: %v", src, err)
+		}
+		var out strings.Builder
+		Format(&out, node)
+		again, err := BuildParser().Parse(out.String())
+		if err != nil {
+			t.Errorf("could not parse %q formatted as %q: %v", src, out.String(), err)
+			continue
+		}
+		if shape(reflect.ValueOf(again)) != shape(reflect.ValueOf(node)) {
+			t.Errorf("expected %q formatted as %q to parse to\n%s\ngot\n%s", src, out.String(), shape(reflect.ValueOf(node)), shape(reflect.ValueOf(again)))
+		}
+		var twice strings.Builder
+		Format(&twice, again)
+		if twice.String() != out.String() {
+			t.Errorf("expected formatting %q to be stable got %q then %q", src, out.String(), twice.String())
+		}
+
+		//the literals of an optimized program are written so they are read
+		//back with the same value and type
+		want := CreateEvaluator(false)
+		if want.Run(src) != nil {
+			continue
+		}
+		if err := NewTypeChecker(nil, nil).Run(node); err != nil {
+			t.Fatal(err)
+		}
+		var opt strings.Builder
+		Format(&opt, Optimize(node))
+		got := CreateEvaluator(false)
+		if err := got.Run(opt.String()); err != nil || fmt.Sprint(got.PrintVals) != fmt.Sprint(want.PrintVals) {
+			t.Errorf("expected %q optimized to %q to print %v got %v, %v", src, opt.String(), want.PrintVals, got.PrintVals, err)
+		}
+	}
+}
+
+func TestFormatSource(t *testing.T) {
+	src := `# leading
+
+func add(int a,int b) int { return a+b }  // sum
+int x = 1
+while x < 10 {
+	# first
+	x *= 2   /* trailing */
+
+
+	print x
+	// last
+}
+{ # empty
+}
+print add(x, 1) # end
+/* block
+   comment */`
+
+	want := `# leading
+
+func add(int a, int b) int {
+	return a + b
+} // sum
+int x = 1
+while x < 10 {
+	# first
+	x *= 2 /* trailing */
+
+	print x
+	// last
+}
+{
+	# empty
+}
+print add(x, 1) # end
+/* block
+   comment */
+`
+	got, err := FormatSource(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	if again, _ := FormatSource(got); again != got {
+		t.Errorf("expected formatting to be stable got\n%s", again)
+	}
+	if _, err := FormatSource("int = 1"); err == nil {
+		t.Errorf("expected a syntax error")
+	}
+}
@@ -14,6 +14,7 @@ type astGenerator struct {
 
 func (ag *astGenerator) defineAst(baseName string, types []string) {
 	ag.sb.WriteString("package typedcalculator\n\n")
+	ag.sb.WriteString("import \"math/big\"\n\n")
 
 	ag.defineNodeInterface()
 
@@ -21,32 +22,48 @@ func (ag *astGenerator) defineAst(baseName string, types []string) {
 	visitorTypes := make([]string, 0)
 
 	for _, ty := range types {
-		rs := strings.Split(ty, ":")
-		r := strings.TrimSpace(rs[0])
+		r, fts, _ := parseType(ty)
 		visitorTypes = append(visitorTypes, r)
-
-		fts := make([]string, 0)
-		fs := strings.Split(rs[1], ",")
-		for i, _ := range fs {
-			if fs[i] == " " {
-				continue
-			}
-			fs[i] = strings.TrimSpace(fs[i])
-			ev := strings.Split(fs[i], " ")
-			for j, _ := range ev {
-				ev[j] = strings.TrimSpace(ev[j])
-			}
-			fts = append(fts, ev...)
-		}
 		ag.defineType(baseName, r, fts)
 		ag.defineVisitor(baseName, r)
 	}
 
 	ag.generateNodeType(visitorTypes)
+	ag.generatePosition(visitorTypes)
 	ag.generateVisitorInterface(baseName, visitorTypes)
 
 }
 
+// parseType splits the description of a node into its name, a list of
+// alternating field names and types, and the names of the node fields that
+// may be nil, which are marked with a ? after their type
+func parseType(ty string) (string, []string, map[string]bool) {
+	rs := strings.Split(ty, ":")
+	r := strings.TrimSpace(rs[0])
+
+	fts := make([]string, 0)
+	optional := make(map[string]bool)
+	fs := strings.Split(rs[1], ",")
+	for i, _ := range fs {
+		if fs[i] == " " {
+			continue
+		}
+		fs[i] = strings.TrimSpace(fs[i])
+		ev := strings.Split(fs[i], " ")
+		for j, _ := range ev {
+			ev[j] = strings.TrimSpace(ev[j])
+		}
+		if strings.HasSuffix(ev[1], "?") {
+			ev[1] = strings.TrimSuffix(ev[1], "?")
+			optional[ev[0]] = true
+		}
+		fts = append(fts, ev...)
+	}
+	// every node records the span of source it was parsed from
+	fts = append(fts, "Pos", "Span")
+	return r, fts, optional
+}
+
 func (ag *astGenerator) defineType(baseType string, typeName string, fields []string) {
 	ag.sb.WriteString("type ")
 	ag.sb.WriteString(typeName)
@@ -65,10 +82,14 @@ func (ag *astGenerator) defineType(baseType string, typeName string, fields []st
 }
 
 func (ag *astGenerator) OpGenerator() {
-	ops := []string{"ILLEGALOP", "NOOP", "PLUS", "MINUS", "MULTIPLY", "DIVIDE", "POWER"}
+	ops := []string{"ILLEGALOP", "NOOP", "PLUS", "MINUS", "MULTIPLY", "DIVIDE", "POWER",
+		"EQ", "NEQ", "LT", "GT", "LTE", "GTE", "AND", "OR", "NOT"}
 	ag.generateConstEnum("Op", ops)
 
-	types := []string{"NOTYPE", "FLOAT", "DOUBLE", "INT", "LONG"}
+	// the number types are ordered from narrowest to widest, a value widens
+	// to a later one, except that the exact BIGINT only widens to DECIMAL.
+	// BOOL is not a number.
+	types := []string{"NOTYPE", "INT", "LONG", "BIGINT", "FLOAT", "DOUBLE", "DECIMAL", "BOOL"}
 	ag.generateConstEnum("Type", types)
 }
 
@@ -99,6 +120,7 @@ func (ag *astGenerator) generateConstEnum(c string, vals []string) {
 func (ag *astGenerator) defineNodeInterface() {
 	ag.sb.WriteString("type Node interface {\n")
 	ag.sb.WriteString("isNode()\n")
+	ag.sb.WriteString("position() Span\n")
 	ag.sb.WriteString("accept(Visitor)}\n\n")
 }
 
@@ -114,6 +136,13 @@ func (ag *astGenerator) generateNodeType(types []string) {
 	ag.sb.WriteString("\n")
 }
 
+func (ag *astGenerator) generatePosition(types []string) {
+	for _, ty := range types {
+		ag.sb.WriteString(fmt.Sprintf("func (f *%s) position() Span { return f.Pos }\n", ty))
+	}
+	ag.sb.WriteString("\n")
+}
+
 func (ag *astGenerator) generateVisitorInterface(baseType string, types []string) {
 	ag.sb.WriteString("type Visitor interface {\n")
 	for _, ty := range types {
@@ -147,19 +176,127 @@ func (ag astGenerator) WriteToFile(file string) {
 	}
 }
 
+// fieldCodecs names the nodeWriter and nodeReader methods that encode and
+// decode a field of each type, see codec.go
+var fieldCodecs = map[string]string{
+	"Node":     "node",
+	"*Block":   "block",
+	"[]*Line":  "lines",
+	"[]Node":   "nodes",
+	"[]string": "strings",
+	"[]Type":   "types",
+	"Type":     "typ",
+	"Op":       "op",
+	"bool":     "bool",
+	"string":   "str",
+	"int32":    "int32",
+	"int64":    "int64",
+	"float32":  "float32",
+	"float64":  "float64",
+	"*big.Int": "bigInt",
+	"*big.Rat": "rat",
+	"Span":     "span",
+}
+
+// validated are the nodes whose fields depend on each other, readNode
+// checks them with their valid method, see codec.go
+var validated = map[string]bool{"Func": true, "Binary2": true, "Unary": true, "Number": true}
+
+// defineCodec writes writeNode and readNode, which encode and decode every
+// node with the methods of a nodeWriter and a nodeReader. A node is tagged
+// with the name of its type and its fields are named like the Go fields
+// with a lower case first letter. readNode fails on a node without a child
+// it needs.
+func (ag *astGenerator) defineCodec(types []string) {
+	ag.sb.WriteString("// Code generated by generator/generate_ast.go. DO NOT EDIT.\n\n")
+	ag.sb.WriteString("package typedcalculator\n\n")
+
+	nodes := make([]string, 0)
+	fields := make(map[string][]string)
+	optional := make(map[string]map[string]bool)
+	for _, ty := range types {
+		r, fts, opt := parseType(ty)
+		nodes = append(nodes, r)
+		fields[r] = fts
+		optional[r] = opt
+		for i := 1; i < len(fts); i += 2 {
+			if _, ok := fieldCodecs[fts[i]]; !ok {
+				log.Fatalf("no codec for field %s %s of %s", fts[i-1], fts[i], r)
+			}
+		}
+	}
+
+	ag.sb.WriteString("// writeNode writes n and the nodes below it with w, a nil n as null\n")
+	ag.sb.WriteString("func writeNode(w nodeWriter, n Node) {\n")
+	ag.sb.WriteString("switch f := n.(type) {\n")
+	for _, r := range nodes {
+		fts := fields[r]
+		ag.sb.WriteString(fmt.Sprintf("case *%s:\n", r))
+		ag.sb.WriteString(fmt.Sprintf("w.begin(%q)\n", r))
+		for i := 0; i < len(fts); i += 2 {
+			ag.sb.WriteString(fmt.Sprintf("w.%s(%q, f.%s)\n", fieldCodecs[fts[i+1]], fieldName(fts[i]), fts[i]))
+		}
+		ag.sb.WriteString("w.end()\n")
+	}
+	ag.sb.WriteString("default:\nw.null()\n}\n}\n\n")
+
+	ag.sb.WriteString("// readNode reads a node written by writeNode, null as nil\n")
+	ag.sb.WriteString("func readNode(r nodeReader) Node {\n")
+	ag.sb.WriteString("switch tag := r.begin(); tag {\n")
+	ag.sb.WriteString("case \"\":\nreturn nil\n")
+	for _, r := range nodes {
+		fts := fields[r]
+		ag.sb.WriteString(fmt.Sprintf("case %q:\n", r))
+		ag.sb.WriteString(fmt.Sprintf("f := &%s{}\n", r))
+		for i := 0; i < len(fts); i += 2 {
+			ag.sb.WriteString(fmt.Sprintf("f.%s = r.%s(%q)\n", fts[i], fieldCodecs[fts[i+1]], fieldName(fts[i])))
+		}
+		for i := 0; i < len(fts); i += 2 {
+			if (fts[i+1] == "Node" || fts[i+1] == "*Block") && !optional[r][fts[i]] {
+				ag.sb.WriteString(fmt.Sprintf("if f.%s == nil {\nr.fail(\"%s without %s\")\n}\n", fts[i], r, fieldName(fts[i])))
+			}
+		}
+		if validated[r] {
+			ag.sb.WriteString(fmt.Sprintf("if err := f.valid(); err != nil {\nr.fail(\"%s: %%v\", err)\n}\n", r))
+		}
+		ag.sb.WriteString("r.end()\nreturn f\n")
+	}
+	ag.sb.WriteString("default:\nr.fail(\"unknown node %s\", tag)\nreturn nil\n}\n}\n")
+}
+
+// fieldName is the name a field is encoded with, e.g. paramTypes
+func fieldName(field string) string {
+	return strings.ToLower(field[:1]) + field[1:]
+}
+
+// nodeTypes describes every node of the ast, its name and then its fields.
+// A node field that may be nil has a ? after its type.
+var nodeTypes = []string{
+	"Program   : Lines []*Line",
+	"Line     : Stmt Node",
+	"Assignment : Type Type , Const bool, Identifier string, Expr Node",
+	"Reassign : Type Type, Op Op, Identifier string, Expr Node",
+	"Print : Expr Node",
+	"Reset : Identifier string",
+	"Block : Lines []*Line",
+	"If : Cond Node, Then Node, Else Node?",
+	"While : Cond Node, Body Node",
+	"Func : Name string, Params []string, ParamTypes []Type, Result Type, Body *Block",
+	"Return : Expr Node",
+	"Call : Type Type, Name string, Args []Node",
+	"Binary2 : Type Type, Op Op, Lhs Node, Rhs Node?",
+	"Unary : Type Type, Op Op, Expr Node",
+	"Cast : Type Type, Exact bool, Expr Node",
+	"Identifier : Val string",
+	"Number : Type Type, Fixed bool, Num int32, Long int64, Big *big.Int, Flt float32, Dbl float64, Dec *big.Rat, Bool bool",
+}
+
 func main() {
 	ag := astGenerator{sb: &strings.Builder{}}
-	ag.defineAst("Stmt", []string{
-		"Program   : Lines []*Line",
-		"Line     : Stmt Node",
-		"Assignment : Type Type , Identifier string, Expr Node",
-		"Print : Expr Node",
-		"Reset : ",
-		"Binary2 : Type Type, Op Op, Lhs Node, Rhs Node",
-		"Identifier : Val string",
-		"Number : Type Type, Fixed bool, Num int, Flt float64",
-	})
-
+	ag.defineAst("Stmt", nodeTypes)
 	ag.WriteToFile("../ast_tree.go")
 
+	cg := astGenerator{sb: &strings.Builder{}}
+	cg.defineCodec(nodeTypes)
+	cg.WriteToFile("../ast_codec.go")
 }
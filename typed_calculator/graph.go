@@ -0,0 +1,165 @@
+package typedcalculator
+
+import (
+	"calctools/graph"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// astGraph draws an ast. As a Visitor it adds the node it visits below
+// parent.
+type astGraph struct {
+	*graph.Graph
+	parent int    //the node the next node is drawn below, -1 for the root
+	label  string //the label of the edge to the next node
+}
+
+// DumpDOT writes the ast rooted at node to w as a Graphviz DOT graph, e.g.
+// for `dot -Tsvg`. A node is labelled with what it is, its operator, the
+// identifier or literal it has and, once a TypeChecker has set it, its
+// type, e.g. "+ long". The NOOP wrappers of the parser are left out.
+func DumpDOT(w io.Writer, node Node) {
+	newGraph(node).WriteDOT(w)
+}
+
+// DumpMermaid writes the ast rooted at node to w as a Mermaid flowchart,
+// drawn like DumpDOT
+func DumpMermaid(w io.Writer, node Node) {
+	newGraph(node).WriteMermaid(w)
+}
+
+func newGraph(node Node) *astGraph {
+	g := &astGraph{Graph: &graph.Graph{}, parent: -1}
+	node.accept(g)
+	return g
+}
+
+// add adds a node with the label format makes below the parent and returns
+// its number
+func (g *astGraph) add(format string, a ...interface{}) int {
+	return g.Add(fmt.Sprintf(format, a...), g.parent, g.label)
+}
+
+// typed adds a node whose label ends with the type t, if it has been set
+func (g *astGraph) typed(t Type, format string, a ...interface{}) int {
+	if t != NOTYPE {
+		format += " " + typeName(t)
+	}
+	return g.add(format, a...)
+}
+
+// child draws n below parent along an edge labelled label
+func (g *astGraph) child(n Node, parent int, label string) {
+	g.parent, g.label = parent, label
+	n.accept(g)
+}
+
+func (g *astGraph) lines(lines []*Line, parent int) {
+	for _, l := range lines {
+		g.child(l, parent, "")
+	}
+}
+
+func (g *astGraph) visitProgramStmt(p *Program) {
+	g.lines(p.Lines, g.add("program"))
+}
+
+// visitLineStmt draws the statement of the line, a line is not a node of
+// its own
+func (g *astGraph) visitLineStmt(l *Line) {
+	l.Stmt.accept(g)
+}
+
+func (g *astGraph) visitAssignmentStmt(a *Assignment) {
+	format := "%s %s"
+	if a.Const {
+		format = "const " + format
+	}
+	g.child(a.Expr, g.add(format, typeName(a.Type), a.Identifier), "")
+}
+
+func (g *astGraph) visitReassignStmt(r *Reassign) {
+	g.child(r.Expr, g.typed(r.Type, "%s %s=", r.Identifier, opSymbols[r.Op]), "")
+}
+
+func (g *astGraph) visitPrintStmt(p *Print) {
+	g.child(p.Expr, g.add("print"), "")
+}
+
+func (g *astGraph) visitResetStmt(r *Reset) {
+	if r.Identifier != "" {
+		g.add("reset %s", r.Identifier)
+		return
+	}
+	g.add("reset")
+}
+
+func (g *astGraph) visitBlockStmt(b *Block) {
+	g.lines(b.Lines, g.add("block"))
+}
+
+func (g *astGraph) visitIfStmt(i *If) {
+	id := g.add("if")
+	g.child(i.Cond, id, "cond")
+	g.child(i.Then, id, "then")
+	if i.Else != nil {
+		g.child(i.Else, id, "else")
+	}
+}
+
+func (g *astGraph) visitWhileStmt(w *While) {
+	id := g.add("while")
+	g.child(w.Cond, id, "cond")
+	g.child(w.Body, id, "body")
+}
+
+func (g *astGraph) visitFuncStmt(fn *Func) {
+	params := make([]string, len(fn.Params))
+	for i, p := range fn.Params {
+		params[i] = typeName(fn.ParamTypes[i]) + " " + p
+	}
+	g.child(fn.Body, g.add("func %s(%s) %s", fn.Name, strings.Join(params, ", "), typeName(fn.Result)), "")
+}
+
+func (g *astGraph) visitReturnStmt(r *Return) {
+	g.child(r.Expr, g.add("return"), "")
+}
+
+func (g *astGraph) visitCallStmt(c *Call) {
+	id := g.typed(c.Type, "call %s", c.Name)
+	for _, a := range c.Args {
+		g.child(a, id, "")
+	}
+}
+
+func (g *astGraph) visitBinary2Stmt(b *Binary2) {
+	if b.Op == NOOP {
+		b.Lhs.accept(g)
+		return
+	}
+	id := g.typed(b.Type, "%s", opSymbols[b.Op])
+	g.child(b.Lhs, id, "")
+	g.child(b.Rhs, id, "")
+}
+
+func (g *astGraph) visitUnaryStmt(u *Unary) {
+	g.child(u.Expr, g.typed(u.Type, "unary %s", opSymbols[u.Op]), "")
+}
+
+// visitCastStmt draws the casts a TypeChecker inserts as widen
+func (g *astGraph) visitCastStmt(c *Cast) {
+	format := "cast"
+	if c.Exact {
+		format = "widen"
+	}
+	g.child(c.Expr, g.typed(c.Type, format), "")
+}
+
+func (g *astGraph) visitIdentifierStmt(i *Identifier) {
+	g.add("identifier %s", i.Val)
+}
+
+func (g *astGraph) visitNumberStmt(n *Number) {
+	g.typed(n.Type, "number %s", n)
+}
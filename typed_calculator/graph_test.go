@@ -0,0 +1,93 @@
+package typedcalculator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGraph(t *testing.T) {
+	node, err := BuildParser().Parse("long l = 2 ** 3 - 1 - 1\nwhile l > 0 { l -= 1 }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewTypeChecker(nil, nil).Run(node); err != nil {
+		t.Fatal(err)
+	}
+
+	//- nests to the left, and the nodes have the types the checker set
+	want := `digraph ast {
+	node [shape=box, fontname=monospace];
+	n0 [label="program"];
+	n1 [label="long l"];
+	n2 [label="- int"];
+	n3 [label="- int"];
+	n4 [label="** int"];
+	n5 [label="number 2 int"];
+	n6 [label="number 3 int"];
+	n7 [label="number 1 int"];
+	n8 [label="number 1 int"];
+	n9 [label="while"];
+	n10 [label="> long"];
+	n11 [label="identifier l"];
+	n12 [label="number 0 int"];
+	n13 [label="block"];
+	n14 [label="l -= long"];
+	n15 [label="number 1 int"];
+	n0 -> n1;
+	n1 -> n2;
+	n2 -> n3;
+	n3 -> n4;
+	n4 -> n5;
+	n4 -> n6;
+	n3 -> n7;
+	n2 -> n8;
+	n0 -> n9;
+	n9 -> n10 [label="cond"];
+	n10 -> n11;
+	n10 -> n12;
+	n9 -> n13 [label="body"];
+	n13 -> n14;
+	n14 -> n15;
+}
+`
+	var out strings.Builder
+	DumpDOT(&out, node)
+	if out.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out.String())
+	}
+
+	node, err = BuildParser().Parse("func f(int a) bool { return a >= 1 }\nif f(2) then print 1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `flowchart TD
+	n0["program"]
+	n1["func f(int a) bool"]
+	n2["block"]
+	n3["return"]
+	n4["#gt;="]
+	n5["identifier a"]
+	n6["number 1 int"]
+	n7["if"]
+	n8["call f"]
+	n9["number 2 int"]
+	n10["print"]
+	n11["number 1.5 double"]
+	n0 --> n1
+	n1 --> n2
+	n2 --> n3
+	n3 --> n4
+	n4 --> n5
+	n4 --> n6
+	n0 --> n7
+	n7 -->|"cond"| n8
+	n8 --> n9
+	n7 -->|"then"| n10
+	n10 --> n11
+`
+	out.Reset()
+	DumpMermaid(&out, node)
+	if out.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out.String())
+	}
+}
@@ -0,0 +1,472 @@
+package typedcalculator
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// String formats the value of n. A Number holds its value in the field of
+// its type: Num for an INT, Long for a LONG, Big for a BIGINT, Flt for a
+// FLOAT, Dbl for a DOUBLE, Dec for a DECIMAL and Bool for a BOOL. INT and
+// FLOAT are 32 bit, LONG and DOUBLE 64 bit, BIGINT and DECIMAL are exact.
+func (n Number) String() string {
+	switch n.Type {
+	case INT:
+		return strconv.FormatInt(int64(n.Num), 10)
+	case LONG:
+		return strconv.FormatInt(n.Long, 10)
+	case BIGINT:
+		return n.Big.String()
+	case FLOAT:
+		return strconv.FormatFloat(float64(n.Flt), 'g', -1, 32)
+	case DOUBLE:
+		return strconv.FormatFloat(n.Dbl, 'g', -1, 64)
+	case DECIMAL:
+		return decimalString(n.Dec)
+	case BOOL:
+		return strconv.FormatBool(n.Bool)
+	}
+	return "<no value>"
+}
+
+// intNum returns v as an INT, or an error when it does not fit in 32 bits
+func intNum(v int64) (Number, error) {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return Number{}, fmt.Errorf("int overflow, %d does not fit in 32 bits", v)
+	}
+	return Number{Type: INT, Num: int32(v)}, nil
+}
+
+var errLongOverflow = fmt.Errorf("long overflow")
+
+func addLong(a, b int64) (int64, error) {
+	s := a + b
+	if (a > 0 && b > 0 && s < 0) || (a < 0 && b < 0 && s >= 0) {
+		return 0, errLongOverflow
+	}
+	return s, nil
+}
+
+func subLong(a, b int64) (int64, error) {
+	s := a - b
+	if (a >= 0 && b < 0 && s < 0) || (a < 0 && b > 0 && s >= 0) {
+		return 0, errLongOverflow
+	}
+	return s, nil
+}
+
+func mulLong(a, b int64) (int64, error) {
+	if a == 0 || b == 0 {
+		return 0, nil
+	}
+	p := a * b
+	if p/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, errLongOverflow
+	}
+	return p, nil
+}
+
+func divLong(a, b int64) (int64, error) {
+	if b == 0 {
+		return 0, fmt.Errorf("divide by zero")
+	}
+	if a == math.MinInt64 && b == -1 {
+		return 0, errLongOverflow
+	}
+	return a / b, nil
+}
+
+// powLong raises x to y by squaring. A negative power truncates towards
+// zero like a division does.
+func powLong(x, y int64) (int64, error) {
+	if y < 0 {
+		switch x {
+		case 0:
+			return 0, fmt.Errorf("divide by zero")
+		case 1:
+			return 1, nil
+		case -1:
+			if y%2 == 0 {
+				return 1, nil
+			}
+			return -1, nil
+		}
+		return 0, nil
+	}
+
+	var err error
+	res := int64(1)
+	for y > 0 {
+		if y&1 == 1 {
+			if res, err = mulLong(res, x); err != nil {
+				return 0, err
+			}
+		}
+		y >>= 1
+		if y > 0 {
+			if x, err = mulLong(x, x); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// integerOp applies op to two INTs or two LONGs. INTs are combined as
+// LONGs and checked to still fit in 32 bits.
+func integerOp(op func(a, b int64) (int64, error), lhs Number, rhs Number) (Number, error) {
+	if lhs.Type == INT {
+		v, err := op(int64(lhs.Num), int64(rhs.Num))
+		if err == errLongOverflow {
+			return Number{}, fmt.Errorf("int overflow")
+		}
+		if err != nil {
+			return Number{}, err
+		}
+		return intNum(v)
+	}
+	v, err := op(lhs.Long, rhs.Long)
+	if err != nil {
+		return Number{}, err
+	}
+	return Number{Type: LONG, Long: v}, nil
+}
+
+// floatOp applies op to two FLOATs or two DOUBLEs, FLOATs are rounded to
+// 32 bits again
+func floatOp(op func(a, b float64) float64, lhs Number, rhs Number) Number {
+	if lhs.Type == FLOAT {
+		return Number{Type: FLOAT, Flt: float32(op(float64(lhs.Flt), float64(rhs.Flt)))}
+	}
+	return Number{Type: DOUBLE, Dbl: op(lhs.Dbl, rhs.Dbl)}
+}
+
+// arithOps is one operator on each representation of a number
+type arithOps struct {
+	ints   func(a, b int64) (int64, error)
+	floats func(a, b float64) float64
+	bigs   func(a, b *big.Int) (*big.Int, error)
+	decs   func(a, b *big.Rat) (*big.Rat, error)
+}
+
+// arith applies an operator to two numbers of the same type
+func arith(lhs Number, rhs Number, ops arithOps) (Number, error) {
+	if lhs.Type != rhs.Type {
+		return Number{}, fmt.Errorf("lhs type %s is not equal to rhs type %s", TypeStringMap[lhs.Type], TypeStringMap[rhs.Type])
+	}
+	switch lhs.Type {
+	case INT, LONG:
+		return integerOp(ops.ints, lhs, rhs)
+	case FLOAT, DOUBLE:
+		return floatOp(ops.floats, lhs, rhs), nil
+	case BIGINT:
+		v, err := ops.bigs(lhs.Big, rhs.Big)
+		if err != nil {
+			return Number{}, err
+		}
+		return Number{Type: BIGINT, Big: v}, nil
+	case DECIMAL:
+		v, err := ops.decs(lhs.Dec, rhs.Dec)
+		if err != nil {
+			return Number{}, err
+		}
+		return Number{Type: DECIMAL, Dec: v}, nil
+	}
+	return Number{}, fmt.Errorf("unknown type %s", TypeStringMap[lhs.Type])
+}
+
+// at this point both Numbers should have the same type
+func AddNums(lhs Number, rhs Number) (Number, error) {
+	return arith(lhs, rhs, arithOps{addLong, func(a, b float64) float64 { return a + b }, addBig, addRat})
+}
+
+func SubNums(lhs Number, rhs Number) (Number, error) {
+	return arith(lhs, rhs, arithOps{subLong, func(a, b float64) float64 { return a - b }, subBig, subRat})
+}
+
+func MultiplyNums(lhs Number, rhs Number) (Number, error) {
+	return arith(lhs, rhs, arithOps{mulLong, func(a, b float64) float64 { return a * b }, mulBig, mulRat})
+}
+
+// DivideNums rounds a decimal quotient to DefaultDecimalPlaces
+func DivideNums(lhs Number, rhs Number) (Number, error) {
+	return divideNums(lhs, rhs, DefaultDecimalPlaces)
+}
+
+func divideNums(lhs Number, rhs Number, places int) (Number, error) {
+	divDec := func(a, b *big.Rat) (*big.Rat, error) { return divRat(a, b, places) }
+	return arith(lhs, rhs, arithOps{divLong, func(a, b float64) float64 { return a / b }, divBig, divDec})
+}
+
+// PowerNums rounds a decimal raised to a negative power to
+// DefaultDecimalPlaces
+func PowerNums(lhs Number, rhs Number) (Number, error) {
+	return powerNums(lhs, rhs, DefaultDecimalPlaces)
+}
+
+func powerNums(lhs Number, rhs Number, places int) (Number, error) {
+	powDec := func(x, y *big.Rat) (*big.Rat, error) { return powRat(x, y, places) }
+	return arith(lhs, rhs, arithOps{powLong, math.Pow, powBig, powDec})
+}
+
+// NegateNum returns -n
+func NegateNum(n Number) (Number, error) {
+	return SubNums(changeType(Number{Type: INT}, n.Type), n)
+}
+
+// CompareNums compares two values of the same type with a comparison
+// operator, a BOOL can only be compared for equality
+func CompareNums(op Op, lhs Number, rhs Number) (Number, error) {
+	if lhs.Type != rhs.Type {
+		return Number{}, fmt.Errorf("lhs type %s is not equal to rhs type %s", TypeStringMap[lhs.Type], TypeStringMap[rhs.Type])
+	}
+	//lt and gt are computed separately so that NaN is neither
+	var lt, gt, eq bool
+	switch lhs.Type {
+	case INT:
+		lt, gt, eq = lhs.Num < rhs.Num, lhs.Num > rhs.Num, lhs.Num == rhs.Num
+	case LONG:
+		lt, gt, eq = lhs.Long < rhs.Long, lhs.Long > rhs.Long, lhs.Long == rhs.Long
+	case FLOAT:
+		lt, gt, eq = lhs.Flt < rhs.Flt, lhs.Flt > rhs.Flt, lhs.Flt == rhs.Flt
+	case DOUBLE:
+		lt, gt, eq = lhs.Dbl < rhs.Dbl, lhs.Dbl > rhs.Dbl, lhs.Dbl == rhs.Dbl
+	case BIGINT:
+		c := lhs.Big.Cmp(rhs.Big)
+		lt, gt, eq = c < 0, c > 0, c == 0
+	case DECIMAL:
+		c := lhs.Dec.Cmp(rhs.Dec)
+		lt, gt, eq = c < 0, c > 0, c == 0
+	case BOOL:
+		if op != EQ && op != NEQ {
+			return Number{}, fmt.Errorf("cannot order bools")
+		}
+		eq = lhs.Bool == rhs.Bool
+	default:
+		return Number{}, fmt.Errorf("unknown type %s", TypeStringMap[lhs.Type])
+	}
+
+	res := Number{Type: BOOL}
+	switch op {
+	case EQ:
+		res.Bool = eq
+	case NEQ:
+		res.Bool = !eq
+	case LT:
+		res.Bool = lt
+	case GT:
+		res.Bool = gt
+	case LTE:
+		res.Bool = lt || eq
+	case GTE:
+		res.Bool = gt || eq
+	default:
+		return Number{}, fmt.Errorf("%s is not a comparison", OpStringMap[op])
+	}
+	return res, nil
+}
+
+// TYPE CHECKING
+
+// inferType returns the type both operands of a binary operator are changed
+// to. A number that is not fixed takes the type of a fixed one when it can,
+// otherwise the narrower operand is widened.
+func inferType(n1 Number, n2 Number) (Type, error) {
+	if n1.Type == NOTYPE || n2.Type == NOTYPE {
+		return NOTYPE, fmt.Errorf("unmatched types %s and %s", TypeStringMap[n1.Type], TypeStringMap[n2.Type])
+	}
+	if n1.Fixed && !n2.Fixed {
+		if t, err := convertible(n2, n1.Type); err == nil {
+			return t, nil
+		}
+	} else if n2.Fixed && !n1.Fixed {
+		if t, err := convertible(n1, n2.Type); err == nil {
+			return t, nil
+		}
+	}
+	return maxType(n1.Type, n2.Type), nil
+}
+
+// convertible returns t when n can be changed to type t. A number widens to
+// any later type, apart from a BIGINT, which is exact and only widens to a
+// DECIMAL. A value that is not fixed to a variable's type, such as a
+// decimal literal, may also be rounded from a DOUBLE to a FLOAT. A BOOL is
+// only convertible to a BOOL.
+func convertible(n Number, t Type) (Type, error) {
+	if (n.Type == BOOL) != (t == BOOL) {
+		return NOTYPE, fmt.Errorf("cannot change %s to %s", TypeStringMap[n.Type], TypeStringMap[t])
+	}
+	if n.Type <= t && !(n.Type == BIGINT && isFloat(t)) {
+		return t, nil
+	}
+	if !n.Fixed && n.Type == DOUBLE && t == FLOAT {
+		return t, nil
+	}
+	return NOTYPE, fmt.Errorf("cannot change %s to %s", TypeStringMap[n.Type], TypeStringMap[t])
+}
+
+// maxType returns the type both t1 and t2 widen to. A BIGINT and a FLOAT or
+// a DOUBLE both widen to a DECIMAL, so the bigint is not rounded.
+func maxType(t1 Type, t2 Type) Type {
+	if (t1 == BIGINT && isFloat(t2)) || (t2 == BIGINT && isFloat(t1)) {
+		return DECIMAL
+	}
+	if t1 >= t2 {
+		return t1
+	}
+	return t2
+}
+
+// isNumber reports whether t is one of the number types
+func isNumber(t Type) bool {
+	return t >= INT && t <= DECIMAL
+}
+
+// isInteger reports whether t is one of the integer types
+func isInteger(t Type) bool {
+	return t == INT || t == LONG || t == BIGINT
+}
+
+// isFloat reports whether t is one of the floating point types
+func isFloat(t Type) bool {
+	return t == FLOAT || t == DOUBLE
+}
+
+// article returns the type name with its indefinite article, e.g. an int
+func article(t Type) string {
+	if t == INT {
+		return "an int"
+	}
+	return "a " + typeName(t)
+}
+
+// toLong truncates n towards zero
+func toLong(n Number) (int64, error) {
+	switch n.Type {
+	case INT:
+		return int64(n.Num), nil
+	case LONG:
+		return n.Long, nil
+	case BIGINT, DECIMAL:
+		v := bigValue(n)
+		if !v.IsInt64() {
+			return 0, fmt.Errorf("%s does not fit in a long", n)
+		}
+		return v.Int64(), nil
+	}
+	d := math.Trunc(floatValue(n))
+	//-2**63 is a long, 2**63 is not
+	if math.IsNaN(d) || d < math.MinInt64 || d >= -math.MinInt64 {
+		return 0, fmt.Errorf("%s does not fit in a long", n)
+	}
+	return int64(d), nil
+}
+
+// convertNum converts n to type t as a cast does. A floating point number
+// or a decimal is truncated towards zero to become an integer, and a number
+// is rounded to the nearest float or double. A value outside the range of t
+// is an error, and a BOOL cannot be converted.
+func convertNum(n Number, t Type) (Number, error) {
+	if !isNumber(n.Type) {
+		return Number{}, fmt.Errorf("cannot convert %s to %s", n, TypeStringMap[t])
+	}
+	switch t {
+	case INT:
+		v, err := toLong(n)
+		if err != nil || v < math.MinInt32 || v > math.MaxInt32 {
+			return Number{}, fmt.Errorf("%s does not fit in an int", n)
+		}
+		return Number{Type: INT, Num: int32(v)}, nil
+	case LONG:
+		v, err := toLong(n)
+		if err != nil {
+			return Number{}, err
+		}
+		return Number{Type: LONG, Long: v}, nil
+	case BIGINT:
+		if d := floatValue(n); math.IsNaN(d) || isInf(n) {
+			return Number{}, fmt.Errorf("%s does not fit in a bigint", n)
+		}
+		return Number{Type: BIGINT, Big: bigValue(n)}, nil
+	case FLOAT:
+		f := float32(floatValue(n))
+		if math.IsInf(float64(f), 0) && !isInf(n) {
+			return Number{}, fmt.Errorf("%s does not fit in a float", n)
+		}
+		return Number{Type: FLOAT, Flt: f}, nil
+	case DOUBLE:
+		d := floatValue(n)
+		if math.IsInf(d, 0) && !isInf(n) {
+			return Number{}, fmt.Errorf("%s does not fit in a double", n)
+		}
+		return Number{Type: DOUBLE, Dbl: d}, nil
+	case DECIMAL:
+		r := ratValue(n)
+		if r == nil {
+			return Number{}, fmt.Errorf("%s does not fit in a decimal", n)
+		}
+		return Number{Type: DECIMAL, Dec: r}, nil
+	}
+	return Number{}, fmt.Errorf("cannot convert %s to %s", n, TypeStringMap[t])
+}
+
+// exactly converts n to type t when t can hold its value without rounding
+// or truncating it
+func exactly(n Number, t Type) (Number, error) {
+	res, err := convertNum(n, t)
+	if err == nil {
+		back, err := convertNum(res, n.Type)
+		if err == nil && back.String() == n.String() {
+			return res, nil
+		}
+	}
+	return Number{}, fmt.Errorf("%s is not exactly representable as %s", n, article(t))
+}
+
+// isInf reports whether n is a floating point infinity
+func isInf(n Number) bool {
+	return (n.Type == FLOAT || n.Type == DOUBLE) && math.IsInf(floatValue(n), 0)
+}
+
+// truncValue truncates n towards zero, wrapping around when it does not
+// fit in 64 bits
+func truncValue(n Number) int64 {
+	switch n.Type {
+	case INT:
+		return int64(n.Num)
+	case LONG:
+		return n.Long
+	case FLOAT:
+		return int64(n.Flt)
+	case DOUBLE:
+		return int64(n.Dbl)
+	}
+	return bigValue(n).Int64()
+}
+
+// changeType converts n1 to type t, keeping whether it is fixed. A NaN or
+// an infinity changed to a DECIMAL has no value, its Dec is nil.
+func changeType(n1 Number, t Type) Number {
+	if n1.Type == t {
+		return n1
+	}
+
+	res := Number{Type: t, Fixed: n1.Fixed, Pos: n1.Pos}
+	switch t {
+	case INT:
+		res.Num = int32(truncValue(n1))
+	case LONG:
+		res.Long = truncValue(n1)
+	case BIGINT:
+		res.Big = bigValue(n1)
+	case FLOAT:
+		res.Flt = float32(floatValue(n1))
+	case DOUBLE:
+		res.Dbl = floatValue(n1)
+	case DECIMAL:
+		res.Dec = ratValue(n1)
+	}
+	return res
+}
@@ -0,0 +1,249 @@
+package typedcalculator
+
+// optimizer rewrites a type checked program into a simpler one that runs
+// the same way. It relies on the types the checker annotated: a folded
+// operator applies its operands the way the evaluator would, after changing
+// them to the annotated Type, so the types inferType chose are kept.
+type optimizer struct {
+	res    Node //the rewritten node
+	places int  //the digits a decimal division is rounded to
+}
+
+// Optimize simplifies the program rooted at node, which must have been
+// checked by a TypeChecker. It folds operators, casts and conditions whose
+// operands are literals, removes the NOOP wrapper of a single operand, and
+// drops the operand of x*1, x/1, x+0 and x-0 when it is an int literal.
+// An operator that would fail, e.g. 1/0, is left to fail when the program
+// runs. Like the checker, it rewrites the tree in place and returns the new
+// root. A decimal division is rounded to DefaultDecimalPlaces.
+func Optimize(node Node) Node {
+	return (&optimizer{places: DefaultDecimalPlaces}).optimize(node)
+}
+
+func (o *optimizer) optimize(n Node) Node {
+	n.accept(o)
+	return o.res
+}
+
+// literal returns the value of a literal or of a folded operator
+func literal(n Node) (*Number, bool) {
+	num, ok := n.(*Number)
+	return num, ok
+}
+
+// folded returns the value of an operator as the literal that replaces it
+func folded(n Number, pos Span) Node {
+	n.Pos = pos
+	return &n
+}
+
+func (o *optimizer) visitProgramStmt(f *Program) {
+	for _, l := range f.Lines {
+		l.accept(o)
+	}
+	o.res = f
+}
+
+func (o *optimizer) visitLineStmt(f *Line) {
+	f.Stmt = o.optimize(f.Stmt)
+	o.res = f
+}
+
+func (o *optimizer) visitAssignmentStmt(f *Assignment) {
+	f.Expr = o.optimize(f.Expr)
+	o.res = f
+}
+
+func (o *optimizer) visitReassignStmt(f *Reassign) {
+	f.Expr = o.optimize(f.Expr)
+	o.res = f
+}
+
+func (o *optimizer) visitPrintStmt(f *Print) {
+	f.Expr = o.optimize(f.Expr)
+	o.res = f
+}
+
+func (o *optimizer) visitResetStmt(f *Reset) {
+	o.res = f
+}
+
+func (o *optimizer) visitBlockStmt(f *Block) {
+	for _, l := range f.Lines {
+		l.accept(o)
+	}
+	o.res = f
+}
+
+// visitIfStmt replaces an if with a literal condition by the branch it
+// runs, in a block as the branch has a scope of its own
+func (o *optimizer) visitIfStmt(f *If) {
+	f.Cond = o.optimize(f.Cond)
+	f.Then = o.optimize(f.Then)
+	if f.Else != nil {
+		f.Else = o.optimize(f.Else)
+	}
+	o.res = f
+
+	cond, ok := literal(f.Cond)
+	if !ok {
+		return
+	}
+	branch := f.Else
+	if cond.Bool {
+		branch = f.Then
+	}
+	block := &Block{Pos: f.Pos}
+	if branch != nil {
+		block.Lines = []*Line{{Stmt: branch, Pos: branch.position()}}
+	}
+	o.res = block
+}
+
+func (o *optimizer) visitWhileStmt(f *While) {
+	f.Cond = o.optimize(f.Cond)
+	f.Body = o.optimize(f.Body)
+	o.res = f
+}
+
+func (o *optimizer) visitFuncStmt(f *Func) {
+	f.Body.accept(o)
+	o.res = f
+}
+
+func (o *optimizer) visitReturnStmt(f *Return) {
+	f.Expr = o.optimize(f.Expr)
+	o.res = f
+}
+
+func (o *optimizer) visitCallStmt(f *Call) {
+	for i, a := range f.Args {
+		f.Args[i] = o.optimize(a)
+	}
+	o.res = f
+}
+
+func (o *optimizer) visitBinary2Stmt(f *Binary2) {
+	operand := f.Lhs.position()
+	f.Lhs = o.optimize(f.Lhs)
+	o.res = f
+	if f.Op == NOOP {
+		//the operand takes the place of the wrapper when nothing tells
+		//them apart, a group keeps its parentheses in its span
+		if lhs, ok := literal(f.Lhs); ok {
+			o.res = folded(*lhs, f.Pos)
+		} else if operand == f.Pos {
+			o.res = f.Lhs
+		}
+		return
+	}
+	f.Rhs = o.optimize(f.Rhs)
+	o.res = f
+	lhs, lok := literal(f.Lhs)
+	rhs, rok := literal(f.Rhs)
+
+	switch f.Op {
+	case AND, OR:
+		//a literal lhs either decides the result or leaves it to the rhs
+		if lok {
+			if lhs.Bool == (f.Op == OR) {
+				o.res = folded(*lhs, f.Pos)
+			} else {
+				o.res = f.Rhs
+			}
+		}
+		return
+	}
+
+	if lok && rok {
+		if res, ok := foldOp(f.Op, *lhs, *rhs, f.Type, o.places); ok {
+			o.res = folded(res, f.Pos)
+		}
+		return
+	}
+	if f.Type == FLOAT || f.Type == DOUBLE {
+		//-0 + 0 is 0, and a NaN that cannot become a decimal fails at the
+		//position of the operator
+		return
+	}
+	switch {
+	case rok && f.Op == DIVIDE && f.Type == DECIMAL:
+		//a decimal quotient is rounded to the decimal places, x / 1 is not x
+	case rok && identityOf(f.Op, *rhs):
+		o.res = f.Lhs
+	case lok && (f.Op == PLUS || f.Op == MULTIPLY) && identityOf(f.Op, *lhs):
+		o.res = f.Rhs
+	}
+}
+
+// identityOf reports whether n is an int literal that leaves the other
+// operand of op unchanged. An int literal takes the type of the other
+// operand, or is widened to it, so dropping it keeps the type of the
+// operator.
+func identityOf(op Op, n Number) bool {
+	if n.Type != INT {
+		return false
+	}
+	switch op {
+	case PLUS, MINUS:
+		return n.Num == 0
+	case MULTIPLY, DIVIDE:
+		return n.Num == 1
+	}
+	return false
+}
+
+// foldOp applies op to two literals changed to type t, it fails when the
+// evaluator would
+func foldOp(op Op, lhs Number, rhs Number, t Type, places int) (Number, bool) {
+	l, r := changeType(lhs, t), changeType(rhs, t)
+	if t == DECIMAL && (l.Dec == nil || r.Dec == nil) {
+		return Number{}, false
+	}
+	res, err := applyOp(op, l, r, places)
+	return res, err == nil
+}
+
+func (o *optimizer) visitUnaryStmt(f *Unary) {
+	f.Expr = o.optimize(f.Expr)
+	o.res = f
+	n, ok := literal(f.Expr)
+	if !ok {
+		return
+	}
+	switch f.Op {
+	case NOT:
+		o.res = folded(Number{Type: BOOL, Bool: !n.Bool}, f.Pos)
+	case MINUS:
+		if res, err := NegateNum(*n); err == nil {
+			o.res = folded(res, f.Pos)
+		}
+	}
+}
+
+func (o *optimizer) visitCastStmt(f *Cast) {
+	f.Expr = o.optimize(f.Expr)
+	o.res = f
+	n, ok := literal(f.Expr)
+	if !ok {
+		return
+	}
+	var res Number
+	var err error
+	if f.Exact {
+		res, err = exactly(*n, f.Type)
+	} else {
+		res, err = convertNum(*n, f.Type)
+	}
+	if err == nil {
+		o.res = folded(res, f.Pos)
+	}
+}
+
+func (o *optimizer) visitIdentifierStmt(f *Identifier) {
+	o.res = f
+}
+
+func (o *optimizer) visitNumberStmt(f *Number) {
+	o.res = f
+}
@@ -0,0 +1,119 @@
+package typedcalculator
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// nodes counts the ast nodes reachable from v
+func nodes(v reflect.Value) int {
+	count := 0
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return 0
+		}
+		if _, ok := v.Interface().(Node); ok {
+			count++
+		}
+		if v.Elem().Kind() == reflect.Struct {
+			count += nodes(v.Elem())
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			count += nodes(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			count += nodes(v.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			count += nodes(v.Index(i))
+		}
+	}
+	return count
+}
+
+func TestOptimize(t *testing.T) {
+	//each program prints the same and fails the same way when optimized
+	programs := []string{
+		"print 1 + 2 * 3 - 4; print 2 ** 10; print -(3 - 5)",
+		"int x = 6; print x * 1; print 1 * x; print x + 0 - 0; print x / 1",
+		"long l = 5; print l * 1; print (l + 0) * 2; print l + 2 * 3",
+		"float f = -0.0; print f + 0; print f * 1; double d = 2; print d * 1.5 + 0",
+		"decimal d = 0.1; print d + 0.2; print d * 1; print (0.1 + 0.2) + d",
+		"decimal d = 1; print d + 1.0 / 0.0",
+		"decimal d = 0.0000000000000001; decimal e = d * d; print e / 1",
+		"decimal d = 0.1 + 0.2 * 3; print d; print d + 1.0 / 3; print d < 0.7 + 0.0",
+		"float f = 1; print f + 0.1; print float(0.1) + f",
+		"bigint b = 3; print b * 1 + 9223372036854775807 * 2",
+		"print int(2.75) + 1; int x = 3.0; print x; print long(2) ** 40",
+		"print 1 / 0",
+		"print 2147483647 + 1",
+		"print true && 1 < 2; print false || !true; print 1 > 2 || 2 > 1",
+		"int i = 0; print i != 0 && 10 / i > 1; print true || 1 / i > 0",
+		"if 1 < 2 then print 1 else print 2; if 2 < 1 then print 3; if false then { print 4 } else { print 5 }",
+		"int x = 1; if true then int x = 2; print x",
+		"while 1 > 2 { print 1 }; int i = 0; while i < 3 + 0 { i += 1 * 1 }; print i",
+		"func f(int a) long { return a * 1 + 2 * 3 }; print f(2 + 2); print f(3) * 1",
+		"func g(int n) int { if true then { return n } else { return 0 } }; print g(7)",
+	}
+
+	for _, pr := range programs {
+		want, got := CreateEvaluator(false), CreateEvaluator(false)
+		want.optimize = false
+		werr, gerr := want.Run(pr), got.Run(pr)
+		if !reflect.DeepEqual(werr, gerr) || fmt.Sprint(want.PrintVals) != fmt.Sprint(got.PrintVals) {
+			t.Errorf("expected %q to print %v, %v when optimized got %v, %v", pr, want.PrintVals, werr, got.PrintVals, gerr)
+		}
+	}
+
+	//a decimal divided by 1 is still rounded to the places of the evaluator
+	pr := "decimal d = 0.15; print d * d / 1"
+	want, got := CreateEvaluator(false), CreateEvaluator(false)
+	want.optimize = false
+	want.DecimalPlaces, got.DecimalPlaces = 2, 2
+	if want.Run(pr) != nil || got.Run(pr) != nil || fmt.Sprint(got.PrintVals) != fmt.Sprint(want.PrintVals) || fmt.Sprint(got.PrintVals) != "[0.02]" {
+		t.Errorf("expected %q to print [0.02] when optimized got %v and %v", pr, got.PrintVals, want.PrintVals)
+	}
+
+	folded := map[string]Number{
+		"print 1 + 2 * 3":        Number{Type: INT, Num: 7},
+		"print 2.5 * 2":          Number{Type: DOUBLE, Dbl: 5},
+		"print -(1 + 1) < 0":     Number{Type: BOOL, Bool: true},
+		"print long(3) ** 2":     Number{Type: LONG, Long: 9},
+		"print (1 < 2) && !true": Number{Type: BOOL, Bool: false},
+	}
+	for pr, want := range folded {
+		node, err := BuildParser().Parse(pr)
+		if err == nil {
+			err = NewTypeChecker(nil, nil).Run(node)
+		}
+		if err != nil {
+			t.Fatalf("could not check %q: %v", pr, err)
+		}
+		before := nodes(reflect.ValueOf(node))
+		Optimize(node)
+		got, ok := node.(*Program).Lines[0].Stmt.(*Print).Expr.(*Number)
+		if !ok || !NumberSliceEqual([]Number{*got}, []Number{want}) {
+			t.Errorf("expected %q to fold to %v got %v", pr, want, node.(*Program).Lines[0].Stmt.(*Print).Expr)
+		}
+		if after := nodes(reflect.ValueOf(node)); after >= before {
+			t.Errorf("expected %q to have fewer nodes than %d got %d", pr, before, after)
+		}
+	}
+
+	//x * 1.0 is a double, x * 1 is x
+	node, _ := BuildParser().Parse("int x = 2; print x * 1.0; print x * 1")
+	NewTypeChecker(nil, nil).Run(node)
+	Optimize(node)
+	lines := node.(*Program).Lines
+	if _, ok := lines[1].Stmt.(*Print).Expr.(*Binary2); !ok {
+		t.Errorf("expected x * 1.0 to be kept")
+	}
+	if _, ok := lines[2].Stmt.(*Print).Expr.(*Identifier); !ok {
+		t.Errorf("expected x * 1 to become x")
+	}
+}
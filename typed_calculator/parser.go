@@ -1,146 +1,545 @@
 package typedcalculator
 
-import "fmt"
-import "strconv"
+import (
+	"fmt"
+	"io"
+	"lexer"
+	"math/big"
+	"strconv"
+	"strings"
+)
 
 type Parser struct {
-	Lexer        *Lexer
-	CurrentToken *Token
+	Lexer        *lexer.Lexer
+	CurrentToken *lexer.Token
+	prevEnd      Position //end of the last matched token
+	depth        int      //the number of blocks being parsed
+	funcs        int      //the number of function bodies being parsed
+	errors       ErrorList
 }
 
-func BuildParser() *Parser {
-	regexMap := [][2]string{
-		{`set`, "SET"},
-		{`if`, "IF"},
-		{`then`, "THEN"},
-		{`else`, "ELSE"},
-		{`func`, "FUNC"},
-		{`print`, "PRINT"},
-		{`reset`, "RESET"},
-		{`int`, "TYPE"},
-		{`float`, "TYPE"},
-		{`\n`, "NEWLINE"},
-		{`\d*\.\d+`, "DECIMAL"},
-		{`\d+`, "NUMBER"},
-		{`[a-zA-Z_]\w*`, "IDENTIFIER"},
-		{`\*\*`, "**"},
-		{`!=`, "!="},
-		{`==`, "=="},
-		{`>=`, ">="},
-		{`<=`, "<="},
-		{`>>`, ">>"},
-		{`<<`, "<<"},
-		{`&`, "&"},
-		{`\^`, "^"},
-		{`\|`, "|"},
-		{`<`, "<"},
-		{`>`, ">"},
-		{`\+`, "+"},
-		{`\-`, "-"},
-		{`\*`, "*"},
-		{`\/`, "/"},
-		{`\(`, "("},
-		{`\)`, ")"},
-		{`\{`, "{"},
-		{`\}`, "}"},
-		{`=`, "="},
-		{`;`, ";"},
-		{`,`, ","},
-	}
+// token kinds produced by typedRules
+const (
+	tokSet lexer.Kind = lexer.FirstKind + iota
+	tokIf
+	tokThen
+	tokElse
+	tokWhile
+	tokFunc
+	tokReturn
+	tokPrint
+	tokReset
+	tokConst
+	tokType
+	tokBoolean
+	tokDecimal
+	tokNumber
+	tokIdentifier
+	tokPower
+	tokNeq
+	tokEq
+	tokGte
+	tokLte
+	tokRshift
+	tokLshift
+	tokAnd
+	tokOr
+	tokNot
+	tokAmp
+	tokCaret
+	tokPipe
+	tokLt
+	tokGt
+	tokPlus
+	tokMinus
+	tokStar
+	tokSlash
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokAssign
+	tokSemicolon
+	tokComma
+	tokPlusAssign
+	tokMinusAssign
+	tokStarAssign
+	tokSlashAssign
+)
 
+// typedRules lists the tokens of the language, keywords before identifiers
+var typedRules = lexer.MustRuleSet([]lexer.Rule{
+	{Pattern: `set`, Kind: tokSet, Name: "SET"},
+	{Pattern: `if`, Kind: tokIf, Name: "IF"},
+	{Pattern: `then`, Kind: tokThen, Name: "THEN"},
+	{Pattern: `else`, Kind: tokElse, Name: "ELSE"},
+	{Pattern: `while`, Kind: tokWhile, Name: "WHILE"},
+	{Pattern: `func`, Kind: tokFunc, Name: "FUNC"},
+	{Pattern: `return`, Kind: tokReturn, Name: "RETURN"},
+	{Pattern: `print`, Kind: tokPrint, Name: "PRINT"},
+	{Pattern: `reset`, Kind: tokReset, Name: "RESET"},
+	{Pattern: `const`, Kind: tokConst, Name: "CONST"},
+	{Pattern: `int`, Kind: tokType, Name: "TYPE"},
+	{Pattern: `long`, Kind: tokType, Name: "TYPE"},
+	{Pattern: `float`, Kind: tokType, Name: "TYPE"},
+	{Pattern: `double`, Kind: tokType, Name: "TYPE"},
+	{Pattern: `bigint`, Kind: tokType, Name: "TYPE"},
+	{Pattern: `decimal`, Kind: tokType, Name: "TYPE"},
+	{Pattern: `bool`, Kind: tokType, Name: "TYPE"},
+	{Pattern: `true`, Kind: tokBoolean, Name: "BOOLEAN"},
+	{Pattern: `false`, Kind: tokBoolean, Name: "BOOLEAN"},
+	{Pattern: `\d*\.\d+`, Kind: tokDecimal, Name: "DECIMAL"},
+	{Pattern: `\d+`, Kind: tokNumber, Name: "NUMBER"},
+	{Pattern: `[a-zA-Z_]\w*`, Kind: tokIdentifier, Name: "IDENTIFIER"},
+	{Pattern: `\*\*`, Kind: tokPower, Name: "**"},
+	{Pattern: `!=`, Kind: tokNeq, Name: "!="},
+	{Pattern: `==`, Kind: tokEq, Name: "=="},
+	{Pattern: `>=`, Kind: tokGte, Name: ">="},
+	{Pattern: `<=`, Kind: tokLte, Name: "<="},
+	{Pattern: `>>`, Kind: tokRshift, Name: ">>"},
+	{Pattern: `<<`, Kind: tokLshift, Name: "<<"},
+	{Pattern: `&&`, Kind: tokAnd, Name: "&&"},
+	{Pattern: `\|\|`, Kind: tokOr, Name: "||"},
+	{Pattern: `!`, Kind: tokNot, Name: "!"},
+	{Pattern: `&`, Kind: tokAmp, Name: "&"},
+	{Pattern: `\^`, Kind: tokCaret, Name: "^"},
+	{Pattern: `\|`, Kind: tokPipe, Name: "|"},
+	{Pattern: `<`, Kind: tokLt, Name: "<"},
+	{Pattern: `>`, Kind: tokGt, Name: ">"},
+	{Pattern: `\+`, Kind: tokPlus, Name: "+"},
+	{Pattern: `\-`, Kind: tokMinus, Name: "-"},
+	{Pattern: `\*`, Kind: tokStar, Name: "*"},
+	{Pattern: `\/`, Kind: tokSlash, Name: "/"},
+	{Pattern: `\(`, Kind: tokLParen, Name: "("},
+	{Pattern: `\)`, Kind: tokRParen, Name: ")"},
+	{Pattern: `\{`, Kind: tokLBrace, Name: "{"},
+	{Pattern: `\}`, Kind: tokRBrace, Name: "}"},
+	{Pattern: `=`, Kind: tokAssign, Name: "="},
+	{Pattern: `\+=`, Kind: tokPlusAssign, Name: "+="},
+	{Pattern: `\-=`, Kind: tokMinusAssign, Name: "-="},
+	{Pattern: `\*=`, Kind: tokStarAssign, Name: "*="},
+	{Pattern: `\/=`, Kind: tokSlashAssign, Name: "/="},
+	{Pattern: `;`, Kind: tokSemicolon, Name: ";"},
+	{Pattern: `,`, Kind: tokComma, Name: ","},
+}, commentConfig())
+
+// commentConfig skips # and // line comments and /* */ block comments
+func commentConfig() lexer.Config {
+	cfg := lexer.DefaultConfig()
+	cfg.LineComments = []string{"#", "//"}
+	cfg.BlockComments = [][2]string{{"/*", "*/"}}
+	return cfg
+}
+
+func BuildParser() *Parser {
 	p := &Parser{}
-	p.Lexer = CreateLexer(regexMap, true)
+	p.Lexer = lexer.New(typedRules, nil)
 	return p
 }
 
 func (p *Parser) getNextToken() {
-	var err error
-	p.CurrentToken, err = p.Lexer.Token()
+	tok, err := p.Lexer.Token()
 	if err != nil {
-		panic(err)
+		pos := p.Lexer.Position()
+		if lerr, ok := err.(*lexer.LexError); ok {
+			pos = lerr.Pos
+		}
+		if err == p.Lexer.Err() {
+			//the input ends where it could not be read
+			p.CurrentToken = &lexer.Token{Kind: lexer.EOF, Pos: pos, End: pos}
+		}
+		panic(&SyntaxError{Pos: Span{Start: pos, End: pos}, Msg: err.Error()})
+	}
+	if p.CurrentToken != nil {
+		p.prevEnd = p.CurrentToken.End
 	}
+	p.CurrentToken = tok
+}
+
+// span returns the span from start to the end of the last matched token
+func (p *Parser) span(start Position) Span {
+	return Span{Start: start, End: p.prevEnd}
 }
 
-func (p *Parser) matchMultipleTokens(ts ...string) string {
+// unexpected reports that the current token is not one of the kinds ks
+func (p *Parser) unexpected(ks ...lexer.Kind) *SyntaxError {
+	ts := make([]string, len(ks))
+	for i, k := range ks {
+		ts[i] = typedRules.Name(k)
+	}
+	err := &SyntaxError{
+		Pos:      p.CurrentToken.Span(),
+		Expected: ts,
+		Got:      typedRules.Name(p.CurrentToken.Kind),
+	}
+	if len(ts) == 1 {
+		err.Msg = fmt.Sprintf("expected type %s got type %s", ts[0], err.Got)
+	} else {
+		err.Msg = fmt.Sprintf("expected one of the types %+v got type %s", ts, err.Got)
+	}
+	return err
+}
+
+func (p *Parser) matchMultipleTokens(ts ...lexer.Kind) string {
 	for _, t := range ts {
-		if t == p.CurrentToken.Type {
+		if t == p.CurrentToken.Kind {
 			v := p.CurrentToken.Value
 			p.getNextToken()
 			return v
 		}
 	}
-	err := fmt.Errorf("expcted one of the types %+v got type %s", ts, p.CurrentToken.Type)
-	panic(err)
-	return ""
+	panic(p.unexpected(ts...))
 }
 
-func (p *Parser) matchToken(t string) string {
-	if t == p.CurrentToken.Type {
+func (p *Parser) matchToken(t lexer.Kind) string {
+	if t == p.CurrentToken.Kind {
 		v := p.CurrentToken.Value
 		p.getNextToken()
 		return v
 	}
 
-	err := fmt.Errorf("expcted type %s got type %s", t, p.CurrentToken.Type)
-	panic(err)
-	return ""
+	panic(p.unexpected(t))
 }
 
-func (p *Parser) Parse(program string) Node {
-	p.Lexer.Input(program)
-	p.Lexer.Reset()
-	p.CurrentToken, _ = p.Lexer.Token()
+// Parse returns the ast of program. When the program is malformed the error
+// is an ErrorList holding every syntax error, and the ast contains the lines
+// that could be parsed.
+func (p *Parser) Parse(program string) (Node, error) {
+	return p.ParseReader(strings.NewReader(program))
+}
 
-	return p.parseProgram()
+// ParseReader parses the program read from r like Parse. An error reading r
+// is reported as a syntax error where the input stopped.
+func (p *Parser) ParseReader(r io.Reader) (Node, error) {
+	p.Lexer.Reset(r)
+	p.CurrentToken = nil
+	p.prevEnd = Position{}
+	p.depth = 0
+	p.funcs = 0
+	p.errors = nil
+	for p.CurrentToken == nil {
+		p.recover(p.getNextToken)
+	}
+
+	node := p.parseProgram()
+	return node, p.errors.Err()
 }
 
-func (p *Parser) parseProgram() Node {
-	lines := make([]*Line, 0)
-	var n Node
-	for p.CurrentToken.Type != "EOF" {
-		n = p.parseLine()
-		if p.CurrentToken.Type != "EOF" {
-			p.matchToken(";")
+// recover runs parse and records the *SyntaxError it panics with. It
+// reports whether parse succeeded.
+func (p *Parser) recover(parse func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, isSyntax := r.(*SyntaxError)
+			if !isSyntax {
+				panic(r)
+			}
+			p.errors = append(p.errors, err)
+			ok = false
+		}
+	}()
+	parse()
+	return true
+}
+
+// skipToken moves past the current token while synchronizing, the lexer
+// errors in the skipped input are not reported. An error reading the input
+// ends it and is reported.
+func (p *Parser) skipToken() {
+	for {
+		tok, err := p.Lexer.Token()
+		if err == nil {
+			p.prevEnd = p.CurrentToken.End
+			p.CurrentToken = tok
+			return
+		}
+		if err == p.Lexer.Err() {
+			//the read error is returned again, getNextToken reports it
+			p.recover(p.getNextToken)
+			return
 		}
-		lines = append(lines, &Line{Stmt: n})
 	}
+}
+
+// synchronize discards tokens after a syntax error until the end of the
+// line it occurred in. The } that ends a block being parsed is kept to
+// close it.
+func (p *Parser) synchronize() {
+	for {
+		switch p.CurrentToken.Kind {
+		case tokSemicolon, lexer.Newline:
+			p.skipToken()
+			return
+		case tokRBrace:
+			if p.depth == 0 {
+				p.skipToken()
+			}
+			return
+		case lexer.EOF:
+			return
+		}
+		p.skipToken()
+	}
+}
+
+func (p *Parser) parseProgram() Node {
+	start := p.CurrentToken.Pos
+	lines := p.parseLines(lexer.EOF)
 	return &Program{
 		Lines: lines,
+		Pos:   p.span(start),
+	}
+}
+
+// parseLines parses the lines of a program or a block up to the token that
+// ends them, which is not matched. A line with a syntax error is recorded
+// and skipped.
+func (p *Parser) parseLines(end lexer.Kind) []*Line {
+	lines := make([]*Line, 0)
+	for p.CurrentToken.Kind != end && p.CurrentToken.Kind != lexer.EOF {
+		//a line may be empty
+		if p.CurrentToken.Kind == tokSemicolon || p.CurrentToken.Kind == lexer.Newline {
+			p.recover(p.getNextToken)
+			continue
+		}
+
+		ok := p.recover(func() {
+			n := p.parseLine()
+			lines = append(lines, &Line{Stmt: n, Pos: n.position()})
+			if p.CurrentToken.Kind != end && p.CurrentToken.Kind != lexer.EOF {
+				p.matchMultipleTokens(tokSemicolon, lexer.Newline)
+			}
+		})
+		if !ok {
+			p.synchronize()
+		}
 	}
+	return lines
+}
+
+// parseBlock parses the lines between { and }, which may span several lines
+func (p *Parser) parseBlock() Node {
+	start := p.CurrentToken.Pos
+	p.matchToken(tokLBrace)
+	p.depth++
+	lines := p.parseLines(tokRBrace)
+	p.depth--
+	p.matchToken(tokRBrace)
+	return &Block{Lines: lines, Pos: p.span(start)}
 }
 
 func (p *Parser) parseLine() Node {
-	switch t := p.CurrentToken.Type; t {
-	case "RESET":
+	start := p.CurrentToken.Pos
+	switch t := p.CurrentToken.Kind; t {
+	case tokLBrace:
+		return p.parseBlock()
+	case tokIf:
+		{
+			p.matchToken(tokIf)
+			cond := p.parseExpression()
+			p.matchToken(tokThen)
+			then := p.parseLine()
+			//else has to follow on the same line, e.g. after the } of then
+			var els Node
+			if p.CurrentToken.Kind == tokElse {
+				p.matchToken(tokElse)
+				els = p.parseLine()
+			}
+			return &If{
+				Cond: cond,
+				Then: then,
+				Else: els,
+				Pos:  p.span(start),
+			}
+		}
+	case tokFunc:
+		return p.parseFunc()
+	case tokReturn:
+		{
+			if p.funcs == 0 {
+				panic(&SyntaxError{Pos: p.CurrentToken.Span(), Msg: "return outside of a function"})
+			}
+			p.matchToken(tokReturn)
+			n := p.parseExpression()
+			return &Return{
+				Expr: n,
+				Pos:  p.span(start),
+			}
+		}
+	case tokWhile:
 		{
-			p.matchToken("RESET")
-			return &Reset{}
+			p.matchToken(tokWhile)
+			cond := p.parseExpression()
+			body := p.parseBlock()
+			return &While{
+				Cond: cond,
+				Body: body,
+				Pos:  p.span(start),
+			}
+		}
+	case tokReset:
+		{
+			p.matchToken(tokReset)
+			//reset x only unbinds x
+			var iden string
+			if p.CurrentToken.Kind == tokIdentifier {
+				iden = p.matchToken(tokIdentifier)
+			}
+			return &Reset{Identifier: iden, Pos: p.span(start)}
 		}
 
-	case "PRINT":
+	case tokPrint:
 		{
-			p.matchToken("PRINT")
-			n := p.parseExpression2()
+			p.matchToken(tokPrint)
+			n := p.parseExpression()
 			return &Print{
 				Expr: n,
+				Pos:  p.span(start),
 			}
 		}
-	case "TYPE":
+	case tokConst, tokType:
 		{
-
-			ty := StringTypeMap[p.matchToken("TYPE")]
-			iden := p.matchToken("IDENTIFIER")
-			p.matchToken("=")
-			n := p.parseExpression2()
+			isConst := t == tokConst
+			if isConst {
+				p.matchToken(tokConst)
+			}
+			ty := StringTypeMap[strings.ToUpper(p.matchToken(tokType))]
+			iden := p.matchToken(tokIdentifier)
+			p.matchToken(tokAssign)
+			n := p.parseExpression()
 			return &Assignment{
 				Type:       ty,
+				Const:      isConst,
+				Identifier: iden,
+				Expr:       n,
+				Pos:        p.span(start),
+			}
+		}
+	case tokIdentifier:
+		{
+			iden := p.matchToken(tokIdentifier)
+			//a call is run for what it prints
+			if p.CurrentToken.Kind == tokLParen {
+				return p.parseCall(iden, start)
+			}
+			op, ok := assignOps[p.CurrentToken.Kind]
+			if !ok {
+				panic(p.unexpected(tokAssign, tokPlusAssign, tokMinusAssign, tokStarAssign, tokSlashAssign))
+			}
+			p.matchToken(p.CurrentToken.Kind)
+			n := p.parseExpression()
+			return &Reassign{
+				Op:         op,
 				Identifier: iden,
 				Expr:       n,
+				Pos:        p.span(start),
 			}
 		}
 	}
-	panic(fmt.Sprintf("Unknown type %s", p.CurrentToken.Type))
-	return nil
+	panic(p.unexpected(tokReset, tokPrint, tokConst, tokType, tokIdentifier, tokIf, tokWhile, tokLBrace, tokFunc, tokReturn))
+}
+
+// parseFunc parses a declaration such as func f(int a, float b) float {...}
+func (p *Parser) parseFunc() Node {
+	start := p.CurrentToken.Pos
+	p.matchToken(tokFunc)
+	name := p.matchToken(tokIdentifier)
+	p.matchToken(tokLParen)
+	params := make([]string, 0)
+	types := make([]Type, 0)
+	for p.CurrentToken.Kind != tokRParen {
+		if len(params) > 0 {
+			p.matchToken(tokComma)
+		}
+		types = append(types, StringTypeMap[strings.ToUpper(p.matchToken(tokType))])
+		params = append(params, p.matchToken(tokIdentifier))
+	}
+	p.matchToken(tokRParen)
+	result := StringTypeMap[strings.ToUpper(p.matchToken(tokType))]
+
+	p.funcs++
+	defer func() { p.funcs-- }()
+	body := p.parseBlock().(*Block)
+	return &Func{
+		Name:       name,
+		Params:     params,
+		ParamTypes: types,
+		Result:     result,
+		Body:       body,
+		Pos:        p.span(start),
+	}
+}
+
+// parseCall parses the arguments of a call to the function name, the
+// current token is the (
+func (p *Parser) parseCall(name string, start Position) Node {
+	p.matchToken(tokLParen)
+	args := make([]Node, 0)
+	for p.CurrentToken.Kind != tokRParen {
+		if len(args) > 0 {
+			p.matchToken(tokComma)
+		}
+		args = append(args, p.parseExpression())
+	}
+	p.matchToken(tokRParen)
+	return &Call{
+		Name: name,
+		Args: args,
+		Pos:  p.span(start),
+	}
+}
+
+// assignOps maps an assignment to the operator it applies, = applies none
+var assignOps = map[lexer.Kind]Op{
+	tokAssign:      NOOP,
+	tokPlusAssign:  PLUS,
+	tokMinusAssign: MINUS,
+	tokStarAssign:  MULTIPLY,
+	tokSlashAssign: DIVIDE,
+}
+
+// parseExpression parses an expression, || binds loosest then && and then
+// the comparisons
+func (p *Parser) parseExpression() Node {
+	lhs := p.parseAnd()
+	for p.CurrentToken.Kind == tokOr {
+		p.matchToken(tokOr)
+		rhs := p.parseAnd()
+		lhs = &Binary2{Op: OR, Lhs: lhs, Rhs: rhs, Pos: p.span(lhs.position().Start)}
+	}
+	return lhs
+}
+
+func (p *Parser) parseAnd() Node {
+	lhs := p.parseComparison()
+	for p.CurrentToken.Kind == tokAnd {
+		p.matchToken(tokAnd)
+		rhs := p.parseComparison()
+		lhs = &Binary2{Op: AND, Lhs: lhs, Rhs: rhs, Pos: p.span(lhs.position().Start)}
+	}
+	return lhs
+}
+
+// parseComparison does not chain comparisons, a < b < c is a syntax error
+func (p *Parser) parseComparison() Node {
+	lhs := p.parseExpression2()
+	op, ok := cmpOps[p.CurrentToken.Kind]
+	if !ok {
+		return lhs
+	}
+	p.matchToken(p.CurrentToken.Kind)
+	rhs := p.parseExpression2()
+	return &Binary2{Op: op, Lhs: lhs, Rhs: rhs, Pos: p.span(lhs.position().Start)}
+}
+
+// cmpOps maps a comparison to its operator
+var cmpOps = map[lexer.Kind]Op{
+	tokEq:  EQ,
+	tokNeq: NEQ,
+	tokLt:  LT,
+	tokGt:  GT,
+	tokLte: LTE,
+	tokGte: GTE,
 }
 
 func (p *Parser) parseExpression2() Node {
@@ -149,23 +548,25 @@ func (p *Parser) parseExpression2() Node {
 	be := &Binary2{
 		Op:  NOOP,
 		Lhs: lhs,
+		Pos: lhs.position(),
 	}
 	var be2 *Binary2
-	for p.CurrentToken.Type == "+" || p.CurrentToken.Type == "-" {
-		if p.CurrentToken.Type == "+" {
-			p.matchToken("+")
+	for p.CurrentToken.Kind == tokPlus || p.CurrentToken.Kind == tokMinus {
+		if p.CurrentToken.Kind == tokPlus {
+			p.matchToken(tokPlus)
 			op = PLUS
 		} else {
-			p.matchToken("-")
+			p.matchToken(tokMinus)
 			op = MINUS
 		}
 
 		rhs := p.parseTerm2()
 		be.Op = op
 		be.Rhs = rhs
+		be.Pos = p.span(lhs.position().Start)
 
-		if p.CurrentToken.Type == "+" || p.CurrentToken.Type == "-" {
-			be2 = &Binary2{Op: NOOP}
+		if p.CurrentToken.Kind == tokPlus || p.CurrentToken.Kind == tokMinus {
+			be2 = &Binary2{Op: NOOP, Pos: be.Pos}
 			be2.Lhs = be
 			be = be2
 		}
@@ -175,27 +576,29 @@ func (p *Parser) parseExpression2() Node {
 
 func (p *Parser) parseTerm2() Node {
 	var op Op
-	lhs := p.parsePower()
+	lhs := p.parseUnary()
 	be := &Binary2{
 		Op:  NOOP,
 		Lhs: lhs,
+		Pos: lhs.position(),
 	}
 	var be2 *Binary2
-	for p.CurrentToken.Type == "*" || p.CurrentToken.Type == "/" {
-		if p.CurrentToken.Type == "*" {
-			p.matchToken("*")
+	for p.CurrentToken.Kind == tokStar || p.CurrentToken.Kind == tokSlash {
+		if p.CurrentToken.Kind == tokStar {
+			p.matchToken(tokStar)
 			op = MULTIPLY
 		} else {
-			p.matchToken("/")
+			p.matchToken(tokSlash)
 			op = DIVIDE
 		}
 
-		rhs := p.parsePower()
+		rhs := p.parseUnary()
 		be.Op = op
 		be.Rhs = rhs
+		be.Pos = p.span(lhs.position().Start)
 
-		if p.CurrentToken.Type == "*" || p.CurrentToken.Type == "/" {
-			be2 = &Binary2{Op: NOOP}
+		if p.CurrentToken.Kind == tokStar || p.CurrentToken.Kind == tokSlash {
+			be2 = &Binary2{Op: NOOP, Pos: be.Pos}
 			be2.Lhs = be
 			be = be2
 		}
@@ -204,6 +607,25 @@ func (p *Parser) parseTerm2() Node {
 	return be
 }
 
+// parseUnary binds a minus or a ! looser than **, so -2**2 is -(2**2)
+func (p *Parser) parseUnary() Node {
+	start := p.CurrentToken.Pos
+	if p.CurrentToken.Kind == tokMinus || p.CurrentToken.Kind == tokNot {
+		op := MINUS
+		if p.CurrentToken.Kind == tokNot {
+			op = NOT
+		}
+		p.matchToken(p.CurrentToken.Kind)
+		n := p.parseUnary()
+		return &Unary{
+			Op:   op,
+			Expr: n,
+			Pos:  p.span(start),
+		}
+	}
+	return p.parsePower()
+}
+
 func (p *Parser) parsePower() Node {
 
 	lhs := p.parseFactor()
@@ -211,57 +633,108 @@ func (p *Parser) parsePower() Node {
 	se := &Binary2{
 		Op:  NOOP,
 		Lhs: lhs,
+		Pos: lhs.position(),
 	}
-	for p.CurrentToken.Type == "**" {
-		p.matchToken("**")
+	for p.CurrentToken.Kind == tokPower {
+		p.matchToken(tokPower)
 
-		rhs := p.parsePower()
+		rhs := p.parseUnary()
 		se.Op = POWER
 		se.Rhs = rhs
+		se.Pos = p.span(lhs.position().Start)
 	}
 
 	return se
 }
 
 func (p *Parser) parseFactor() Node {
-	switch c := p.CurrentToken.Type; c {
-	case "IDENTIFIER":
+	start := p.CurrentToken.Pos
+	switch c := p.CurrentToken.Kind; c {
+	case tokIdentifier:
 		{
-			val := p.matchToken("IDENTIFIER")
+			val := p.matchToken(tokIdentifier)
+			if p.CurrentToken.Kind == tokLParen {
+				return p.parseCall(val, start)
+			}
 			return &Identifier{
 				Val: val,
+				Pos: p.span(start),
 			}
 		}
-	case "DECIMAL":
+	case tokDecimal:
 		{
-			flt, err := strconv.ParseFloat(p.matchToken("DECIMAL"), 64)
+			lit := p.matchToken(tokDecimal)
+			flt, err := strconv.ParseFloat(lit, 64)
 			if err != nil {
-				panic(err)
+				panic(&SyntaxError{Pos: p.span(start), Msg: err.Error()})
 			}
+			//a decimal literal keeps every digit it can, see convertible,
+			//and its exact value for when it becomes a decimal
+			dec, _ := new(big.Rat).SetString(lit)
 			return &Number{
-				Type: FLOAT,
-				Flt:  flt,
+				Type: DOUBLE,
+				Dbl:  flt,
+				Dec:  dec,
+				Pos:  p.span(start),
 			}
 		}
-	case "NUMBER":
+	case tokNumber:
 		{
-			num, err := strconv.Atoi(p.matchToken("NUMBER"))
+			lit := p.matchToken(tokNumber)
+			num, err := strconv.ParseInt(lit, 10, 64)
+			//an integer literal is an int unless it needs 64 bits or more
 			if err != nil {
-				panic(err)
+				v, _ := new(big.Int).SetString(lit, 10)
+				return &Number{
+					Type: BIGINT,
+					Big:  v,
+					Pos:  p.span(start),
+				}
+			}
+			if n, err := intNum(num); err == nil {
+				n.Pos = p.span(start)
+				return &n
 			}
 			return &Number{
-				Type: INT,
-				Num:  num,
+				Type: LONG,
+				Long: num,
+				Pos:  p.span(start),
 			}
 		}
-	case "(":
+	case tokBoolean:
 		{
-			p.matchToken("(")
-			p.parseExpression2()
-			p.matchToken(")")
+			val := p.matchToken(tokBoolean)
+			return &Number{
+				Type: BOOL,
+				Bool: val == "true",
+				Pos:  p.span(start),
+			}
+		}
+	case tokType:
+		{
+			ty := StringTypeMap[strings.ToUpper(p.matchToken(tokType))]
+			p.matchToken(tokLParen)
+			n := p.parseExpression()
+			p.matchToken(tokRParen)
+			return &Cast{
+				Type: ty,
+				Expr: n,
+				Pos:  p.span(start),
+			}
+		}
+	case tokLParen:
+		{
+			p.matchToken(tokLParen)
+			n := p.parseExpression()
+			p.matchToken(tokRParen)
+			//keep the parentheses in the span of the group
+			return &Binary2{
+				Op:  NOOP,
+				Lhs: n,
+				Pos: p.span(start),
+			}
 		}
 	}
 
-	panic(fmt.Sprintf("Unkown type %s", p.CurrentToken.Type))
-	return nil
+	panic(p.unexpected(tokIdentifier, tokDecimal, tokNumber, tokBoolean, tokType, tokLParen))
 }
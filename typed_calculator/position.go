@@ -0,0 +1,10 @@
+package typedcalculator
+
+import "lexer"
+
+// Position and Span are the lexer's, so ast nodes and errors can be built
+// from token positions directly
+type (
+	Position = lexer.Position
+	Span     = lexer.Span
+)
@@ -0,0 +1,122 @@
+package typedcalculator
+
+// scope holds the variables and functions declared in a block. The type
+// checker only uses the Type of each Number. Functions have names of their
+// own, a variable and a function can have the same name.
+type scope struct {
+	vars   map[string]Number
+	consts map[string]bool //the variables declared const
+	funcs  map[string]*function
+}
+
+func newScope() *scope {
+	return &scope{
+		vars:   make(map[string]Number),
+		consts: make(map[string]bool),
+		funcs:  make(map[string]*function),
+	}
+}
+
+// function is a declared function. The evaluator runs its body in the
+// scopes it was declared in, so the variables it uses are resolved where it
+// is declared rather than where it is called.
+type function struct {
+	decl *Func
+	env  scopes
+}
+
+// scopes is the chain of blocks being run, innermost last. The first scope
+// holds the variables of the program and is never popped.
+type scopes []*scope
+
+func newScopes() scopes {
+	return scopes{newScope()}
+}
+
+// lookup returns the innermost scope that declares name, or nil
+func (s scopes) lookup(name string) *scope {
+	for i := len(s) - 1; i >= 0; i-- {
+		if _, ok := s[i].vars[name]; ok {
+			return s[i]
+		}
+	}
+	return nil
+}
+
+// lookupFunc returns the innermost function called name, or nil
+func (s scopes) lookupFunc(name string) *function {
+	for i := len(s) - 1; i >= 0; i-- {
+		if fn, ok := s[i].funcs[name]; ok {
+			return fn
+		}
+	}
+	return nil
+}
+
+func (s scopes) innermost() *scope {
+	return s[len(s)-1]
+}
+
+// declare binds name in the innermost scope, shadowing a variable of an
+// enclosing block with the same name
+func (s scopes) declare(name string, n Number, isConst bool) {
+	in := s.innermost()
+	in.vars[name] = n
+	if isConst {
+		in.consts[name] = true
+	} else {
+		delete(in.consts, name)
+	}
+}
+
+// unbind removes the innermost variable called name, or the innermost
+// function when there is no such variable
+func (s scopes) unbind(name string) {
+	if in := s.lookup(name); in != nil {
+		delete(in.vars, name)
+		delete(in.consts, name)
+		return
+	}
+	for i := len(s) - 1; i >= 0; i-- {
+		if _, ok := s[i].funcs[name]; ok {
+			delete(s[i].funcs, name)
+			return
+		}
+	}
+}
+
+// clear removes every variable and function but keeps the blocks being
+// run. The scopes are cleared in place, as functions refer to them.
+func (s scopes) clear() {
+	for i := range s {
+		*s[i] = *newScope()
+	}
+}
+
+// copy returns scopes that declare what s declares but can be changed
+// without changing s
+func (s scopes) copy() scopes {
+	res := make(scopes, len(s))
+	for i, in := range s {
+		c := newScope()
+		for k, v := range in.vars {
+			c.vars[k] = v
+		}
+		for k := range in.consts {
+			c.consts[k] = true
+		}
+		for k, fn := range in.funcs {
+			c.funcs[k] = fn
+		}
+		res[i] = c
+	}
+	return res
+}
+
+func (s *scopes) push() {
+	*s = append(*s, newScope())
+}
+
+func (s *scopes) pop() {
+	*s = (*s)[:len(*s)-1]
+}
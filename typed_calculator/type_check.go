@@ -0,0 +1,539 @@
+package typedcalculator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TypeChecker infers the type of every expression of a program before it
+// is evaluated. It annotates each Binary2 and Unary with the type its
+// operands are changed to, and reports every type error it finds.
+//
+// The type of an expression is tracked as a Number, Fixed is set for the
+// value of a variable or a cast, see inferType. Only the Number of a literal,
+// or of an integer expression made of literals, holds a value.
+type TypeChecker struct {
+	Nm       Number  //the type of the last expression checked
+	constant bool    //whether Nm is a literal and holds its value
+	vars     scopes  //the declared type of each variable in scope
+	fn       *Func   //the function whose body is checked
+	lines    []*Line //the lines of the innermost block, see visitFuncStmt
+	errors   TypeErrorList
+}
+
+// NewTypeChecker returns a checker for a program that can use the variables
+// in vars, e.g. the ones an earlier program defined. The names in consts
+// cannot be assigned to.
+func NewTypeChecker(vars map[string]Type, consts map[string]bool) *TypeChecker {
+	t := &TypeChecker{vars: newScopes()}
+	for k, v := range vars {
+		t.vars.declare(k, Number{Type: v}, consts[k])
+	}
+	return t
+}
+
+// checkerFor returns a checker for a program that can use the variables and
+// functions in globals
+func checkerFor(globals *scope) *TypeChecker {
+	t := &TypeChecker{vars: newScopes()}
+	for k, v := range globals.vars {
+		t.vars.declare(k, Number{Type: v.Type}, globals.consts[k])
+	}
+	for k, fn := range globals.funcs {
+		t.vars[0].funcs[k] = fn
+	}
+	return t
+}
+
+// Run checks the program rooted at root. The error is a TypeErrorList
+// holding every type error in source order.
+func (t *TypeChecker) Run(root Node) error {
+	t.errors = nil
+	root.accept(t)
+	return t.errors.Err()
+}
+
+func (t *TypeChecker) addError(pos Span, format string, a ...interface{}) {
+	t.errors = append(t.errors, &TypeError{Pos: pos, Msg: fmt.Sprintf(format, a...)})
+}
+
+func (t *TypeChecker) visitProgramStmt(f *Program) {
+	t.lines = f.Lines
+	for _, l := range f.Lines {
+		l.accept(t)
+	}
+}
+
+func (t *TypeChecker) visitLineStmt(f *Line) {
+	f.Stmt.accept(t)
+}
+
+// visitAssignmentStmt declares a variable. A variable can be declared again
+// with another type, a const only once it is reset. A declaration in a block
+// shadows the variables of the enclosing blocks.
+func (t *TypeChecker) visitAssignmentStmt(f *Assignment) {
+	f.Expr.accept(t)
+	if t.vars.innermost().consts[f.Identifier] {
+		t.addError(f.Pos, "cannot assign to const %s", f.Identifier)
+	}
+	//the variable is declared even when its value is wrong, so its uses
+	//are not reported as well
+	f.Expr = t.narrow(f.Expr, f.Type, typeName(f.Type)+" "+f.Identifier, f.Pos)
+	t.vars.declare(f.Identifier, Number{Type: f.Type}, f.Const)
+}
+
+// narrow allows the value of expr, which was just checked, to be narrowed
+// to type ty when it is exactly representable in it. A literal is checked
+// here, any other value is wrapped in an exact Cast that checks it when
+// the program runs. to describes what the value is assigned to, e.g. int x.
+func (t *TypeChecker) narrow(expr Node, ty Type, to string, pos Span) Node {
+	if t.Nm.Type == NOTYPE {
+		return expr
+	}
+	if (t.Nm.Type == BOOL) != (ty == BOOL) {
+		t.addError(pos, "cannot assign %s to %s", article(t.Nm.Type), to)
+		return expr
+	}
+	if ty == DECIMAL {
+		exactDecimal(expr)
+	}
+	if _, err := convertible(t.Nm, ty); err != nil {
+		if t.constant {
+			if _, err := exactly(t.Nm, ty); err != nil {
+				t.addError(pos, "cannot assign to %s: %v", to, err)
+			}
+		}
+		return &Cast{Type: ty, Exact: true, Expr: expr, Pos: expr.position()}
+	}
+	return expr
+}
+
+// visitReassignStmt keeps the declared type of the variable. A compound
+// assignment such as x += y is checked as x + y, its Type is the type the
+// operands are changed to, and the result is narrowed back to the declared
+// type when the program runs.
+func (t *TypeChecker) visitReassignStmt(f *Reassign) {
+	f.Expr.accept(t)
+	s := t.vars.lookup(f.Identifier)
+	if s == nil {
+		t.addError(f.Pos, "unknown identifier %s", f.Identifier)
+		return
+	}
+	ty := s.vars[f.Identifier].Type
+	if s.consts[f.Identifier] {
+		t.addError(f.Pos, "cannot assign to const %s", f.Identifier)
+	}
+	if f.Op == NOOP {
+		f.Type = ty
+		f.Expr = t.narrow(f.Expr, ty, typeName(ty)+" "+f.Identifier, f.Pos)
+		return
+	}
+	if t.Nm.Type == NOTYPE {
+		f.Type = NOTYPE
+		return
+	}
+	if ty == BOOL || t.Nm.Type == BOOL {
+		t.addError(f.Pos, "operator %s= is not defined on bool", opSymbols[f.Op])
+		f.Type = NOTYPE
+		return
+	}
+	f.Type, _ = inferType(Number{Type: ty, Fixed: true}, t.Nm)
+	if f.Type == DECIMAL {
+		exactDecimal(f.Expr)
+	}
+}
+
+func (t *TypeChecker) visitPrintStmt(f *Print) {
+	f.Expr.accept(t)
+}
+
+func (t *TypeChecker) visitResetStmt(f *Reset) {
+	if f.Identifier == "" {
+		t.vars.clear()
+		return
+	}
+	if t.vars.lookup(f.Identifier) == nil && t.vars.lookupFunc(f.Identifier) == nil {
+		t.addError(f.Pos, "unknown identifier %s", f.Identifier)
+	}
+	t.vars.unbind(f.Identifier)
+}
+
+// visitBlockStmt checks the lines of a block in a scope of their own
+func (t *TypeChecker) visitBlockStmt(f *Block) {
+	lines := t.lines
+	t.vars.push()
+	t.lines = f.Lines
+	defer func() {
+		t.vars.pop()
+		t.lines = lines
+	}()
+	for _, l := range f.Lines {
+		l.accept(t)
+	}
+}
+
+// scoped checks a branch or a loop body in a scope of its own, so that a
+// variable it declares does not outlive it
+func (t *TypeChecker) scoped(n Node) {
+	lines := t.lines
+	t.vars.push()
+	t.lines = nil
+	defer func() {
+		t.vars.pop()
+		t.lines = lines
+	}()
+	n.accept(t)
+}
+
+// condition checks that the condition of an if or a while is a bool
+func (t *TypeChecker) condition(n Node) {
+	n.accept(t)
+	if t.Nm.Type != NOTYPE && t.Nm.Type != BOOL {
+		t.addError(n.position(), "condition must be a bool, got %s", article(t.Nm.Type))
+	}
+}
+
+func (t *TypeChecker) visitIfStmt(f *If) {
+	t.condition(f.Cond)
+	t.scoped(f.Then)
+	if f.Else != nil {
+		t.scoped(f.Else)
+	}
+}
+
+func (t *TypeChecker) visitWhileStmt(f *While) {
+	t.condition(f.Cond)
+	t.scoped(f.Body)
+}
+
+// visitFuncStmt declares the function before checking its body, so that it
+// can call itself. The body sees the variables declared before the function
+// and its parameters, and the functions declared before or after it in the
+// same block, so that functions can call each other. The body runs when the
+// function is called, so it is checked against a copy of the scopes that a
+// reset in it cannot change.
+func (t *TypeChecker) visitFuncStmt(f *Func) {
+	t.vars.innermost().funcs[f.Name] = &function{decl: f}
+
+	outer, vars, lines := t.fn, t.vars, t.lines
+	t.fn, t.vars = f, t.vars.copy()
+	defer func() {
+		t.fn, t.vars, t.lines = outer, vars, lines
+	}()
+	after := false
+	for _, l := range lines {
+		g, ok := l.Stmt.(*Func)
+		if after && ok && t.vars.innermost().funcs[g.Name] == nil {
+			t.vars.innermost().funcs[g.Name] = &function{decl: g}
+		}
+		after = after || g == f
+	}
+
+	t.vars.push()
+	for i, p := range f.Params {
+		t.vars.declare(p, Number{Type: f.ParamTypes[i]}, false)
+	}
+	f.Body.accept(t)
+	if !returns(f.Body) {
+		t.addError(f.Pos, "func %s does not return %s on every path", f.Name, article(f.Result))
+	}
+}
+
+// returns reports whether running n always ends with a return statement
+func returns(n Node) bool {
+	switch s := n.(type) {
+	case *Return:
+		return true
+	case *Line:
+		return returns(s.Stmt)
+	case *Block:
+		for _, l := range s.Lines {
+			if returns(l) {
+				return true
+			}
+		}
+	case *If:
+		return s.Else != nil && returns(s.Then) && returns(s.Else)
+	}
+	return false
+}
+
+// visitReturnStmt narrows the value to the result type of the function
+func (t *TypeChecker) visitReturnStmt(f *Return) {
+	f.Expr.accept(t)
+	if t.fn == nil {
+		t.addError(f.Pos, "return outside of a function")
+		return
+	}
+	f.Expr = t.narrow(f.Expr, t.fn.Result, fmt.Sprintf("the %s result of %s", typeName(t.fn.Result), t.fn.Name), f.Pos)
+}
+
+// visitCallStmt checks the arguments against the parameters of the
+// function, each is narrowed to the type of its parameter like a value
+// assigned to a variable. The call is fixed to the result type.
+func (t *TypeChecker) visitCallStmt(f *Call) {
+	fn := t.vars.lookupFunc(f.Name)
+	if fn == nil {
+		for _, a := range f.Args {
+			a.accept(t)
+		}
+		t.addError(f.Pos, "unknown function %s", f.Name)
+		f.Type = NOTYPE
+		t.Nm = Number{}
+		t.constant = false
+		return
+	}
+
+	d := fn.decl
+	if len(f.Args) != len(d.Params) {
+		t.addError(f.Pos, "%s takes %d arguments got %d", f.Name, len(d.Params), len(f.Args))
+	}
+	for i, a := range f.Args {
+		a.accept(t)
+		if i < len(d.Params) {
+			to := fmt.Sprintf("%s parameter %s of %s", typeName(d.ParamTypes[i]), d.Params[i], f.Name)
+			f.Args[i] = t.narrow(a, d.ParamTypes[i], to, a.position())
+		}
+	}
+	f.Type = d.Result
+	t.Nm = Number{Type: d.Result, Fixed: true}
+	t.constant = false
+}
+
+// visitBinary2Stmt annotates an arithmetic operator or a comparison with
+// the type its operands are changed to. A comparison is a BOOL, and && and
+// || take BOOL operands.
+func (t *TypeChecker) visitBinary2Stmt(f *Binary2) {
+	f.Lhs.accept(t)
+	lhs, constant := t.Nm, t.constant
+	if f.Op == NOOP {
+		f.Type = lhs.Type
+		return
+	}
+
+	f.Rhs.accept(t)
+	rhs := t.Nm
+	constant = constant && t.constant
+
+	//an operand with an error has no type, it was reported already
+	if lhs.Type == NOTYPE || rhs.Type == NOTYPE {
+		f.Type = NOTYPE
+		t.Nm = Number{}
+		return
+	}
+	t.constant = false
+
+	switch f.Op {
+	case AND, OR:
+		if lhs.Type != BOOL || rhs.Type != BOOL {
+			t.mismatch(f, lhs.Type, rhs.Type)
+			return
+		}
+		f.Type = BOOL
+	case EQ, NEQ, LT, GT, LTE, GTE:
+		if lhs.Type == BOOL && rhs.Type == BOOL && (f.Op == EQ || f.Op == NEQ) {
+			f.Type = BOOL
+		} else if isNumber(lhs.Type) && isNumber(rhs.Type) {
+			f.Type, _ = inferType(lhs, rhs)
+			if f.Type == DECIMAL {
+				exactDecimal(f.Lhs)
+				exactDecimal(f.Rhs)
+			}
+		} else {
+			t.mismatch(f, lhs.Type, rhs.Type)
+			return
+		}
+		t.Nm = Number{Type: BOOL}
+		return
+	default:
+		if !isNumber(lhs.Type) || !isNumber(rhs.Type) {
+			t.mismatch(f, lhs.Type, rhs.Type)
+			return
+		}
+		ty, err := inferType(lhs, rhs)
+		if err != nil {
+			t.addError(f.Pos, "%v", err)
+		}
+		f.Type = ty
+		if ty == DECIMAL {
+			exactDecimal(f.Lhs)
+			exactDecimal(f.Rhs)
+		}
+		if !constant {
+			break
+		}
+		if res, ok := constantInteger(f.Op, lhs, rhs, ty); ok {
+			f.Type = res.Type
+			t.Nm = res
+			t.constant = true
+			return
+		}
+	}
+	t.Nm = Number{Type: f.Type}
+}
+
+// constantInteger computes op on two integer literals, or on expressions
+// made only of them, exactly. The result has the narrowest integer type
+// that holds it but none narrower than ty, so 2**62 is a long rather than
+// an int that overflows. It fails when op would, e.g. when dividing by
+// zero, so the evaluator reports it.
+func constantInteger(op Op, lhs Number, rhs Number, ty Type) (Number, bool) {
+	if ty != INT && ty != LONG && ty != BIGINT {
+		return Number{}, false
+	}
+	res, err := applyOp(op, changeType(lhs, BIGINT), changeType(rhs, BIGINT), DefaultDecimalPlaces)
+	if err != nil {
+		return Number{}, false
+	}
+	switch {
+	case ty == INT && res.Big.IsInt64():
+		if n, err := intNum(res.Big.Int64()); err == nil {
+			return n, true
+		}
+		fallthrough
+	case ty != BIGINT && res.Big.IsInt64():
+		return Number{Type: LONG, Long: res.Big.Int64()}, true
+	}
+	return res, true
+}
+
+// exactDecimal computes the floating point operators of n as decimals when
+// n is made only of literals and is changed to a decimal, so that it is
+// exact like a decimal literal is: decimal d = 0.1 + 0.2 is 0.3. A power is
+// only computed as a decimal when its exponent is an integer, and integer
+// operators are left alone, so 1 / 2 is still 0.
+func exactDecimal(n Node) {
+	if literalExpr(n) {
+		toDecimal(n)
+	}
+}
+
+// literalExpr reports whether n is an arithmetic expression of literals
+func literalExpr(n Node) bool {
+	switch f := n.(type) {
+	case *Number:
+		return true
+	case *Binary2:
+		return literalExpr(f.Lhs) && (f.Rhs == nil || literalExpr(f.Rhs))
+	case *Unary:
+		return f.Op == MINUS && literalExpr(f.Expr)
+	}
+	return false
+}
+
+func toDecimal(n Node) {
+	switch f := n.(type) {
+	case *Binary2:
+		if !isFloat(f.Type) || f.Op == POWER && !isInteger(exprType(f.Rhs)) {
+			return
+		}
+		f.Type = DECIMAL
+		toDecimal(f.Lhs)
+		if f.Rhs != nil {
+			toDecimal(f.Rhs)
+		}
+	case *Unary:
+		if isFloat(f.Type) {
+			f.Type = DECIMAL
+			toDecimal(f.Expr)
+		}
+	}
+}
+
+// exprType returns the type the checker gave n, an expression of literals
+func exprType(n Node) Type {
+	switch f := n.(type) {
+	case *Number:
+		return f.Type
+	case *Binary2:
+		return f.Type
+	case *Unary:
+		return f.Type
+	}
+	return NOTYPE
+}
+
+// mismatch reports an operator that is not defined on the types of its
+// operands
+func (t *TypeChecker) mismatch(f *Binary2, lhs Type, rhs Type) {
+	t.addError(f.Pos, "operator %s is not defined on %s and %s", opSymbols[f.Op], typeName(lhs), typeName(rhs))
+	f.Type = NOTYPE
+	t.Nm = Number{}
+}
+
+// visitUnaryStmt checks that - negates a number and ! a bool
+func (t *TypeChecker) visitUnaryStmt(f *Unary) {
+	f.Expr.accept(t)
+	f.Type = t.Nm.Type
+	constant := t.constant
+	t.constant = false
+	if f.Type == NOTYPE {
+		return
+	}
+	if (f.Op == NOT) != (f.Type == BOOL) {
+		t.addError(f.Pos, "operator %s is not defined on %s", opSymbols[f.Op], typeName(f.Type))
+		f.Type = NOTYPE
+		t.Nm = Number{}
+		return
+	}
+	//a negated integer literal stays a literal of its type
+	if !constant {
+		return
+	}
+	if res, ok := constantInteger(MINUS, Number{Type: INT}, t.Nm, f.Type); ok && res.Type == f.Type {
+		t.Nm = res
+		t.constant = true
+	}
+}
+
+// visitCastStmt gives the value the type of the cast, fixed like the value
+// of a variable
+func (t *TypeChecker) visitCastStmt(f *Cast) {
+	f.Expr.accept(t)
+	if t.Nm.Type != NOTYPE {
+		if (t.Nm.Type == BOOL) != (f.Type == BOOL) {
+			t.addError(f.Pos, "cannot cast %s to %s", article(t.Nm.Type), typeName(f.Type))
+		}
+		t.Nm = Number{Type: f.Type, Fixed: true}
+	}
+	t.constant = false
+}
+
+func (t *TypeChecker) visitIdentifierStmt(f *Identifier) {
+	t.Nm = Number{}
+	t.constant = false
+	s := t.vars.lookup(f.Val)
+	if s == nil {
+		t.addError(f.Pos, "unknown identifier %s", f.Val)
+		return
+	}
+	t.Nm = Number{Type: s.vars[f.Val].Type, Fixed: true}
+}
+
+func (t *TypeChecker) visitNumberStmt(f *Number) {
+	t.Nm = *f
+	t.constant = true
+}
+
+// opSymbols is how each operator is written
+var opSymbols = map[Op]string{
+	PLUS:     "+",
+	MINUS:    "-",
+	MULTIPLY: "*",
+	DIVIDE:   "/",
+	POWER:    "**",
+	EQ:       "==",
+	NEQ:      "!=",
+	LT:       "<",
+	GT:       ">",
+	LTE:      "<=",
+	GTE:      ">=",
+	AND:      "&&",
+	OR:       "||",
+	NOT:      "!",
+}
+
+// typeName is the keyword of a type, e.g. int
+func typeName(t Type) string {
+	return strings.ToLower(TypeStringMap[t])
+}